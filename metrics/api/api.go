@@ -19,6 +19,8 @@
 //Package api contains the public interface
 package api
 
+import "context"
+
 // Stopwatch measures elapsed time
 type Stopwatch interface {
 	ElapsedMillis() float64
@@ -31,15 +33,87 @@ type Distribution interface {
 	Max() float64
 	Avg() float64
 	StdDev() float64
+	// Percentile returns the value below which a fraction q (0 <= q <= 1) of the samples fall, Percentile(0.99)
+	// returns the 99th percentile. Implementations may estimate this value.
+	Percentile(q float64) float64
+}
+
+// Counter is a handle to a counter that is registered once and can then be updated without looking up its key
+type Counter interface {
+	Inc()
+	Dec()
+	Add(value int64)
+}
+
+// Timer is a handle to a duration distribution that is registered once and can then be recorded into without looking
+// up its key
+type Timer interface {
+	// Record adds a duration in millis
+	Record(millis float64)
+	// Time runs the subject function and records its duration, returns the recorded millis
+	Time(subject func()) float64
+	// Start starts timing, the duration is recorded when Stop is called on the result
+	Start() TimerContext
+}
+
+// TimerContext is a single timing started with Timer.Start
+type TimerContext interface {
+	// Stop records the elapsed time since the start and returns the recorded millis
+	Stop() float64
+}
+
+// Histogram is a handle to a sample distribution that is registered once and can then be recorded into without
+// looking up its key
+type Histogram interface {
+	Record(value float64)
+}
+
+// ErrorClassifier returns the suffix under which a failure with err is recorded by MeasureFuncErr, for example timeout
+// or notfound. An empty suffix is recorded as error.
+type ErrorClassifier func(err error) string
+
+// Cursor returns what changed in a facade since its previous read, without resetting the facade
+type Cursor interface {
+	// Next returns a snapshot with the counter differences and the new samples since the previous call to Next
+	Next() Snapshot
+}
+
+// Meter models the rate of events, rates are in events per second
+type Meter interface {
+	Count() int64
+	// Rate1, Rate5 and Rate15 return the exponentially weighted moving average rate over the last 1, 5 and 15 minutes
+	Rate1() float64
+	Rate5() float64
+	Rate15() float64
+	// RateMean returns the mean rate since the meter was created
+	RateMean() float64
+	// StartedTimestamp returns the timestamp (millis since epoch) on which the meter was created, the count is the
+	// number of events since then. It returns 0 when the creation time is unknown, like for meters read from json
+	// written without it.
+	StartedTimestamp() int64
+}
+
+// Tags add dimensions to a counter, duration or sample, for example {"method": "GET", "status": "200"}
+type Tags map[string]string
+
+// Series identifies a counter, duration or sample by its key and tags
+type Series struct {
+	Key  string `json:"key"`
+	Tags Tags   `json:"tags"`
 }
 
-// Snapshot resembles an internal snapshot of the data
+// Snapshot resembles an internal snapshot of the data. Tagged counters, durations and samples are stored under
+// their series key, which is the key followed by the sorted tags, for example http.requests{method=GET,status=200}
 type Snapshot interface {
 	CreatedTimestamp() int64
 	StartedTimestamp() int64
 	Durations() map[string]Distribution
 	Counters() map[string]int64
 	Samples() map[string]Distribution
+	Gauges() map[string]float64
+	Meters() map[string]Meter
+	// Series returns the key and tags of every tagged counter, duration and sample, indexed by series key
+	Series() map[string]Series
 }
 
 // Facade is the end-user interface for adding, removing and querying data
@@ -59,6 +133,28 @@ type Facade interface {
 	// itself will panic with the same error as the inner function.
 	MeasureFuncCanPanic(key string, subject func()) float64
 
+	// MeasureFuncErr runs the subject function and records its duration under key when it succeeds, or under key with
+	// the suffix of the ErrorClassifier appended when it returns an error, for example key.error or key.timeout. The
+	// counter key.ok or the counter with the same suffix is incremented as well. Returns the recorded millis and the
+	// error of subject
+	MeasureFuncErr(key string, subject func() error) (float64, error)
+
+	// SetErrorClassifier sets the classifier that determines the suffix of failed MeasureFuncErr calls, nil records
+	// every failure as error
+	SetErrorClassifier(classifier ErrorClassifier)
+
+	// StartStopwatchCtx starts a stopwatch that stops when ctx is done, record it with RecordElapsedTimeCtx
+	StartStopwatchCtx(ctx context.Context) Stopwatch
+
+	// RecordElapsedTimeCtx records the elapsed time of the stopwatch under key with the outcome of ctx and err
+	// appended: .ok, .error, .canceled or .deadline_exceeded. Returns the recorded millis
+	RecordElapsedTimeCtx(ctx context.Context, key string, stopwatch Stopwatch, err error) float64
+
+	// MeasureFuncCtx runs subject with ctx and records its duration under key with the outcome appended, see
+	// RecordElapsedTimeCtx. The timing stops when ctx is done, even when subject returns later. Returns the recorded
+	// millis and the error of subject
+	MeasureFuncCtx(ctx context.Context, key string, subject func(ctx context.Context) error) (float64, error)
+
 	// Increments the counter identified with key by 1. If the counter does not yet exist, it will be created
 	// with initial value of 1
 	IncrementCounter(key string)
@@ -71,15 +167,61 @@ type Facade interface {
 	// and initialized to value. Value can be negative.
 	AddToCounter(key string, value int64)
 
+	// Returns a handle to the counter identified by key. The handle updates the counter with atomic operations,
+	// without a lock or lookup, and keeps working after a reset. Registered counters are always part of a snapshot,
+	// even when they are 0. The values of a handle and of AddToCounter with the same key are added up.
+	Counter(key string) Counter
+
+	// Returns a handle to the duration distribution identified by key. The handle keeps working after a reset, it then
+	// records into a new distribution.
+	Timer(key string) Timer
+
+	// Returns a handle to the sample distribution identified by key. The handle keeps working after a reset, it then
+	// records into a new distribution.
+	Histogram(key string) Histogram
+
 	// Adds a value to the sample distribution identified by key. If the distribution does not yet exist, value will be it's initial value.
 	AddSample(key string, value float64)
 
-	// Clears all durations, counters and samples
+	// The methods below are equal to the ones above, but record into the series identified by key and tags. A series
+	// without tags is the same as the key itself.
+
+	RecordElapsedTimeWithTags(key string, tags Tags, stopwatch Stopwatch) float64
+	MeasureFuncWithTags(key string, tags Tags, subject func()) float64
+	IncrementCounterWithTags(key string, tags Tags)
+	DecrementCounterWithTags(key string, tags Tags)
+	AddToCounterWithTags(key string, tags Tags, value int64)
+	AddSampleWithTags(key string, tags Tags, value float64)
+
+	// Sets the gauge identified by key to value, replacing its previous value.
+	SetGauge(key string, value float64)
+
+	// Registers a function that returns the value of the gauge identified by key. The function is called every
+	// time a snapshot is created, and replaces any value set with SetGauge.
+	RegisterGaugeFunc(key string, gauge func() float64)
+
+	// Marks the occurrence of n events on the meter identified by key. If the meter does not yet exist, it will be
+	// created and starts measuring the rate from now on.
+	MarkMeter(key string, n int64)
+
+	// Equal to SetGauge and MarkMeter, but set the gauge or mark the meter identified by key and tags.
+
+	SetGaugeWithTags(key string, tags Tags, value float64)
+	MarkMeterWithTags(key string, tags Tags, n int64)
+
+	// Clears all durations, counters and samples. Gauges and meters are not affected, gauges always reflect the
+	// latest value and meters keep their moving averages over time.
 	Reset()
 
-	// Creates a snapshot containing all currently registered durations, counters and samples
+	// Creates a snapshot containing all currently registered durations, counters, samples, gauges and meters
 	Snapshot() Snapshot
 
+	// Creates a cursor that returns what changed since its previous read, without resetting. Every consumer can have
+	// its own cursor, so they do not interfere with each other. The first read returns what changed since the cursor
+	// was created. Cursors subtract cumulative distributions, so they do not work with distributions that forget
+	// samples, such as windowed distributions.
+	Cursor() Cursor
+
 	// Creates a snapshot and then calls Reset()
 	// Also, see documentation above
 	SnapshotAndReset() Snapshot
@@ -0,0 +1,47 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package channelbased
+
+import (
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// Facade provides a nice API on the channelbased store, see common.Facade
+type Facade struct {
+	*common.Facade
+	store *Store
+}
+
+// NewFacade creates a new facade initialized with the store
+func NewFacade(store *Store) *Facade {
+	if store == nil {
+		panic("store = nil, Facade needs a store")
+	}
+	return &Facade{Facade: common.NewFacade(store), store: store}
+}
+
+// Dropped returns the number of recordings that were dropped because the buffer of the store was full
+func (facade *Facade) Dropped() int64 {
+	return facade.store.Dropped()
+}
+
+// Close stops the store after processing the queued recordings, see Store.Close
+func (facade *Facade) Close() {
+	facade.store.Close()
+}
@@ -0,0 +1,77 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package channelbased
+
+import (
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common/commontest"
+)
+
+func TestFacadeBehaviour(t *testing.T) {
+	commontest.RunFacadeTests(t, func() api.Facade {
+		facade := NewFacade(NewStore())
+		t.Cleanup(facade.Close)
+		return facade
+	})
+}
+
+func TestDroppingFacadeBehaviour(t *testing.T) {
+	commontest.RunFacadeTests(t, func() api.Facade {
+		facade := NewFacade(NewStoreWithConfig(Config{BufferSize: 100000, DropWhenFull: true}))
+		t.Cleanup(facade.Close)
+		return facade
+	})
+}
+
+func TestNewFacadeWithNilStore(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewFacade should panic when store=nil, but no panic")
+		}
+	}()
+	NewFacade(nil)
+}
+
+func TestFacadeDroppedAndClose(t *testing.T) {
+	facade := NewFacade(NewStoreWithConfig(Config{BufferSize: 1, DropWhenFull: true}))
+	facade.Close()
+	facade.IncrementCounter("ignored")
+	if facade.Dropped() != 0 {
+		t.Error("recordings after Close should be ignored, not dropped")
+	}
+	if len(facade.Snapshot().Counters()) != 0 {
+		t.Error("a closed store should return an empty snapshot")
+	}
+}
+
+func BenchmarkFacadeParallelAll(b *testing.B) {
+	facade := NewFacade(NewStore())
+	defer facade.Close()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			facade.MeasureFunc("some.func", func() {
+				facade.DecrementCounter("some.counter")
+				facade.AddSample("some.sample", 333.333)
+				facade.IncrementCounter("some.counter")
+			})
+		}
+	})
+}
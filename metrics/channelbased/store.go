@@ -0,0 +1,297 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package channelbased contains a store that is owned by a single go-routine. Recordings are sent to that go-routine
+// over a buffered channel, so recording never waits for a lock.
+package channelbased
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// DefaultBufferSize is the number of recordings that can be queued when Config.BufferSize is 0
+const DefaultBufferSize = 1024
+
+// Config configures a Store, fields with a zero value get a default
+type Config struct {
+	// BufferSize is the number of recordings that can be queued before recording blocks or drops, defaults to
+	// DefaultBufferSize
+	BufferSize int
+	// DropWhenFull drops recordings when the buffer is full instead of waiting for room, see Store.Dropped.
+	// Snapshots, resets and gauge functions are never dropped.
+	DropWhenFull bool
+	// NewDuration creates the distributions of new durations, defaults to common.DefaultDistributionFactory
+	NewDuration common.DistributionFactory
+	// NewSample creates the distributions of new samples, defaults to common.DefaultDistributionFactory
+	NewSample common.DistributionFactory
+}
+
+// Store holds the state of the channelbased implementation. All state is owned by a single go-routine that
+// executes the commands it receives on the commands channel one by one. Store is the common.Backend of a Facade.
+type Store struct {
+	// state is only used by the go-routine, except for its counter cells, which are updated by counter handles
+	state        *common.State
+	dropWhenFull bool
+
+	commands  chan command
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   int64
+}
+
+type commandType int
+
+const (
+	addDuration commandType = iota
+	addSample
+	addToHandle
+	addToCounter
+	setGauge
+	markMeter
+	registerGaugeFunc
+	registerCounter
+	takeSnapshot
+	takeSnapshotAndReset
+	reset
+	stop
+)
+
+type command struct {
+	commandType commandType
+	key         string
+	value       float64
+	count       int64
+	gauge       func() float64
+	reply       chan snapshotReply
+	counter     chan *common.AtomicCounter
+	handle      *common.DistributionHandle
+}
+
+// snapshotReply contains a snapshot without gauge functions, those are evaluated by the requester
+type snapshotReply struct {
+	snapshot   *common.Snapshot
+	gaugeFuncs map[string]func() float64
+}
+
+// NewStore creates a new store with the default configuration and starts a go-routine that listens for requests on
+// the channels. Call Close to stop the go-routine.
+func NewStore() *Store {
+	return NewStoreWithConfig(Config{})
+}
+
+// NewStoreWithConfig creates a new store with the given configuration and starts a go-routine that listens for
+// requests on the channels. Call Close to stop the go-routine. Panics if the buffer size is negative.
+func NewStoreWithConfig(config Config) *Store {
+	if config.BufferSize < 0 {
+		panic(fmt.Sprint("buffer size must be >= 0 but was ", config.BufferSize))
+	}
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+	if config.NewDuration == nil {
+		config.NewDuration = common.DefaultDistributionFactory
+	}
+	if config.NewSample == nil {
+		config.NewSample = common.DefaultDistributionFactory
+	}
+	store := &Store{
+		state:        common.NewState(config.NewDuration, config.NewSample),
+		dropWhenFull: config.DropWhenFull,
+		commands:     make(chan command, config.BufferSize),
+		done:         make(chan struct{}),
+	}
+	go store.run()
+	log.Println("[METRICS] created new channelbased store")
+	return store
+}
+
+func (store *Store) run() {
+	defer close(store.done)
+	for cmd := range store.commands {
+		switch cmd.commandType {
+		case addDuration:
+			store.state.AddDuration(cmd.key, cmd.value)
+		case addSample:
+			store.state.AddSample(cmd.key, cmd.value)
+		case addToHandle:
+			store.state.RecordTo(cmd.handle, cmd.value)
+		case addToCounter:
+			store.state.AddToCounter(cmd.key, cmd.count)
+		case setGauge:
+			store.state.SetGauge(cmd.key, cmd.value)
+		case markMeter:
+			store.state.MarkMeter(cmd.key, cmd.count)
+		case registerGaugeFunc:
+			store.state.RegisterGaugeFunc(cmd.key, cmd.gauge)
+		case registerCounter:
+			cmd.counter <- store.state.CounterCell(cmd.key)
+		case takeSnapshot:
+			cmd.reply <- snapshotReply{snapshot: store.state.Snapshot(), gaugeFuncs: store.state.GaugeFuncs()}
+		case takeSnapshotAndReset:
+			cmd.reply <- snapshotReply{snapshot: store.state.SnapshotAndReset(), gaugeFuncs: store.state.GaugeFuncs()}
+		case reset:
+			store.state.Reset()
+		case stop:
+			return
+		}
+	}
+}
+
+// record sends a recording to the go-routine, or drops it when the buffer is full and the store drops when full.
+// Recordings are ignored once the store is closed.
+func (store *Store) record(cmd command) {
+	if !store.dropWhenFull {
+		store.send(cmd)
+		return
+	}
+	select {
+	case store.commands <- cmd:
+	case <-store.done:
+	default:
+		atomic.AddInt64(&store.dropped, 1)
+	}
+}
+
+// send waits until the command is accepted, returns false if the store is closed
+func (store *Store) send(cmd command) bool {
+	select {
+	case store.commands <- cmd:
+		return true
+	case <-store.done:
+		return false
+	}
+}
+
+// AddSample adds value to the sample distribution identified by key
+func (store *Store) AddSample(key string, value float64) {
+	store.record(command{commandType: addSample, key: key, value: value})
+}
+
+// AddDuration adds value to the duration distribution identified by key
+func (store *Store) AddDuration(key string, value float64) {
+	store.record(command{commandType: addDuration, key: key, value: value})
+}
+
+// DurationHandle returns a function that records into the duration distribution identified by key
+func (store *Store) DurationHandle(key string) func(value float64) {
+	handle := common.NewDurationHandle(key)
+	return func(value float64) {
+		store.record(command{commandType: addToHandle, handle: handle, value: value})
+	}
+}
+
+// SampleHandle returns a function that records into the sample distribution identified by key
+func (store *Store) SampleHandle(key string) func(value float64) {
+	handle := common.NewSampleHandle(key)
+	return func(value float64) {
+		store.record(command{commandType: addToHandle, handle: handle, value: value})
+	}
+}
+
+// AddToCounter adds value to the counter identified by key
+func (store *Store) AddToCounter(key string, value int64) {
+	store.record(command{commandType: addToCounter, key: key, count: value})
+}
+
+// SetGauge sets the gauge identified by key to value
+func (store *Store) SetGauge(key string, value float64) {
+	store.record(command{commandType: setGauge, key: key, value: value})
+}
+
+// MarkMeter marks n events on the meter identified by key
+func (store *Store) MarkMeter(key string, n int64) {
+	store.record(command{commandType: markMeter, key: key, count: n})
+}
+
+// RegisterGaugeFunc registers a function that determines the value of the gauge identified by key on every snapshot
+func (store *Store) RegisterGaugeFunc(key string, gauge func() float64) {
+	store.send(command{commandType: registerGaugeFunc, key: key, gauge: gauge})
+}
+
+// CounterCell returns the cell of the counter identified by key, which is updated without going through the go-routine.
+// When the store is closed, the returned cell is not part of the store.
+func (store *Store) CounterCell(key string) *common.AtomicCounter {
+	cells := make(chan *common.AtomicCounter, 1)
+	if store.send(command{commandType: registerCounter, key: key, counter: cells}) {
+		select {
+		case cell := <-cells:
+			return cell
+		case <-store.done:
+		}
+	}
+	return &common.AtomicCounter{}
+}
+
+// Dropped returns the number of recordings that were dropped because the buffer was full
+func (store *Store) Dropped() int64 {
+	return atomic.LoadInt64(&store.dropped)
+}
+
+// Snapshot creates a new snapshot of the current state. The snapshot contains all recordings that the calling
+// go-routine made before. Returns an empty snapshot when the store is closed.
+func (store *Store) Snapshot() api.Snapshot {
+	return store.requestSnapshot(takeSnapshot)
+}
+
+// SnapshotAndReset creates a snapshot and clears the recorded counters, durations and samples, gauges and meters
+// are kept
+func (store *Store) SnapshotAndReset() api.Snapshot {
+	return store.requestSnapshot(takeSnapshotAndReset)
+}
+
+// Reset clears the recorded counters, durations and samples, gauges and meters are kept
+func (store *Store) Reset() {
+	store.send(command{commandType: reset})
+}
+
+// Close processes the recordings that are still queued and stops the go-routine of the store. Recordings made after
+// Close are ignored. Close can be called more than once.
+func (store *Store) Close() {
+	store.closeOnce.Do(func() {
+		store.send(command{commandType: stop})
+		<-store.done
+		log.Println("[METRICS] closed channelbased store")
+	})
+}
+
+func (store *Store) requestSnapshot(commandType commandType) api.Snapshot {
+	replies := make(chan snapshotReply, 1)
+	if store.send(command{commandType: commandType, reply: replies}) {
+		select {
+		case reply := <-replies:
+			// gauge functions are evaluated by the requester, so they can use the store themselves
+			common.EvaluateGaugeFuncs(reply.snapshot, reply.gaugeFuncs)
+			return reply.snapshot
+		case <-store.done:
+		}
+	}
+	now := common.CurrentTimeMillis()
+	return &common.Snapshot{
+		TimestampStarted:  now,
+		TimestampCreated:  now,
+		DurationsSnapshot: make(map[string]api.Distribution),
+		CountersSnapshot:  make(map[string]int64),
+		SamplesSnapshot:   make(map[string]api.Distribution),
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package channelbased
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/common/commontest"
+)
+
+func TestStoreDropsWhenFull(t *testing.T) {
+	store := NewStoreWithConfig(Config{BufferSize: 10, DropWhenFull: true})
+	defer store.Close()
+	// a snapshot request whose reply is not read blocks the go-routine of the store
+	blockingReplies := make(chan snapshotReply)
+	store.commands <- command{commandType: takeSnapshot, reply: blockingReplies}
+	for len(store.commands) > 0 {
+		runtime.Gosched()
+	}
+	for i := 0; i < 100; i++ {
+		store.AddToCounter("counter", 1)
+	}
+	if store.Dropped() != 90 {
+		t.Error("expected 90 dropped recordings but got", store.Dropped())
+	}
+	<-blockingReplies
+	if counter := store.Snapshot().Counters()["counter"]; counter != 10 {
+		t.Error("expected the 10 buffered recordings to be counted but got", counter)
+	}
+}
+
+func TestStoreClose(t *testing.T) {
+	store := NewStore()
+	for i := 0; i < 100; i++ {
+		store.AddToCounter("counter", 1)
+	}
+	store.Close()
+	store.Close()
+	store.AddSample("after.close", 1)
+	if store.Dropped() != 0 {
+		t.Error("recordings after Close should not be counted as dropped")
+	}
+	snapshot := store.SnapshotAndReset()
+	if len(snapshot.Counters())+len(snapshot.Samples()) != 0 {
+		t.Error("a closed store should return an empty snapshot")
+	}
+}
+
+func TestStoreWithHdrDurations(t *testing.T) {
+	store := NewStoreWithConfig(Config{NewDuration: common.HdrDistributionFactory(0.001, 60000, 3)})
+	defer store.Close()
+	store.AddDuration("duration", 15)
+	store.AddSample("sample", 15)
+	snapshot := store.Snapshot()
+	if _, isHdr := snapshot.Durations()["duration"].(*common.HdrDistribution); !isHdr {
+		t.Error("expected durations to be recorded in a HdrDistribution")
+	}
+	if _, isDefault := snapshot.Samples()["sample"].(*common.Distribution); !isDefault {
+		t.Error("expected samples to be recorded in a Distribution")
+	}
+	commontest.AssertDistributionHasValues(snapshot.Durations()["duration"], 1, 15, 15, 15, 0, t)
+}
+
+func TestNewStoreWithNegativeBufferSize(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewStoreWithConfig should panic when the buffer size is negative, but no panic")
+		}
+	}()
+	NewStoreWithConfig(Config{BufferSize: -1})
+}
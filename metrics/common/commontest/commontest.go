@@ -28,7 +28,7 @@ import (
 //AssertDistributionHasValues checks if the distribution contains the expected values
 func AssertDistributionHasValues(dist api.Distribution, sampleCount int64, min, max, avg, stdDev float64, t *testing.T) {
 	if dist == nil {
-		t.Error("dist is nil, the value is not present (look for a Reset() or SnapshotAndReset() on the channelbased)")
+		t.Error("dist is nil, the value is not present (look for a Reset() or SnapshotAndReset() on the store)")
 		return
 	}
 	if dist.SampleCount() != sampleCount {
@@ -0,0 +1,456 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package commontest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// RunFacadeTests runs the behavioural tests that every api.Facade implementation must pass. newFacade must return a
+// new facade with an empty store every time it is called.
+func RunFacadeTests(t *testing.T, newFacade func() api.Facade) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, facade api.Facade)
+	}{
+		{"Counters", testCounters},
+		{"CounterHandles", testCounterHandles},
+		{"CounterHandlesLoseNoUpdatesOnReset", testCounterHandlesLoseNoUpdatesOnReset},
+		{"Samples", testSamples},
+		{"Handles", testHandles},
+		{"Durations", testDurations},
+		{"MeasureFuncCanPanic", testMeasureFuncCanPanic},
+		{"MeasureFuncErr", testMeasureFuncErr},
+		{"MeasureFuncCtx", testMeasureFuncCtx},
+		{"MeasureFuncCtxStopsAtCancellation", testMeasureFuncCtxStopsAtCancellation},
+		{"Tags", testTags},
+		{"Gauges", testGauges},
+		{"NonFiniteGaugesAreLeftOutOfJSON", testNonFiniteGaugesAreLeftOutOfJSON},
+		{"Meters", testMeters},
+		{"Reset", testReset},
+		{"SnapshotAndReset", testSnapshotAndReset},
+		{"SnapshotsAreDisconnected", testSnapshotsAreDisconnected},
+		{"Cursors", testCursors},
+		{"Concurrency", testConcurrency},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newFacade())
+		})
+	}
+}
+
+func testCounters(t *testing.T, facade api.Facade) {
+	facade.IncrementCounter("counter")
+	facade.IncrementCounter("counter")
+	facade.DecrementCounter("counter")
+	facade.AddToCounter("counter", 10)
+	facade.DecrementCounter("negative")
+	snapshot := facade.Snapshot()
+	if snapshot.Counters()["counter"] != 11 || snapshot.Counters()["negative"] != -1 {
+		t.Errorf("expected counter=11 and negative=-1 but got %v", snapshot.Counters())
+	}
+}
+
+func testCounterHandles(t *testing.T, facade api.Facade) {
+	counter := facade.Counter("counter")
+	counter.Inc()
+	counter.Inc()
+	counter.Dec()
+	counter.Add(10)
+	facade.Counter("counter").Inc()
+	facade.IncrementCounter("counter")
+	facade.Counter("unused")
+	snapshot := facade.SnapshotAndReset()
+	if snapshot.Counters()["counter"] != 13 {
+		t.Error("expected handles and AddToCounter to be added up to 13 but got", snapshot.Counters()["counter"])
+	}
+	if value, exists := snapshot.Counters()["unused"]; !exists || value != 0 {
+		t.Error("a registered counter should be part of the snapshot")
+	}
+	counter.Inc()
+	if facade.Snapshot().Counters()["counter"] != 1 {
+		t.Error("a handle should keep working after a reset, expected 1 but got", facade.Snapshot().Counters()["counter"])
+	}
+	facade.Reset()
+	if facade.Snapshot().Counters()["counter"] != 0 {
+		t.Error("a reset should zero the counter of a handle")
+	}
+}
+
+func testCounterHandlesLoseNoUpdatesOnReset(t *testing.T, facade api.Facade) {
+	counter := facade.Counter("counter")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				counter.Inc()
+			}
+		}()
+	}
+	total := int64(0)
+	for i := 0; i < 20; i++ {
+		total += facade.SnapshotAndReset().Counters()["counter"]
+	}
+	wg.Wait()
+	total += facade.SnapshotAndReset().Counters()["counter"]
+	if total != 100000 {
+		t.Error("expected 100000 increments in all snapshots together but got", total)
+	}
+}
+
+func testSamples(t *testing.T, facade api.Facade) {
+	for i := 1; i <= 10; i++ {
+		facade.AddSample("sample", float64(i))
+	}
+	AssertDistributionHasValues(facade.Snapshot().Samples()["sample"], 10, 1, 10, 5.5, 3.0276, t)
+}
+
+func testHandles(t *testing.T, facade api.Facade) {
+	timer := facade.Timer("timer")
+	histogram := facade.Histogram("histogram")
+	timer.Record(1000)
+	millis := timer.Time(func() {
+		time.Sleep(5 * time.Millisecond)
+	})
+	if millis < 5 {
+		t.Error("Time should return at least 5 millis but returned", millis)
+	}
+	context := timer.Start()
+	context.Stop()
+	histogram.Record(1)
+	histogram.Record(3)
+	facade.AddSample("histogram", 5)
+	snapshot := facade.SnapshotAndReset()
+	if timerDist := snapshot.Durations()["timer"]; timerDist == nil || timerDist.SampleCount() != 3 || timerDist.Max() != 1000 {
+		t.Errorf("expected 3 durations in the timer with a maximum of 1000 but got %v", timerDist)
+	}
+	AssertDistributionHasValues(snapshot.Samples()["histogram"], 3, 1, 5, 3, 2, t)
+
+	timer.Record(20)
+	histogram.Record(7)
+	facade.Reset()
+	histogram.Record(9)
+	snapshot = facade.Snapshot()
+	if _, exists := snapshot.Durations()["timer"]; exists {
+		t.Error("a reset should clear the distribution of a timer")
+	}
+	AssertDistributionHasValues(snapshot.Samples()["histogram"], 1, 9, 9, 9, 0, t)
+}
+
+func testDurations(t *testing.T, facade api.Facade) {
+	millis := facade.MeasureFunc("duration", func() {
+		time.Sleep(10 * time.Millisecond)
+	})
+	if millis < 10 {
+		t.Error("MeasureFunc should return at least 10 millis but returned", millis)
+	}
+	facade.RecordElapsedTime("duration", facade.StartStopwatch())
+	duration := facade.Snapshot().Durations()["duration"]
+	if duration == nil || duration.SampleCount() != 2 || duration.Max() != millis {
+		t.Errorf("expected 2 durations with a maximum of %v but got %v", millis, duration)
+	}
+}
+
+func testMeasureFuncCanPanic(t *testing.T, facade api.Facade) {
+	facade.MeasureFuncCanPanic("func", func() {})
+	func() {
+		defer func() {
+			if r := recover(); r != "failed" {
+				t.Error("MeasureFuncCanPanic should panic with the error of the subject but got", r)
+			}
+		}()
+		facade.MeasureFuncCanPanic("func", func() { panic("failed") })
+	}()
+	snapshot := facade.Snapshot()
+	if snapshot.Durations()["func"].SampleCount() != 1 || snapshot.Durations()["func.panic"].SampleCount() != 1 {
+		t.Errorf("expected a duration for func and for func.panic but got %v", snapshot.Durations())
+	}
+}
+
+func testMeasureFuncErr(t *testing.T, facade api.Facade) {
+	failure := errors.New("failed")
+	notFound := errors.New("not found")
+	facade.MeasureFuncErr("func", func() error { return nil })
+	if _, err := facade.MeasureFuncErr("func", func() error { return failure }); err != failure {
+		t.Error("MeasureFuncErr should return the error of the subject but got", err)
+	}
+	facade.SetErrorClassifier(func(err error) string {
+		if err == notFound {
+			return "notfound"
+		}
+		return ""
+	})
+	facade.MeasureFuncErr("func", func() error { return notFound })
+	facade.MeasureFuncErr("func", func() error { return failure })
+	facade.SetErrorClassifier(nil)
+	facade.MeasureFuncErr("func", func() error { return notFound })
+
+	snapshot := facade.Snapshot()
+	for key, count := range map[string]int64{"func": 1, "func.error": 3, "func.notfound": 1} {
+		if duration := snapshot.Durations()[key]; duration == nil || duration.SampleCount() != count {
+			t.Errorf("expected %v durations under %v but got %v", count, key, snapshot.Durations())
+		}
+	}
+	for key, count := range map[string]int64{"func.ok": 1, "func.error": 3, "func.notfound": 1} {
+		if snapshot.Counters()[key] != count {
+			t.Errorf("expected outcome counter %v to be %v but got %v", key, count, snapshot.Counters())
+		}
+	}
+}
+
+func testMeasureFuncCtx(t *testing.T, facade api.Facade) {
+	failure := errors.New("failed")
+	facade.MeasureFuncCtx(context.Background(), "func", func(ctx context.Context) error { return nil })
+	if _, err := facade.MeasureFuncCtx(context.Background(), "func", func(ctx context.Context) error { return failure }); err != failure {
+		t.Error("MeasureFuncCtx should return the error of the subject but got", err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	facade.MeasureFuncCtx(canceled, "func", func(ctx context.Context) error { return ctx.Err() })
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	facade.MeasureFuncCtx(expired, "func", func(ctx context.Context) error { return ctx.Err() })
+	sw := facade.StartStopwatchCtx(context.Background())
+	facade.RecordElapsedTimeCtx(context.Background(), "func", sw, nil)
+
+	durations := facade.Snapshot().Durations()
+	for key, count := range map[string]int64{"func.ok": 2, "func.error": 1, "func.canceled": 1, "func.deadline_exceeded": 1} {
+		if duration := durations[key]; duration == nil || duration.SampleCount() != count {
+			t.Errorf("expected %v durations under %v but got %v", count, key, durations)
+		}
+	}
+	if _, exists := durations["func"]; exists {
+		t.Error("the outcome should be appended to the key, func should not exist")
+	}
+}
+
+func testMeasureFuncCtxStopsAtCancellation(t *testing.T, facade api.Facade) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	millis, err := facade.MeasureFuncCtx(ctx, "slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(200 * time.Millisecond) // ignores the cancellation for a while
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Error("expected the deadline to be exceeded but got", err)
+	}
+	if millis < 20 || millis > 150 {
+		t.Errorf("expected the timing to stop at the deadline of 20ms but it took %vms", millis)
+	}
+	if duration := facade.Snapshot().Durations()["slow.deadline_exceeded"]; duration == nil || duration.Max() != millis {
+		t.Error("expected the duration to be recorded under slow.deadline_exceeded")
+	}
+}
+
+func testTags(t *testing.T, facade api.Facade) {
+	tags := api.Tags{"method": "GET", "status": "200"}
+	facade.IncrementCounterWithTags("requests", tags)
+	facade.AddToCounterWithTags("requests", api.Tags{"status": "200", "method": "GET"}, 2)
+	facade.DecrementCounterWithTags("requests", tags)
+	facade.AddSampleWithTags("size", tags, 10)
+	facade.MeasureFuncWithTags("duration", tags, func() {})
+	facade.RecordElapsedTimeWithTags("duration", tags, facade.StartStopwatch())
+	snapshot := facade.Snapshot()
+	if snapshot.Counters()["requests{method=GET,status=200}"] != 2 {
+		t.Errorf("expected tagged counter to be 2 but got %v", snapshot.Counters())
+	}
+	AssertDistributionHasValues(snapshot.Samples()["size{method=GET,status=200}"], 1, 10, 10, 10, 0, t)
+	if duration := snapshot.Durations()["duration{method=GET,status=200}"]; duration == nil || duration.SampleCount() != 2 {
+		t.Errorf("expected 2 tagged durations but got %v", snapshot.Durations())
+	}
+	if series := snapshot.Series()["requests{method=GET,status=200}"]; series.Key != "requests" || series.Tags["status"] != "200" {
+		t.Errorf("unexpected series %v", series)
+	}
+	facade.SetGaugeWithTags("pool.size", api.Tags{"pool": "db"}, 4)
+	facade.MarkMeterWithTags("events", tags, 3)
+	snapshot = facade.Snapshot()
+	if snapshot.Gauges()["pool.size{pool=db}"] != 4 {
+		t.Errorf("expected a tagged gauge but got %v", snapshot.Gauges())
+	}
+	if meter := snapshot.Meters()["events{method=GET,status=200}"]; meter == nil || meter.Count() != 3 {
+		t.Errorf("expected a tagged meter with 3 events but got %v", snapshot.Meters())
+	}
+	// plain keys are kept as they are, even when they contain { or \
+	for _, key := range []string{"pool{x}", `C:\dir`} {
+		facade.AddToCounter(key, 1)
+		snapshot = facade.Snapshot()
+		if snapshot.Counters()[key] != 1 {
+			t.Errorf("expected the plain key %v to be kept as it is but got %v", key, snapshot.Counters())
+		}
+		if _, exists := snapshot.Series()[key]; exists {
+			t.Errorf("the plain key %v should not be a tagged series", key)
+		}
+	}
+	if data, err := json.Marshal(facade.Snapshot()); err != nil || !strings.Contains(string(data), `"C:\\dir":1`) {
+		t.Errorf("expected the plain key to be written to json as it is but got %s %v", data, err)
+	}
+}
+
+func testGauges(t *testing.T, facade api.Facade) {
+	facade.SetGauge("queue.depth", 5)
+	facade.SetGauge("queue.depth", 3)
+	facade.SetGauge("pool.size", 1)
+	evaluations := 0
+	facade.RegisterGaugeFunc("pool.size", func() float64 {
+		evaluations++
+		// gauge functions may use the facade themselves
+		facade.IncrementCounter("gauge.evaluations")
+		return 8
+	})
+	snapshot := facade.Snapshot()
+	if snapshot.Gauges()["queue.depth"] != 3 || snapshot.Gauges()["pool.size"] != 8 {
+		t.Errorf("expected queue.depth=3 and pool.size=8 but got %v", snapshot.Gauges())
+	}
+	if evaluations != 1 {
+		t.Error("expected the gauge function to be evaluated once per snapshot but was evaluated", evaluations, "times")
+	}
+}
+
+func testNonFiniteGaugesAreLeftOutOfJSON(t *testing.T, facade api.Facade) {
+	facade.SetGauge("queue.depth", 3)
+	facade.SetGauge("queue.latency", math.NaN())
+	facade.RegisterGaugeFunc("pool.usage", func() float64 { return math.Inf(1) })
+	data, err := json.Marshal(facade.Snapshot())
+	if err != nil {
+		t.Fatal("marshalling a snapshot with non-finite gauges failed", err)
+	}
+	if !strings.Contains(string(data), `"gauges":{"queue.depth":3}`) {
+		t.Errorf("expected only the finite gauge queue.depth in the JSON but got %v", string(data))
+	}
+}
+
+func testMeters(t *testing.T, facade api.Facade) {
+	facade.MarkMeter("requests", 2)
+	facade.MarkMeter("requests", 3)
+	meter := facade.Snapshot().Meters()["requests"]
+	if meter == nil || meter.Count() != 5 {
+		t.Errorf("expected a meter with 5 events but got %v", meter)
+	}
+}
+
+func testReset(t *testing.T, facade api.Facade) {
+	facade.IncrementCounter("counter")
+	facade.AddSample("sample", 1)
+	facade.RecordElapsedTime("duration", facade.StartStopwatch())
+	facade.SetGauge("gauge", 1)
+	facade.MarkMeter("meter", 1)
+	before := facade.Snapshot()
+	time.Sleep(5 * time.Millisecond)
+	facade.Reset()
+	after := facade.Snapshot()
+	if len(after.Counters())+len(after.Samples())+len(after.Durations()) != 0 {
+		t.Error("after a reset the counters, samples and durations should be cleared")
+	}
+	if after.Gauges()["gauge"] != 1 || after.Meters()["meter"] == nil {
+		t.Error("gauges and meters should survive a reset")
+	}
+	if after.StartedTimestamp() <= before.StartedTimestamp() {
+		t.Error("a reset should restart the time window")
+	}
+}
+
+func testSnapshotAndReset(t *testing.T, facade api.Facade) {
+	facade.IncrementCounter("counter")
+	facade.AddSample("sample", 1)
+	snapshot := facade.SnapshotAndReset()
+	if snapshot.Counters()["counter"] != 1 || snapshot.Samples()["sample"] == nil {
+		t.Error("SnapshotAndReset should return the recordings made before")
+	}
+	snapshot = facade.Snapshot()
+	if len(snapshot.Counters())+len(snapshot.Samples()) != 0 {
+		t.Error("a snapshot after SnapshotAndReset should be empty")
+	}
+}
+
+func testSnapshotsAreDisconnected(t *testing.T, facade api.Facade) {
+	facade.AddSample("sample", 10)
+	facade.IncrementCounter("counter")
+	snapshot := facade.Snapshot()
+	facade.AddSample("sample", 20)
+	facade.IncrementCounter("counter")
+	AssertDistributionHasValues(snapshot.Samples()["sample"], 1, 10, 10, 10, 0, t)
+	if snapshot.Counters()["counter"] != 1 {
+		t.Error("a snapshot should not change after it was taken")
+	}
+	AssertDistributionHasValues(facade.Snapshot().Samples()["sample"], 2, 10, 20, 15, 7.0710, t)
+}
+
+func testCursors(t *testing.T, facade api.Facade) {
+	facade.IncrementCounter("counter")
+	facade.AddSample("sample", 1)
+	first := facade.Cursor()
+	facade.IncrementCounter("counter")
+	facade.AddSample("sample", 2)
+	second := facade.Cursor()
+	facade.IncrementCounter("counter")
+	facade.AddSample("sample", 3)
+
+	delta := first.Next()
+	if delta.Counters()["counter"] != 2 {
+		t.Error("the first cursor should see 2 new increments but got", delta.Counters()["counter"])
+	}
+	if sample := delta.Samples()["sample"]; sample == nil || sample.SampleCount() != 2 || !FloatEquals(sample.Avg(), 2.5) {
+		t.Errorf("the first cursor should see the samples 2 and 3 but got %v", sample)
+	}
+	delta = second.Next()
+	if delta.Counters()["counter"] != 1 || delta.Samples()["sample"].SampleCount() != 1 {
+		t.Error("the second cursor should only see the last increment and sample")
+	}
+	if delta = first.Next(); delta.Counters()["counter"] != 0 || len(delta.Samples()) != 0 {
+		t.Error("a cursor should not see the same changes twice")
+	}
+	if facade.Snapshot().Counters()["counter"] != 3 {
+		t.Error("cursors should not reset the facade")
+	}
+}
+
+func testConcurrency(t *testing.T, facade api.Facade) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				facade.IncrementCounter("concurrency.counter")
+				facade.AddSample("concurrency.sample", float64(j))
+			}
+		}()
+	}
+	wg.Wait()
+	snapshot := facade.Snapshot()
+	if snapshot.Counters()["concurrency.counter"] != 20000 {
+		t.Error("expected 20000 increments but got", snapshot.Counters()["concurrency.counter"])
+	}
+	if sample := snapshot.Samples()["concurrency.sample"]; sample == nil || sample.SampleCount() != 20000 {
+		t.Error("expected 20000 samples but got", sample)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// The outcomes of a context-aware measurement, which are appended to the key of the duration, see OutcomeKey
+const (
+	OutcomeOK               = "ok"
+	OutcomeError            = "error"
+	OutcomeCanceled         = "canceled"
+	OutcomeDeadlineExceeded = "deadline_exceeded"
+)
+
+// Outcome classifies the result of work done with ctx. A nil err is OutcomeOK, an err caused by the cancellation or
+// the deadline of a context, or any err while ctx is done, is OutcomeCanceled or OutcomeDeadlineExceeded, and every
+// other err is OutcomeError.
+func Outcome(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case errors.Is(err, context.DeadlineExceeded):
+		return OutcomeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return OutcomeCanceled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return OutcomeDeadlineExceeded
+	case ctx.Err() != nil:
+		return OutcomeCanceled
+	}
+	return OutcomeError
+}
+
+// OutcomeKey returns key with the Outcome of ctx and err appended, for example db.query.deadline_exceeded
+func OutcomeKey(ctx context.Context, key string, err error) string {
+	return key + "." + Outcome(ctx, err)
+}
+
+// ContextStopwatch is a Stopwatch that stops when its context is done, so work that is abandoned because it was
+// canceled is not timed for longer than it was waited for. Call Stop when the work is done, to release the resources
+// that watch the context.
+type ContextStopwatch struct {
+	stopwatch *Stopwatch
+	release   func() bool
+	lock      sync.Mutex
+	stopped   bool
+	elapsed   float64
+}
+
+// StartNewContextStopwatch creates a new ContextStopwatch that stops when ctx is done
+func StartNewContextStopwatch(ctx context.Context) *ContextStopwatch {
+	sw := &ContextStopwatch{stopwatch: StartNewStopwatch()}
+	sw.release = context.AfterFunc(ctx, func() { sw.freeze() })
+	return sw
+}
+
+// ElapsedMillis returns the milliseconds elapsed since its creation, or until it was stopped
+func (sw *ContextStopwatch) ElapsedMillis() float64 {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if sw.stopped {
+		return sw.elapsed
+	}
+	return sw.stopwatch.ElapsedMillis()
+}
+
+// Stop stops the stopwatch and returns the elapsed milliseconds, stopping it again has no effect
+func (sw *ContextStopwatch) Stop() float64 {
+	elapsed := sw.freeze()
+	sw.release()
+	return elapsed
+}
+
+// freeze stops the elapsed time from increasing, it is called by Stop or when the context is done
+func (sw *ContextStopwatch) freeze() float64 {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if !sw.stopped {
+		sw.stopped = true
+		sw.elapsed = sw.stopwatch.ElapsedMillis()
+	}
+	return sw.elapsed
+}
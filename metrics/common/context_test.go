@@ -0,0 +1,95 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+func TestOutcome(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	failure := errors.New("failed")
+	tests := []struct {
+		ctx      context.Context
+		err      error
+		expected string
+	}{
+		{context.Background(), nil, OutcomeOK},
+		{canceled, nil, OutcomeOK},
+		{context.Background(), failure, OutcomeError},
+		{context.Background(), context.Canceled, OutcomeCanceled},
+		{context.Background(), fmt.Errorf("query: %w", context.DeadlineExceeded), OutcomeDeadlineExceeded},
+		{canceled, failure, OutcomeCanceled},
+		{expired, failure, OutcomeDeadlineExceeded},
+	}
+	for i, test := range tests {
+		if outcome := Outcome(test.ctx, test.err); outcome != test.expected {
+			t.Errorf("test %v: expected outcome %v but got %v", i, test.expected, outcome)
+		}
+	}
+	if key := OutcomeKey(context.Background(), "db.query", failure); key != "db.query.error" {
+		t.Error("expected the outcome to be appended to the key but got", key)
+	}
+}
+
+func TestContextStopwatchStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sw := StartNewContextStopwatch(ctx)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond) // the stopwatch is stopped by a go-routine
+	stoppedAt := sw.ElapsedMillis()
+	time.Sleep(50 * time.Millisecond)
+	if stoppedAt < 20 || stoppedAt > 40 || sw.ElapsedMillis() != stoppedAt {
+		t.Errorf("expected the stopwatch to stop at about 20ms but got %v and later %v", stoppedAt, sw.ElapsedMillis())
+	}
+	if sw.Stop() != stoppedAt {
+		t.Error("stopping a stopped stopwatch should not change its elapsed time")
+	}
+}
+
+func TestContextStopwatchStop(t *testing.T) {
+	sw := StartNewContextStopwatch(context.Background())
+	var apiSw api.Stopwatch = sw
+	time.Sleep(10 * time.Millisecond)
+	stoppedAt := sw.Stop()
+	time.Sleep(10 * time.Millisecond)
+	if stoppedAt < 10 || apiSw.ElapsedMillis() != stoppedAt {
+		t.Errorf("expected the stopwatch to stop at about 10ms but got %v and later %v", stoppedAt, apiSw.ElapsedMillis())
+	}
+}
+
+func TestContextStopwatchWithDoneContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sw := StartNewContextStopwatch(ctx)
+	time.Sleep(20 * time.Millisecond)
+	if elapsed := sw.ElapsedMillis(); elapsed > 10 {
+		t.Error("a stopwatch started with a done context should stop immediately, got", elapsed)
+	}
+}
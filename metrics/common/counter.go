@@ -0,0 +1,51 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import "sync/atomic"
+
+// AtomicCounter is a counter that can be updated concurrently without taking a lock. It implements api.Counter.
+type AtomicCounter struct {
+	value int64
+}
+
+// Inc increments the counter by 1
+func (counter *AtomicCounter) Inc() {
+	atomic.AddInt64(&counter.value, 1)
+}
+
+// Dec decrements the counter by 1
+func (counter *AtomicCounter) Dec() {
+	atomic.AddInt64(&counter.value, -1)
+}
+
+// Add adds value to the counter, value can be negative
+func (counter *AtomicCounter) Add(value int64) {
+	atomic.AddInt64(&counter.value, value)
+}
+
+// Value returns the current value of the counter
+func (counter *AtomicCounter) Value() int64 {
+	return atomic.LoadInt64(&counter.value)
+}
+
+// Reset sets the counter to 0 and returns the value it had, in one atomic operation
+func (counter *AtomicCounter) Reset() int64 {
+	return atomic.SwapInt64(&counter.value, 0)
+}
@@ -0,0 +1,64 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+func TestAtomicCounter(t *testing.T) {
+	var counter api.Counter = &AtomicCounter{}
+	counter.Inc()
+	counter.Inc()
+	counter.Dec()
+	counter.Add(10)
+	atomicCounter := counter.(*AtomicCounter)
+	if atomicCounter.Value() != 11 {
+		t.Error("expected counter to be 11 but was", atomicCounter.Value())
+	}
+	if previous := atomicCounter.Reset(); previous != 11 || atomicCounter.Value() != 0 {
+		t.Errorf("Reset should return 11 and zero the counter, but returned %v and left %v", previous, atomicCounter.Value())
+	}
+}
+
+func TestAtomicCounterResetLosesNoIncrements(t *testing.T) {
+	counter := &AtomicCounter{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				counter.Inc()
+			}
+		}()
+	}
+	total := int64(0)
+	for i := 0; i < 100; i++ {
+		total += counter.Reset()
+	}
+	wg.Wait()
+	total += counter.Reset()
+	if total != 100000 {
+		t.Error("expected the resets to return 100000 increments in total but got", total)
+	}
+}
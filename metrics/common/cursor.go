@@ -0,0 +1,53 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"sync"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// Cursor returns what changed in a facade since the previous read, without resetting the facade. Every consumer of a
+// facade can have its own cursor, see SubtractSnapshot. Cursors need cumulative distributions, they do not work with
+// WindowedDistributions.
+type Cursor struct {
+	snapshot func() api.Snapshot
+	previous api.Snapshot
+	lock     sync.Mutex
+}
+
+// NewCursor creates a Cursor that reads snapshots with the given function, usually the Snapshot method of a facade.
+// The first call to Next returns what changed since the cursor was created.
+func NewCursor(snapshot func() api.Snapshot) *Cursor {
+	if snapshot == nil {
+		panic("snapshot = nil, Cursor needs a snapshot function")
+	}
+	return &Cursor{snapshot: snapshot, previous: snapshot()}
+}
+
+// Next returns what changed since the previous call to Next
+func (cursor *Cursor) Next() api.Snapshot {
+	cursor.lock.Lock()
+	defer cursor.lock.Unlock()
+	current := cursor.snapshot()
+	delta := SubtractSnapshot(current, cursor.previous)
+	cursor.previous = current
+	return delta
+}
@@ -0,0 +1,54 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+func TestCursorsAreIndependent(t *testing.T) {
+	counter := int64(0)
+	snapshot := func() api.Snapshot {
+		return &Snapshot{TimestampStarted: 1000, TimestampCreated: 1000 + counter, CountersSnapshot: map[string]int64{"counter": counter}}
+	}
+	first := NewCursor(snapshot)
+	counter = 3
+	second := NewCursor(snapshot)
+	counter = 5
+	if delta := first.Next().Counters()["counter"]; delta != 5 {
+		t.Error("the first cursor should see 5 new increments but got", delta)
+	}
+	if delta := first.Next().Counters()["counter"]; delta != 0 {
+		t.Error("the first cursor should see no new increments but got", delta)
+	}
+	if delta := second.Next().Counters()["counter"]; delta != 2 {
+		t.Error("the second cursor should see 2 new increments but got", delta)
+	}
+}
+
+func TestNewCursorWithNilSnapshot(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewCursor should panic when snapshot=nil, but no panic")
+		}
+	}()
+	NewCursor(nil)
+}
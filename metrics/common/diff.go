@@ -0,0 +1,244 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// SnapshotDiff describes the differences between two snapshots, for example of two benchmark runs or two deploys.
+// Keys are sorted within each section.
+type SnapshotDiff struct {
+	Counters  CountersDiff
+	Durations DistributionsDiff
+	Samples   DistributionsDiff
+}
+
+// CountersDiff describes the differences between the counters of two snapshots
+type CountersDiff struct {
+	// Added contains the keys that only exist in the after snapshot
+	Added []string
+	// Removed contains the keys that only exist in the before snapshot
+	Removed []string
+	// Compared contains the counters that exist in both snapshots, including the unchanged ones
+	Compared []CounterChange
+}
+
+// CounterChange describes how a counter changed
+type CounterChange struct {
+	Key    string
+	Before int64
+	After  int64
+}
+
+// Delta returns the difference between the after and the before value
+func (change CounterChange) Delta() int64 {
+	return change.After - change.Before
+}
+
+// DistributionsDiff describes the differences between the durations or samples of two snapshots
+type DistributionsDiff struct {
+	// Added contains the keys that only exist in the after snapshot
+	Added []string
+	// Removed contains the keys that only exist in the before snapshot
+	Removed []string
+	// Compared contains the distributions that exist in both snapshots, including the unchanged ones
+	Compared []DistributionChange
+}
+
+// DistributionChange describes how the statistics of a distribution changed
+type DistributionChange struct {
+	Key    string
+	Count  Change
+	Mean   Change
+	Max    Change
+	StdDev Change
+}
+
+// Change describes how a value changed
+type Change struct {
+	Before float64
+	After  float64
+}
+
+// Delta returns the difference between the after and the before value
+func (change Change) Delta() float64 {
+	return change.After - change.Before
+}
+
+// Percent returns the change relative to the before value in percent, a change from 0 is +Inf or -Inf
+func (change Change) Percent() float64 {
+	if change.Before == change.After {
+		return 0
+	}
+	if change.Before == 0 {
+		return math.Inf(int(math.Copysign(1, change.After)))
+	}
+	return change.Delta() / math.Abs(change.Before) * 100
+}
+
+// Diff compares the counters, durations and samples of two snapshots. Gauges and meters describe the moment a
+// snapshot was taken and are not compared.
+func Diff(before, after api.Snapshot) *SnapshotDiff {
+	return &SnapshotDiff{
+		Counters:  diffCounters(before.Counters(), after.Counters()),
+		Durations: diffDistributions(before.Durations(), after.Durations()),
+		Samples:   diffDistributions(before.Samples(), after.Samples()),
+	}
+}
+
+func diffCounters(before, after map[string]int64) CountersDiff {
+	var diff CountersDiff
+	for _, key := range sortedUnion(counterKeys(before), counterKeys(after)) {
+		beforeValue, inBefore := before[key]
+		afterValue, inAfter := after[key]
+		switch {
+		case !inBefore:
+			diff.Added = append(diff.Added, key)
+		case !inAfter:
+			diff.Removed = append(diff.Removed, key)
+		default:
+			diff.Compared = append(diff.Compared, CounterChange{Key: key, Before: beforeValue, After: afterValue})
+		}
+	}
+	return diff
+}
+
+func diffDistributions(before, after map[string]api.Distribution) DistributionsDiff {
+	var diff DistributionsDiff
+	for _, key := range sortedUnion(distributionKeys(before), distributionKeys(after)) {
+		beforeDist, inBefore := before[key]
+		afterDist, inAfter := after[key]
+		switch {
+		case !inBefore:
+			diff.Added = append(diff.Added, key)
+		case !inAfter:
+			diff.Removed = append(diff.Removed, key)
+		default:
+			diff.Compared = append(diff.Compared, DistributionChange{
+				Key:    key,
+				Count:  Change{float64(beforeDist.SampleCount()), float64(afterDist.SampleCount())},
+				Mean:   Change{beforeDist.Avg(), afterDist.Avg()},
+				Max:    Change{beforeDist.Max(), afterDist.Max()},
+				StdDev: Change{beforeDist.StdDev(), afterDist.StdDev()},
+			})
+		}
+	}
+	return diff
+}
+
+// sortedUnion returns the sorted keys that are in before, after or both
+func sortedUnion(before, after []string) []string {
+	union := make(map[string]bool, len(after))
+	for _, key := range before {
+		union[key] = true
+	}
+	for _, key := range after {
+		union[key] = true
+	}
+	keys := make([]string, 0, len(union))
+	for key := range union {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func counterKeys(counters map[string]int64) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func distributionKeys(distributions map[string]api.Distribution) []string {
+	keys := make([]string, 0, len(distributions))
+	for key := range distributions {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// WriteText writes the diff to w in a human readable form. Added keys are marked with +, removed keys with - and
+// compared keys with ~ when they changed or = when they did not. Sections without keys are left out.
+func (diff *SnapshotDiff) WriteText(w io.Writer) error {
+	var buffer bytes.Buffer
+	if len(diff.Counters.Added)+len(diff.Counters.Removed)+len(diff.Counters.Compared) > 0 {
+		buffer.WriteString("counters:\n")
+		writeAddedAndRemoved(&buffer, diff.Counters.Added, diff.Counters.Removed)
+		for _, change := range diff.Counters.Compared {
+			if change.Delta() == 0 {
+				fmt.Fprintf(&buffer, "  = %v %v\n", change.Key, change.After)
+			} else {
+				fmt.Fprintf(&buffer, "  ~ %v %v -> %v (%+d, %v)\n", change.Key, change.Before, change.After,
+					change.Delta(), formatPercent(Change{float64(change.Before), float64(change.After)}))
+			}
+		}
+	}
+	writeDistributionsText(&buffer, "durations", diff.Durations)
+	writeDistributionsText(&buffer, "samples", diff.Samples)
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// String returns the diff in the form of WriteText
+func (diff *SnapshotDiff) String() string {
+	var buffer bytes.Buffer
+	diff.WriteText(&buffer)
+	return buffer.String()
+}
+
+func writeDistributionsText(buffer *bytes.Buffer, section string, diff DistributionsDiff) {
+	if len(diff.Added)+len(diff.Removed)+len(diff.Compared) == 0 {
+		return
+	}
+	fmt.Fprintf(buffer, "%v:\n", section)
+	writeAddedAndRemoved(buffer, diff.Added, diff.Removed)
+	for _, change := range diff.Compared {
+		if change.Count.Delta() == 0 && change.Mean.Delta() == 0 && change.Max.Delta() == 0 && change.StdDev.Delta() == 0 {
+			fmt.Fprintf(buffer, "  = %v\n", change.Key)
+			continue
+		}
+		fmt.Fprintf(buffer, "  ~ %v count %v -> %v, mean %v -> %v (%v), max %v -> %v (%v), stddev %v -> %v (%v)\n",
+			change.Key, change.Count.Before, change.Count.After,
+			change.Mean.Before, change.Mean.After, formatPercent(change.Mean),
+			change.Max.Before, change.Max.After, formatPercent(change.Max),
+			change.StdDev.Before, change.StdDev.After, formatPercent(change.StdDev))
+	}
+}
+
+func writeAddedAndRemoved(buffer *bytes.Buffer, added, removed []string) {
+	for _, key := range added {
+		fmt.Fprintf(buffer, "  + %v\n", key)
+	}
+	for _, key := range removed {
+		fmt.Fprintf(buffer, "  - %v\n", key)
+	}
+}
+
+func formatPercent(change Change) string {
+	return fmt.Sprintf("%+.1f%%", change.Percent())
+}
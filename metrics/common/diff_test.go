@@ -0,0 +1,132 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+func distributionOfSamples(samples ...float64) *Distribution {
+	dist := NewDistribution()
+	for _, sample := range samples {
+		dist.AddSample(sample)
+	}
+	return dist
+}
+
+func TestDiff(t *testing.T) {
+	before := &Snapshot{
+		CountersSnapshot: map[string]int64{"requests": 100, "errors": 4, "removed": 1},
+		DurationsSnapshot: map[string]api.Distribution{
+			"db.query": distributionOfSamples(10, 20),
+			"old.call": distributionOfSamples(1),
+		},
+		SamplesSnapshot: map[string]api.Distribution{"size": distributionOfSamples(5)},
+	}
+	after := &Snapshot{
+		CountersSnapshot: map[string]int64{"requests": 150, "errors": 4, "added": 2},
+		DurationsSnapshot: map[string]api.Distribution{
+			"db.query": distributionOfSamples(10, 20, 30),
+			"new.call": distributionOfSamples(1),
+		},
+		SamplesSnapshot: map[string]api.Distribution{"size": distributionOfSamples(5)},
+	}
+	diff := Diff(before, after)
+	if !reflect.DeepEqual(diff.Counters.Added, []string{"added"}) || !reflect.DeepEqual(diff.Counters.Removed, []string{"removed"}) {
+		t.Errorf("expected added counter added and removed counter removed, got %v and %v", diff.Counters.Added, diff.Counters.Removed)
+	}
+	expectedCounters := []CounterChange{{"errors", 4, 4}, {"requests", 100, 150}}
+	if !reflect.DeepEqual(diff.Counters.Compared, expectedCounters) {
+		t.Errorf("expected compared counters %v but got %v", expectedCounters, diff.Counters.Compared)
+	}
+	if diff.Counters.Compared[1].Delta() != 50 {
+		t.Error("expected the requests counter to change by 50 but got", diff.Counters.Compared[1].Delta())
+	}
+	if !reflect.DeepEqual(diff.Durations.Added, []string{"new.call"}) || !reflect.DeepEqual(diff.Durations.Removed, []string{"old.call"}) {
+		t.Errorf("expected added duration new.call and removed duration old.call, got %v and %v", diff.Durations.Added, diff.Durations.Removed)
+	}
+	query := diff.Durations.Compared[0]
+	if query.Key != "db.query" || query.Count != (Change{2, 3}) || query.Mean != (Change{15, 20}) || query.Max != (Change{20, 30}) {
+		t.Errorf("unexpected change of db.query %+v", query)
+	}
+	if query.Mean.Delta() != 5 || math.Abs(query.Mean.Percent()-100.0/3) > 1e-9 || query.Max.Percent() != 50 {
+		t.Errorf("expected the mean to change by 5 (+33.3%%) and the max by +50%%, got %v, %v and %v",
+			query.Mean.Delta(), query.Mean.Percent(), query.Max.Percent())
+	}
+	if len(diff.Samples.Added)+len(diff.Samples.Removed) != 0 || diff.Samples.Compared[0].Mean.Delta() != 0 {
+		t.Errorf("expected the samples to be unchanged, got %+v", diff.Samples)
+	}
+}
+
+func TestChangePercent(t *testing.T) {
+	tests := []struct {
+		change   Change
+		expected float64
+	}{
+		{Change{10, 15}, 50},
+		{Change{10, 5}, -50},
+		{Change{-10, -5}, 50},
+		{Change{0, 0}, 0},
+		{Change{0, 3}, math.Inf(1)},
+		{Change{0, -3}, math.Inf(-1)},
+	}
+	for _, test := range tests {
+		if percent := test.change.Percent(); percent != test.expected {
+			t.Errorf("expected %+v to change by %v%% but got %v%%", test.change, test.expected, percent)
+		}
+	}
+}
+
+func TestDiffWriteText(t *testing.T) {
+	before := &Snapshot{
+		CountersSnapshot:  map[string]int64{"requests": 100, "errors": 4, "removed": 1},
+		DurationsSnapshot: map[string]api.Distribution{"db.query": distributionOfSamples(10, 20)},
+	}
+	after := &Snapshot{
+		CountersSnapshot:  map[string]int64{"requests": 150, "errors": 4, "added": 2},
+		DurationsSnapshot: map[string]api.Distribution{"db.query": distributionOfSamples(20, 40)},
+	}
+	var buffer bytes.Buffer
+	if err := Diff(before, after).WriteText(&buffer); err != nil {
+		t.Fatal("writing the diff failed", err)
+	}
+	expected := `counters:
+  + added
+  - removed
+  = errors 4
+  ~ requests 100 -> 150 (+50, +50.0%)
+durations:
+  ~ db.query count 2 -> 2, mean 15 -> 30 (+100.0%), max 20 -> 40 (+100.0%), stddev 7.0710678118654755 -> 14.142135623730951 (+100.0%)
+`
+	if buffer.String() != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, buffer.String())
+	}
+	if Diff(before, after).String() != expected {
+		t.Error("String() should return the same text as WriteText")
+	}
+	if strings.Contains(buffer.String(), "samples") {
+		t.Error("empty sections should be left out")
+	}
+}
@@ -19,9 +19,35 @@
 package common
 
 import (
+	"encoding/json"
+	"fmt"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/toefel18/go-patan/metrics/api"
 )
 
+// DefaultQuantiles are the quantiles reported in the json output of distributions created with NewDistribution
+var DefaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99, 0.999}
+
+// Recorder is a distribution that samples can be added to, stores keep a Recorder for every duration and sample key
+type Recorder interface {
+	api.Distribution
+	AddSample(value float64)
+	// Snapshot returns a copy of the current state that is not affected by samples added afterwards
+	Snapshot() api.Distribution
+}
+
+// DistributionFactory creates an empty Recorder, stores use it when a duration or sample key is added for the first time
+type DistributionFactory func() Recorder
+
+// DefaultDistributionFactory creates distributions with NewDistribution
+var DefaultDistributionFactory DistributionFactory = func() Recorder {
+	return NewDistribution()
+}
+
 // Distribution contains a summarized view of a statistical distribution
 type Distribution struct {
 	Samples       int64   `json:"sampleCount"`
@@ -30,10 +56,53 @@ type Distribution struct {
 	Mean          float64 `json:"mean"`
 	totalVariance float64 // since this value is not useful to expose
 	StdDeviation  float64 `json:"stdDeviation"`
+	quantiles     []float64
+	sketch        *quantileSketch
+	estimates     []percentileEstimate // percentiles read from json, used when there is no sketch
 }
 
-// NewDistribution creates a new initialized distribution with
+// percentileEstimate is the value at a quantile, as read from json
+type percentileEstimate struct {
+	quantile float64
+	value    float64
+}
+
+// distributionJSON is the json representation of a distribution, the percentiles are added to the fields of the
+// java version of patan
+type distributionJSON struct {
+	Samples      int64              `json:"sampleCount"`
+	Minimum      float64            `json:"minimum"`
+	Maximum      float64            `json:"maximum"`
+	Mean         float64            `json:"mean"`
+	StdDeviation float64            `json:"stdDeviation"`
+	Variance     float64            `json:"variance"`
+	Percentiles  map[string]float64 `json:"percentiles,omitempty"`
+}
+
+// NewDistribution creates a new initialized distribution that reports the DefaultQuantiles
 func NewDistribution() *Distribution {
+	return NewDistributionWithQuantiles(DefaultQuantiles...)
+}
+
+// NewDistributionWithQuantiles creates a new initialized distribution that reports the given quantiles in its json
+// output. Each quantile must be between 0 and 1 (exclusive), 0.99 for example is reported as p99.
+func NewDistributionWithQuantiles(quantiles ...float64) *Distribution {
+	for _, q := range quantiles {
+		if q <= 0 || q >= 1 {
+			panic(fmt.Sprint("quantile ", q, " is not between 0 and 1"))
+		}
+	}
+	return &Distribution{
+		Minimum:   math.MaxFloat64,
+		Maximum:   math.SmallestNonzeroFloat64,
+		quantiles: append([]float64(nil), quantiles...),
+		sketch:    newQuantileSketch(),
+	}
+}
+
+// newMoments creates a distribution that only tracks the count, minimum, maximum, mean and variance, without the
+// sketch that estimates percentiles
+func newMoments() *Distribution {
 	return &Distribution{Minimum: math.MaxFloat64, Maximum: math.SmallestNonzeroFloat64}
 }
 
@@ -62,6 +131,108 @@ func (dist *Distribution) StdDev() float64 {
 	return dist.StdDeviation
 }
 
+// Variance returns the sample variance of the distribution, the square of StdDev()
+func (dist *Distribution) Variance() float64 {
+	if dist.Samples < 2 {
+		return 0
+	}
+	return dist.totalVariance / float64(dist.Samples-1)
+}
+
+// Percentile returns the estimated value below which a fraction q (0 <= q <= 1) of the samples fall. The estimate
+// has a relative error of at most 1% and always lies between Min() and Max(). Returns 0 if there are no samples.
+func (dist *Distribution) Percentile(q float64) float64 {
+	switch {
+	case dist.Samples == 0:
+		return 0
+	case q <= 0:
+		return dist.Minimum
+	case q >= 1:
+		return dist.Maximum
+	case dist.sketch == nil:
+		return dist.interpolateEstimates(q)
+	}
+	return max(dist.Minimum, min(dist.Maximum, dist.sketch.quantile(q)))
+}
+
+// interpolateEstimates estimates the value at quantile q by linear interpolation between the percentiles read from
+// json, the minimum (quantile 0) and the maximum (quantile 1).
+func (dist *Distribution) interpolateEstimates(q float64) float64 {
+	lower := percentileEstimate{0, dist.Minimum}
+	for _, upper := range append(dist.estimates, percentileEstimate{1, dist.Maximum}) {
+		if q == upper.quantile {
+			return upper.value
+		}
+		if q < upper.quantile {
+			fraction := (q - lower.quantile) / (upper.quantile - lower.quantile)
+			return lower.value + fraction*(upper.value-lower.value)
+		}
+		lower = upper
+	}
+	return dist.Maximum
+}
+
+// Copy returns a copy of the distribution that is not affected by samples added to the original
+func (dist *Distribution) Copy() *Distribution {
+	distCopy := *dist
+	if dist.sketch != nil {
+		distCopy.sketch = dist.sketch.copy()
+	}
+	return &distCopy
+}
+
+// Snapshot returns a copy of the distribution, see Copy
+func (dist *Distribution) Snapshot() api.Distribution {
+	return dist.Copy()
+}
+
+// MarshalJSON writes the distribution in the same format as the java version, followed by the configured percentiles
+func (dist *Distribution) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&distributionJSON{
+		Samples:      dist.Samples,
+		Minimum:      dist.Minimum,
+		Maximum:      dist.Maximum,
+		Mean:         dist.Mean,
+		StdDeviation: dist.StdDeviation,
+		Variance:     dist.Variance(),
+		Percentiles:  percentiles(dist, dist.quantiles),
+	})
+}
+
+// UnmarshalJSON reads a distribution written by MarshalJSON or by the java version. The distribution does not
+// estimate percentiles for new samples, Percentile interpolates between the percentiles that were read. When the
+// variance is absent, it is derived from the standard deviation.
+func (dist *Distribution) UnmarshalJSON(data []byte) error {
+	parsed := distributionJSON{Variance: -1}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*dist = Distribution{
+		Samples:      parsed.Samples,
+		Minimum:      parsed.Minimum,
+		Maximum:      parsed.Maximum,
+		Mean:         parsed.Mean,
+		StdDeviation: parsed.StdDeviation,
+	}
+	if parsed.Variance < 0 {
+		parsed.Variance = parsed.StdDeviation * parsed.StdDeviation
+	}
+	if parsed.Samples > 1 {
+		dist.totalVariance = parsed.Variance * float64(parsed.Samples-1)
+	}
+	for name, value := range parsed.Percentiles {
+		q, err := ParsePercentileName(name)
+		if err != nil {
+			return err
+		}
+		dist.quantiles = append(dist.quantiles, q)
+		dist.estimates = append(dist.estimates, percentileEstimate{q, value})
+	}
+	sort.Float64s(dist.quantiles)
+	sort.Slice(dist.estimates, func(i, j int) bool { return dist.estimates[i].quantile < dist.estimates[j].quantile })
+	return nil
+}
+
 // AddSample updates the distribution to contain the value
 func (dist *Distribution) AddSample(value float64) {
 	updatedSampleCount := dist.Samples + 1
@@ -76,6 +247,9 @@ func (dist *Distribution) AddSample(value float64) {
 	dist.Maximum = updatedMax
 	dist.Mean = updatedAvg
 	dist.totalVariance = updatedVar
+	if dist.sketch != nil {
+		dist.sketch.add(value)
+	}
 	if math.IsNaN(updatedStdDev) {
 		updatedStdDev = 0.0 //if there's only one value, the stdDeviation should be 0
 	} else {
@@ -83,6 +257,143 @@ func (dist *Distribution) AddSample(value float64) {
 	}
 }
 
+// Merge updates the distribution to also contain all samples of other, as if they were added with AddSample. Count,
+// minimum, maximum, mean and variance are combined exactly. The percentile estimates are combined when both
+// distributions estimate percentiles, otherwise they are dropped because they would no longer be correct.
+func (dist *Distribution) Merge(other *Distribution) {
+	if other.Samples == 0 {
+		return
+	}
+	if dist.Samples == 0 {
+		// a copy of other that keeps the quantiles reported by dist
+		merged := other.Copy()
+		merged.quantiles = dist.quantiles
+		if dist.sketch == nil {
+			merged.sketch = nil
+		}
+		*dist = *merged
+		return
+	}
+	// parallel algorithm of Chan et al. for combining the mean and variance of two sets
+	updatedSampleCount := dist.Samples + other.Samples
+	delta := other.Mean - dist.Mean
+	updatedAvg := dist.Mean + delta*float64(other.Samples)/float64(updatedSampleCount)
+	updatedVar := dist.totalVariance + other.totalVariance + delta*delta*float64(dist.Samples)*float64(other.Samples)/float64(updatedSampleCount)
+
+	dist.Samples = updatedSampleCount
+	dist.Minimum = min(dist.Minimum, other.Minimum)
+	dist.Maximum = max(dist.Maximum, other.Maximum)
+	dist.Mean = updatedAvg
+	dist.totalVariance = updatedVar
+	dist.StdDeviation = math.Sqrt(updatedVar / float64(updatedSampleCount-1))
+	dist.estimates = nil
+	if dist.sketch != nil && other.sketch != nil {
+		dist.sketch.merge(other.sketch)
+	} else {
+		dist.sketch = nil
+	}
+}
+
+// Subtract updates the distribution to only contain the samples that were added after other was copied from it, so
+// other must be an earlier copy of dist, see Copy. Count, mean and variance are computed exactly. The minimum and
+// maximum are exact when they changed after the copy was taken, otherwise they are estimated from the percentile
+// estimates, or kept when there are none.
+func (dist *Distribution) Subtract(other *Distribution) {
+	if other.Samples == 0 {
+		return
+	}
+	if other.Samples >= dist.Samples {
+		*dist = Distribution{Minimum: math.MaxFloat64, Maximum: math.SmallestNonzeroFloat64, quantiles: dist.quantiles}
+		if other.sketch != nil {
+			dist.sketch = newQuantileSketch()
+		}
+		return
+	}
+	// the parallel algorithm of Chan et al. solved for one of the sets
+	remainingSampleCount := dist.Samples - other.Samples
+	remainingAvg := (dist.Mean*float64(dist.Samples) - other.Mean*float64(other.Samples)) / float64(remainingSampleCount)
+	delta := remainingAvg - other.Mean
+	remainingVar := dist.totalVariance - other.totalVariance - delta*delta*float64(other.Samples)*float64(remainingSampleCount)/float64(dist.Samples)
+	remainingVar = max(remainingVar, 0)
+
+	remainingMin, remainingMax := dist.Minimum, dist.Maximum
+	if dist.sketch != nil && other.sketch != nil {
+		dist.sketch.subtract(other.sketch)
+		if dist.Minimum >= other.Minimum {
+			remainingMin = max(dist.Minimum, dist.sketch.quantile(0))
+		}
+		if dist.Maximum <= other.Maximum {
+			remainingMax = min(dist.Maximum, dist.sketch.quantile(1))
+		}
+	} else {
+		dist.sketch = nil
+	}
+
+	dist.Samples = remainingSampleCount
+	dist.Minimum = remainingMin
+	dist.Maximum = max(remainingMin, remainingMax)
+	dist.Mean = remainingAvg
+	dist.totalVariance = remainingVar
+	dist.StdDeviation = 0
+	if remainingSampleCount > 1 {
+		dist.StdDeviation = math.Sqrt(remainingVar / float64(remainingSampleCount-1))
+	}
+	dist.estimates = nil
+}
+
+// DistributionOf returns a *Distribution with the same count, minimum, maximum, mean and standard deviation as dist.
+// If dist is a *Distribution, a copy is returned. Other implementations lose their percentiles.
+func DistributionOf(dist api.Distribution) *Distribution {
+	switch typed := dist.(type) {
+	case *Distribution:
+		return typed.Copy()
+	case *HdrDistribution:
+		return typed.moments.Copy()
+	}
+	result := NewDistributionWithQuantiles()
+	result.sketch = nil
+	if dist.SampleCount() > 0 {
+		result.Samples = dist.SampleCount()
+		result.Minimum = dist.Min()
+		result.Maximum = dist.Max()
+		result.Mean = dist.Avg()
+		result.StdDeviation = dist.StdDev()
+		result.totalVariance = dist.StdDev() * dist.StdDev() * float64(dist.SampleCount()-1)
+	}
+	return result
+}
+
+// percentiles calculates the given quantiles of dist, keyed by their percentile name
+func percentiles(dist api.Distribution, quantiles []float64) map[string]float64 {
+	if len(quantiles) == 0 {
+		return nil
+	}
+	result := make(map[string]float64, len(quantiles))
+	for _, q := range quantiles {
+		result[PercentileName(q)] = dist.Percentile(q)
+	}
+	return result
+}
+
+// PercentileName returns the name under which quantile q is reported, 0.5 becomes p50, 0.99 becomes p99 and
+// 0.999 becomes p999.
+func PercentileName(q float64) string {
+	digits := strings.TrimPrefix(strconv.FormatFloat(q, 'f', -1, 64), "0.")
+	if len(digits) < 2 {
+		digits += "0"
+	}
+	return "p" + digits
+}
+
+// ParsePercentileName is the inverse of PercentileName, p99 returns 0.99
+func ParsePercentileName(name string) (float64, error) {
+	digits := strings.TrimPrefix(name, "p")
+	if len(digits) == 0 || len(digits) == len(name) || strings.Trim(digits, "0123456789") != "" {
+		return 0, fmt.Errorf("%q is not a valid percentile name", name)
+	}
+	return strconv.ParseFloat("0."+digits, 64)
+}
+
 func min(a, b float64) float64 {
 	if a < b {
 		return a
@@ -19,7 +19,9 @@
 package common
 
 import (
+	"encoding/json"
 	"math"
+	"strings"
 	"testing"
 
 	"github.com/toefel18/go-patan/metrics/api"
@@ -58,3 +60,217 @@ func TestDistributionImplementsInterface(t *testing.T) {
 		t.Error("common.Distribution has problems implementing api.Distribution interface")
 	}
 }
+
+func TestDistributionPercentile(t *testing.T) {
+	dist := NewDistribution()
+	for i := 1; i <= 1000; i++ {
+		dist.AddSample(float64(i))
+	}
+	if p := dist.Percentile(0); p != 1 {
+		t.Errorf("Percentile(0) should be the minimum but was %v", p)
+	}
+	if p := dist.Percentile(1); p != 1000 {
+		t.Errorf("Percentile(1) should be the maximum but was %v", p)
+	}
+	if p := dist.Percentile(0.99); math.Abs(p-990) > 10 {
+		t.Errorf("Percentile(0.99) should be close to 990 but was %v", p)
+	}
+}
+
+func TestDistributionPercentileStaysWithinMinMax(t *testing.T) {
+	dist := NewDistribution()
+	dist.AddSample(1.001)
+	dist.AddSample(1.001)
+	if p := dist.Percentile(0.5); p != 1.001 {
+		t.Errorf("Percentile(0.5) should be clamped to 1.001 but was %v", p)
+	}
+}
+
+func TestEmptyDistributionPercentile(t *testing.T) {
+	if p := NewDistribution().Percentile(0.5); p != 0 {
+		t.Errorf("Percentile of an empty distribution should be 0 but was %v", p)
+	}
+}
+
+func TestNewDistributionWithInvalidQuantile(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewDistributionWithQuantiles should panic when a quantile is not between 0 and 1")
+		}
+	}()
+	NewDistributionWithQuantiles(0.5, 1.5)
+}
+
+func TestDistributionCopyIsDisconnected(t *testing.T) {
+	dist := NewDistribution()
+	dist.AddSample(10)
+	distCopy := dist.Copy()
+	dist.AddSample(20)
+	commontest.AssertDistributionHasValues(distCopy, 1, 10, 10, 10, 0, t)
+	if p := distCopy.Percentile(0.999); p != 10 {
+		t.Errorf("the percentiles of the copy should not change, p999 = %v", p)
+	}
+}
+
+func TestDistributionMarshalJSON(t *testing.T) {
+	dist := NewDistributionWithQuantiles(0.5, 0.95)
+	dist.AddSample(5)
+	data, err := json.Marshal(dist)
+	if err != nil {
+		t.Fatal("marshalling a distribution failed", err)
+	}
+	expected := `{"sampleCount":1,"minimum":5,"maximum":5,"mean":5,"stdDeviation":0,"variance":0,"percentiles":{"p50":5,"p95":5}}`
+	if string(data) != expected {
+		t.Errorf("json was %v, expected %v", string(data), expected)
+	}
+}
+
+func TestDistributionMarshalJSONWithoutQuantiles(t *testing.T) {
+	data, _ := json.Marshal(NewDistributionWithQuantiles())
+	if strings.Contains(string(data), "percentiles") {
+		t.Errorf("a distribution without quantiles should not write percentiles, got %v", string(data))
+	}
+}
+
+func TestPercentileName(t *testing.T) {
+	expectations := map[float64]string{0.5: "p50", 0.9: "p90", 0.95: "p95", 0.99: "p99", 0.999: "p999", 0.05: "p05"}
+	for q, expected := range expectations {
+		if name := PercentileName(q); name != expected {
+			t.Errorf("PercentileName(%v) = %v, expected %v", q, name, expected)
+		}
+	}
+}
+
+func TestDistributionMerge(t *testing.T) {
+	dist := NewDistribution()
+	other := NewDistribution()
+	all := NewDistribution()
+	for i := 1; i <= 10; i++ {
+		if i%3 == 0 {
+			dist.AddSample(float64(i))
+		} else {
+			other.AddSample(float64(i))
+		}
+		all.AddSample(float64(i))
+	}
+	dist.Merge(other)
+	commontest.AssertDistributionHasValues(dist, all.Samples, all.Minimum, all.Maximum, all.Mean, all.StdDeviation, t)
+	if !commontest.FloatEquals(dist.Variance(), all.Variance()) {
+		t.Errorf("merged variance is %v, expected %v", dist.Variance(), all.Variance())
+	}
+	if dist.Percentile(0.5) != all.Percentile(0.5) {
+		t.Errorf("merged median is %v, expected %v", dist.Percentile(0.5), all.Percentile(0.5))
+	}
+	if other.Samples != 7 {
+		t.Error("merging should not modify the merged distribution")
+	}
+}
+
+func TestDistributionMergeEmpty(t *testing.T) {
+	dist := NewDistribution()
+	other := NewDistribution()
+	other.AddSample(4)
+	other.AddSample(6)
+	dist.Merge(NewDistribution())
+	commontest.AssertDistributionHasValues(dist, 0, math.MaxFloat64, math.SmallestNonzeroFloat64, 0.0, 0.0, t)
+	dist.Merge(other)
+	commontest.AssertDistributionHasValues(dist, 2, 4, 6, 5, math.Sqrt(2), t)
+	other.AddSample(100)
+	commontest.AssertDistributionHasValues(dist, 2, 4, 6, 5, math.Sqrt(2), t)
+}
+
+func TestDistributionOf(t *testing.T) {
+	hdr := NewHdrDistribution(1, 1000, 3)
+	hdr.AddSample(10)
+	hdr.AddSample(20)
+	dist := DistributionOf(hdr)
+	commontest.AssertDistributionHasValues(dist, 2, 10, 20, 15, 7.0710, t)
+	if !commontest.FloatEquals(dist.Variance(), 50) {
+		t.Errorf("variance should be 50 but was %v", dist.Variance())
+	}
+}
+
+func TestDistributionUnmarshalJSON(t *testing.T) {
+	dist := &Distribution{}
+	data := `{"sampleCount":3,"minimum":10,"maximum":30,"mean":20,"stdDeviation":10,"variance":100,"percentiles":{"p90":28,"p50":20}}`
+	if err := json.Unmarshal([]byte(data), dist); err != nil {
+		t.Fatal("unmarshalling failed", err)
+	}
+	commontest.AssertDistributionHasValues(dist, 3, 10, 30, 20, 10, t)
+	if dist.Variance() != 100 {
+		t.Errorf("variance should be 100 but was %v", dist.Variance())
+	}
+	expectations := map[float64]float64{0: 10, 0.25: 15, 0.5: 20, 0.7: 24, 0.9: 28, 0.95: 29, 1: 30}
+	for q, expected := range expectations {
+		if p := dist.Percentile(q); !commontest.FloatEquals(p, expected) {
+			t.Errorf("Percentile(%v) should be interpolated to %v but was %v", q, expected, p)
+		}
+	}
+	dist.AddSample(40)
+	commontest.AssertDistributionHasValues(dist, 4, 10, 40, 25, 12.9099, t)
+}
+
+func TestDistributionUnmarshalJSONWithoutVariance(t *testing.T) {
+	dist := &Distribution{}
+	if err := json.Unmarshal([]byte(`{"sampleCount":3,"minimum":10,"maximum":30,"mean":20,"stdDeviation":10}`), dist); err != nil {
+		t.Fatal("unmarshalling failed", err)
+	}
+	if dist.Variance() != 100 {
+		t.Errorf("variance should be derived from the standard deviation but was %v", dist.Variance())
+	}
+}
+
+func TestDistributionUnmarshalJSONWithInvalidPercentile(t *testing.T) {
+	dist := &Distribution{}
+	if err := json.Unmarshal([]byte(`{"sampleCount":1,"percentiles":{"median":20}}`), dist); err == nil {
+		t.Error("unmarshalling an invalid percentile name should fail")
+	}
+}
+
+func TestParsePercentileName(t *testing.T) {
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 0.999, 0.05} {
+		if parsed, err := ParsePercentileName(PercentileName(q)); err != nil || parsed != q {
+			t.Errorf("ParsePercentileName(%v) = %v, %v, expected %v", PercentileName(q), parsed, err, q)
+		}
+	}
+	for _, name := range []string{"p", "50", "p5.0", "p-1", "pe1", "median"} {
+		if _, err := ParsePercentileName(name); err == nil {
+			t.Errorf("ParsePercentileName(%v) should fail", name)
+		}
+	}
+}
+
+func TestDistributionSubtract(t *testing.T) {
+	dist := NewDistribution()
+	for i := 1; i <= 10; i++ {
+		dist.AddSample(float64(i))
+	}
+	previous := dist.Copy()
+	for i := 11; i <= 20; i++ {
+		dist.AddSample(float64(i))
+	}
+	dist.Subtract(previous)
+	if dist.SampleCount() != 10 || !commontest.FloatEquals(dist.Avg(), 15.5) || !commontest.FloatEquals(dist.StdDev(), 3.0276) {
+		t.Errorf("expected 10 samples with mean 15.5 and stddev 3.0276 but got %v, %v and %v", dist.SampleCount(), dist.Avg(), dist.StdDev())
+	}
+	if dist.Max() != 20 {
+		t.Error("the maximum changed after the copy, so it should be exact but was", dist.Max())
+	}
+	if math.Abs(dist.Min()-11) > 11*sketchRelativeAccuracy {
+		t.Error("the minimum should be estimated close to 11 but was", dist.Min())
+	}
+	if p50 := dist.Percentile(0.5); math.Abs(p50-15) > 15*sketchRelativeAccuracy {
+		t.Error("expected the p50 of the remaining samples to be 15 but got", p50)
+	}
+}
+
+func TestDistributionSubtractEverything(t *testing.T) {
+	dist := NewDistribution()
+	dist.AddSample(5)
+	dist.Subtract(dist.Copy())
+	if dist.SampleCount() != 0 || dist.Percentile(0.5) != 0 {
+		t.Error("subtracting a copy should leave an empty distribution")
+	}
+	dist.AddSample(3)
+	commontest.AssertDistributionHasValues(dist, 1, 3, 3, 3, 0, t)
+}
@@ -0,0 +1,82 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"errors"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// DefaultErrorSuffix is the suffix of failures that are not classified otherwise, see ErrorSuffix
+const DefaultErrorSuffix = "error"
+
+// ErrorSuffix returns the suffix under which a failure with err is recorded, as determined by classifier. Returns
+// DefaultErrorSuffix when classifier is nil or returns an empty suffix.
+func ErrorSuffix(classifier api.ErrorClassifier, err error) string {
+	if classifier == nil {
+		return DefaultErrorSuffix
+	}
+	if suffix := classifier(err); suffix != "" {
+		return suffix
+	}
+	return DefaultErrorSuffix
+}
+
+// ErrorRule classifies errors that match Target with errors.Is as Suffix, see NewErrorClassifier
+type ErrorRule struct {
+	Target error
+	Suffix string
+}
+
+// NewErrorClassifier returns an api.ErrorClassifier that classifies an error with the suffix of the first rule it
+// matches, in the order of rules. Errors that match no rule are classified as DefaultErrorSuffix. For example:
+// NewErrorClassifier([]ErrorRule{{Target: context.DeadlineExceeded, Suffix: "timeout"}, {Target: sql.ErrNoRows, Suffix: "notfound"}})
+func NewErrorClassifier(rules []ErrorRule) api.ErrorClassifier {
+	rules = append([]ErrorRule(nil), rules...)
+	return func(err error) string {
+		for _, rule := range rules {
+			if errors.Is(err, rule.Target) {
+				return rule.Suffix
+			}
+		}
+		return DefaultErrorSuffix
+	}
+}
+
+// ErrorRecorder records the outcomes of MeasureFuncErr, every api.Facade is one
+type ErrorRecorder interface {
+	IncrementCounter(key string)
+	RecordElapsedTime(key string, stopwatch api.Stopwatch) float64
+}
+
+// MeasureFuncErr runs the subject function and records its duration in recorder under key when it succeeds, or under
+// key with the suffix of classifier appended when it returns an error, see ErrorSuffix. The counter key.ok or the
+// counter with the same suffix is incremented as well.
+func MeasureFuncErr(recorder ErrorRecorder, classifier api.ErrorClassifier, key string, subject func() error) (float64, error) {
+	sw := StartNewStopwatch()
+	err := subject()
+	if err != nil {
+		failureKey := key + "." + ErrorSuffix(classifier, err)
+		recorder.IncrementCounter(failureKey)
+		return recorder.RecordElapsedTime(failureKey, sw), err
+	}
+	recorder.IncrementCounter(key + ".ok")
+	return recorder.RecordElapsedTime(key, sw), nil
+}
@@ -0,0 +1,98 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+func TestErrorSuffix(t *testing.T) {
+	failure := errors.New("failed")
+	if suffix := ErrorSuffix(nil, failure); suffix != DefaultErrorSuffix {
+		t.Error("expected the default suffix without a classifier but got", suffix)
+	}
+	if suffix := ErrorSuffix(func(err error) string { return "" }, failure); suffix != DefaultErrorSuffix {
+		t.Error("expected the default suffix for an empty suffix but got", suffix)
+	}
+	if suffix := ErrorSuffix(func(err error) string { return "custom" }, failure); suffix != "custom" {
+		t.Error("expected the suffix of the classifier but got", suffix)
+	}
+}
+
+func TestNewErrorClassifier(t *testing.T) {
+	wrappedEOF := fmt.Errorf("reading body: %w", io.EOF)
+	rules := []ErrorRule{
+		{context.DeadlineExceeded, "timeout"},
+		{wrappedEOF, "eof.body"},
+		{io.EOF, "eof"},
+		{io.ErrUnexpectedEOF, "eof.unexpected"},
+	}
+	classify := NewErrorClassifier(rules)
+	rules[0].Suffix = "changed"
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{context.DeadlineExceeded, "timeout"},
+		{fmt.Errorf("handling request: %w", wrappedEOF), "eof.body"},
+		{fmt.Errorf("reading header: %w", io.EOF), "eof"},
+		{io.ErrUnexpectedEOF, "eof.unexpected"},
+		{errors.New("failed"), DefaultErrorSuffix},
+	}
+	for _, test := range tests {
+		if suffix := classify(test.err); suffix != test.expected {
+			t.Errorf("expected %v to be classified as %v but got %v", test.err, test.expected, suffix)
+		}
+	}
+}
+
+type recorder struct {
+	counters  []string
+	durations []string
+}
+
+func (recorder *recorder) IncrementCounter(key string) {
+	recorder.counters = append(recorder.counters, key)
+}
+
+func (recorder *recorder) RecordElapsedTime(key string, stopwatch api.Stopwatch) float64 {
+	recorder.durations = append(recorder.durations, key)
+	return stopwatch.ElapsedMillis()
+}
+
+func TestMeasureFuncErr(t *testing.T) {
+	failure := errors.New("failed")
+	recorder := &recorder{}
+	MeasureFuncErr(recorder, nil, "func", func() error { return nil })
+	if _, err := MeasureFuncErr(recorder, nil, "func", func() error { return failure }); err != failure {
+		t.Error("MeasureFuncErr should return the error of the subject but got", err)
+	}
+	MeasureFuncErr(recorder, func(err error) string { return "custom" }, "func", func() error { return failure })
+	expected := "func.ok,func.error,func.custom"
+	if strings.Join(recorder.counters, ",") != expected || strings.Join(recorder.durations, ",") != "func,func.error,func.custom" {
+		t.Errorf("expected counters %v but got %v and durations %v", expected, recorder.counters, recorder.durations)
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// Backend holds the recordings of a Facade. Keys are series keys, see SeriesKey. The stores of the lockbased and
+// channelbased packages are backends.
+type Backend interface {
+	AddDuration(key string, value float64)
+	AddSample(key string, value float64)
+	AddToCounter(key string, value int64)
+	SetGauge(key string, value float64)
+	RegisterGaugeFunc(key string, gauge func() float64)
+	MarkMeter(key string, n int64)
+	// CounterCell returns the cell of the counter identified by key, which is updated without going through the
+	// backend
+	CounterCell(key string) *AtomicCounter
+	// DurationHandle returns a function that records into the duration distribution identified by key
+	DurationHandle(key string) func(value float64)
+	// SampleHandle returns a function that records into the sample distribution identified by key
+	SampleHandle(key string) func(value float64)
+	Snapshot() api.Snapshot
+	SnapshotAndReset() api.Snapshot
+	Reset()
+}
+
+// Facade implements api.Facade on a Backend
+type Facade struct {
+	backend Backend
+	// classifier holds the api.ErrorClassifier of MeasureFuncErr
+	classifier atomic.Value
+	// series holds the api.Series of every tagged series key, so snapshots can tell tagged series from plain keys
+	series sync.Map
+}
+
+// NewFacade creates a new facade that records in backend
+func NewFacade(backend Backend) *Facade {
+	if backend == nil {
+		panic("backend = nil, Facade needs a backend")
+	}
+	return &Facade{backend: backend}
+}
+
+// StartStopwatch starts a new stopwatch
+func (facade *Facade) StartStopwatch() api.Stopwatch {
+	return StartNewStopwatch()
+}
+
+// RecordElapsedTime records the elapsed time of the stopwatch under the distribution identified with key
+func (facade *Facade) RecordElapsedTime(key string, stopwatch api.Stopwatch) float64 {
+	millis := stopwatch.ElapsedMillis()
+	facade.backend.AddDuration(key, millis)
+	return millis
+}
+
+// MeasureFunc runs the subject function and records it's execution duration under the distribution identified with key
+// if the function panics, no measurement is recorded! Use MeasureFuncCanPanic to get a function that can panic
+func (facade *Facade) MeasureFunc(key string, subject func()) float64 {
+	sw := StartNewStopwatch()
+	subject()
+	return facade.RecordElapsedTime(key, sw)
+}
+
+// MeasureFuncCanPanic runs the subject function and records it's execution duration under the distribution identified
+// with key. When subject() panics, the measurement is recorded under the same key with .panic appended. This function
+// itself will panic with the same error as the inner function.
+func (facade *Facade) MeasureFuncCanPanic(key string, subject func()) float64 {
+	sw := StartNewStopwatch()
+	defer func() {
+		if err := recover(); err != nil {
+			facade.RecordElapsedTime(key+".panic", sw)
+			panic(err)
+		}
+	}()
+	subject()
+	return facade.RecordElapsedTime(key, sw)
+}
+
+// MeasureFuncErr runs the subject function and records its duration under key when it succeeds, or under key with the
+// suffix of the error classifier appended when it returns an error. The counter key.ok or the counter with the same
+// suffix is incremented as well.
+func (facade *Facade) MeasureFuncErr(key string, subject func() error) (float64, error) {
+	return MeasureFuncErr(facade, facade.errorClassifier(), key, subject)
+}
+
+// SetErrorClassifier sets the classifier that determines the suffix of failed MeasureFuncErr calls, nil records every
+// failure as error
+func (facade *Facade) SetErrorClassifier(classifier api.ErrorClassifier) {
+	facade.classifier.Store(classifier)
+}
+
+func (facade *Facade) errorClassifier() api.ErrorClassifier {
+	classifier, _ := facade.classifier.Load().(api.ErrorClassifier)
+	return classifier
+}
+
+// StartStopwatchCtx starts a stopwatch that stops when ctx is done
+func (facade *Facade) StartStopwatchCtx(ctx context.Context) api.Stopwatch {
+	return StartNewContextStopwatch(ctx)
+}
+
+// RecordElapsedTimeCtx records the elapsed time of the stopwatch under key with the outcome of ctx and err appended,
+// see OutcomeKey
+func (facade *Facade) RecordElapsedTimeCtx(ctx context.Context, key string, stopwatch api.Stopwatch, err error) float64 {
+	if contextStopwatch, ok := stopwatch.(*ContextStopwatch); ok {
+		contextStopwatch.Stop()
+	}
+	return facade.RecordElapsedTime(OutcomeKey(ctx, key, err), stopwatch)
+}
+
+// MeasureFuncCtx runs subject with ctx and records its duration under key with the outcome appended. The timing
+// stops when ctx is done, even when subject returns later.
+func (facade *Facade) MeasureFuncCtx(ctx context.Context, key string, subject func(ctx context.Context) error) (float64, error) {
+	sw := facade.StartStopwatchCtx(ctx)
+	err := subject(ctx)
+	return facade.RecordElapsedTimeCtx(ctx, key, sw, err), err
+}
+
+// IncrementCounter increments the counter identified by key by 1
+func (facade *Facade) IncrementCounter(key string) {
+	facade.AddToCounter(key, 1)
+}
+
+// DecrementCounter decrements the counter identified by key by 1
+func (facade *Facade) DecrementCounter(key string) {
+	facade.AddToCounter(key, -1)
+}
+
+// AddToCounter adds value to the counter identified by key, value can be negative
+func (facade *Facade) AddToCounter(key string, value int64) {
+	facade.backend.AddToCounter(key, value)
+}
+
+// Counter returns a handle to the counter identified by key, that updates the counter without a lock
+func (facade *Facade) Counter(key string) api.Counter {
+	return facade.backend.CounterCell(key)
+}
+
+// Timer returns a handle to the duration distribution identified by key, that records without looking up the key
+func (facade *Facade) Timer(key string) api.Timer {
+	return NewTimer(facade.backend.DurationHandle(key))
+}
+
+// Histogram returns a handle to the sample distribution identified by key, that records without looking up the key
+func (facade *Facade) Histogram(key string) api.Histogram {
+	return NewHistogram(facade.backend.SampleHandle(key))
+}
+
+// AddSample adds a sample to the distribution identified by value, if the distribution doesn't
+// exist, it will be created
+func (facade *Facade) AddSample(key string, value float64) {
+	facade.backend.AddSample(key, value)
+}
+
+// SetGauge sets the gauge identified by key to value
+func (facade *Facade) SetGauge(key string, value float64) {
+	facade.backend.SetGauge(key, value)
+}
+
+// RegisterGaugeFunc registers a function that is called on every snapshot to determine the value of the gauge
+// identified by key
+func (facade *Facade) RegisterGaugeFunc(key string, gauge func() float64) {
+	facade.backend.RegisterGaugeFunc(key, gauge)
+}
+
+// MarkMeter marks the occurrence of n events on the meter identified by key
+func (facade *Facade) MarkMeter(key string, n int64) {
+	facade.backend.MarkMeter(key, n)
+}
+
+// RecordElapsedTimeWithTags records the elapsed time of the stopwatch under the distribution identified with key
+// and tags
+func (facade *Facade) RecordElapsedTimeWithTags(key string, tags api.Tags, stopwatch api.Stopwatch) float64 {
+	millis := stopwatch.ElapsedMillis()
+	facade.backend.AddDuration(facade.seriesKey(key, tags), millis)
+	return millis
+}
+
+// MeasureFuncWithTags runs the subject function and records it's execution duration under the distribution
+// identified with key and tags
+func (facade *Facade) MeasureFuncWithTags(key string, tags api.Tags, subject func()) float64 {
+	sw := StartNewStopwatch()
+	subject()
+	return facade.RecordElapsedTimeWithTags(key, tags, sw)
+}
+
+// IncrementCounterWithTags increments the counter identified by key and tags by 1
+func (facade *Facade) IncrementCounterWithTags(key string, tags api.Tags) {
+	facade.backend.AddToCounter(facade.seriesKey(key, tags), 1)
+}
+
+// DecrementCounterWithTags decrements the counter identified by key and tags by 1
+func (facade *Facade) DecrementCounterWithTags(key string, tags api.Tags) {
+	facade.backend.AddToCounter(facade.seriesKey(key, tags), -1)
+}
+
+// AddToCounterWithTags adds value to the counter identified by key and tags, value can be negative
+func (facade *Facade) AddToCounterWithTags(key string, tags api.Tags, value int64) {
+	facade.backend.AddToCounter(facade.seriesKey(key, tags), value)
+}
+
+// AddSampleWithTags adds a sample to the distribution identified by key and tags
+func (facade *Facade) AddSampleWithTags(key string, tags api.Tags, value float64) {
+	facade.backend.AddSample(facade.seriesKey(key, tags), value)
+}
+
+// SetGaugeWithTags sets the gauge identified by key and tags to value
+func (facade *Facade) SetGaugeWithTags(key string, tags api.Tags, value float64) {
+	facade.backend.SetGauge(facade.seriesKey(key, tags), value)
+}
+
+// MarkMeterWithTags marks the occurrence of n events on the meter identified by key and tags
+func (facade *Facade) MarkMeterWithTags(key string, tags api.Tags, n int64) {
+	facade.backend.MarkMeter(facade.seriesKey(key, tags), n)
+}
+
+// Reset clears the backend
+func (facade *Facade) Reset() {
+	facade.backend.Reset()
+}
+
+// Snapshot returns a snapshot of all the counters, durations and samples recorded
+// since creation or the last reset.
+func (facade *Facade) Snapshot() api.Snapshot {
+	return facade.withSeries(facade.backend.Snapshot())
+}
+
+// Cursor returns a cursor that returns what changed since its previous read, without resetting the backend
+func (facade *Facade) Cursor() api.Cursor {
+	return NewCursor(facade.Snapshot)
+}
+
+// SnapshotAndReset returns a snapshot of all the counters, durations and samples recorded
+// since creation or the last reset, and then clears the internal state
+func (facade *Facade) SnapshotAndReset() api.Snapshot {
+	return facade.withSeries(facade.backend.SnapshotAndReset())
+}
+
+// seriesKey returns the series key of key and tags, and remembers the tags of new tagged series
+func (facade *Facade) seriesKey(key string, tags api.Tags) string {
+	seriesKey := SeriesKey(key, tags)
+	if len(tags) > 0 {
+		if _, known := facade.series.Load(seriesKey); !known {
+			copied := make(api.Tags, len(tags))
+			for name, value := range tags {
+				copied[name] = value
+			}
+			facade.series.Store(seriesKey, api.Series{Key: key, Tags: copied})
+		}
+	}
+	return seriesKey
+}
+
+// withSeries fills in the tagged series of a snapshot of the backend
+func (facade *Facade) withSeries(snapshot api.Snapshot) api.Snapshot {
+	typed, ok := snapshot.(*Snapshot)
+	if !ok {
+		return snapshot
+	}
+	typed.SeriesSnapshot = make(map[string]api.Series)
+	facade.series.Range(func(seriesKey, series interface{}) bool {
+		if typed.contains(seriesKey.(string)) {
+			typed.SeriesSnapshot[seriesKey.(string)] = series.(api.Series)
+		}
+		return true
+	})
+	return typed
+}
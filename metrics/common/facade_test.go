@@ -0,0 +1,121 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common/commontest"
+)
+
+// lockedState is the simplest Backend, a State behind a lock
+type lockedState struct {
+	state *State
+	lock  sync.Mutex
+}
+
+func newLockedState() *lockedState {
+	return &lockedState{state: NewState(DefaultDistributionFactory, DefaultDistributionFactory)}
+}
+
+func (backend *lockedState) locked(do func(state *State)) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	do(backend.state)
+}
+
+func (backend *lockedState) AddDuration(key string, value float64) {
+	backend.locked(func(state *State) { state.AddDuration(key, value) })
+}
+
+func (backend *lockedState) AddSample(key string, value float64) {
+	backend.locked(func(state *State) { state.AddSample(key, value) })
+}
+
+func (backend *lockedState) AddToCounter(key string, value int64) {
+	backend.locked(func(state *State) { state.AddToCounter(key, value) })
+}
+
+func (backend *lockedState) SetGauge(key string, value float64) {
+	backend.locked(func(state *State) { state.SetGauge(key, value) })
+}
+
+func (backend *lockedState) RegisterGaugeFunc(key string, gauge func() float64) {
+	backend.locked(func(state *State) { state.RegisterGaugeFunc(key, gauge) })
+}
+
+func (backend *lockedState) MarkMeter(key string, n int64) {
+	backend.locked(func(state *State) { state.MarkMeter(key, n) })
+}
+
+func (backend *lockedState) CounterCell(key string) (cell *AtomicCounter) {
+	backend.locked(func(state *State) { cell = state.CounterCell(key) })
+	return cell
+}
+
+func (backend *lockedState) DurationHandle(key string) func(value float64) {
+	handle := NewDurationHandle(key)
+	return func(value float64) {
+		backend.locked(func(state *State) { state.RecordTo(handle, value) })
+	}
+}
+
+func (backend *lockedState) SampleHandle(key string) func(value float64) {
+	handle := NewSampleHandle(key)
+	return func(value float64) {
+		backend.locked(func(state *State) { state.RecordTo(handle, value) })
+	}
+}
+
+func (backend *lockedState) Snapshot() api.Snapshot {
+	var snapshot *Snapshot
+	var gaugeFuncs map[string]func() float64
+	backend.locked(func(state *State) { snapshot, gaugeFuncs = state.Snapshot(), state.GaugeFuncs() })
+	EvaluateGaugeFuncs(snapshot, gaugeFuncs)
+	return snapshot
+}
+
+func (backend *lockedState) SnapshotAndReset() api.Snapshot {
+	var snapshot *Snapshot
+	var gaugeFuncs map[string]func() float64
+	backend.locked(func(state *State) { snapshot, gaugeFuncs = state.SnapshotAndReset(), state.GaugeFuncs() })
+	EvaluateGaugeFuncs(snapshot, gaugeFuncs)
+	return snapshot
+}
+
+func (backend *lockedState) Reset() {
+	backend.locked(func(state *State) { state.Reset() })
+}
+
+func TestFacadeBehaviour(t *testing.T) {
+	commontest.RunFacadeTests(t, func() api.Facade {
+		return NewFacade(newLockedState())
+	})
+}
+
+func TestNewFacadeWithNilBackend(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewFacade should panic when backend=nil, but no panic")
+		}
+	}()
+	NewFacade(nil)
+}
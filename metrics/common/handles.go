@@ -0,0 +1,81 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import "github.com/toefel18/go-patan/metrics/api"
+
+// Timer implements api.Timer on top of a function that records a duration in millis
+type Timer struct {
+	record func(millis float64)
+}
+
+// NewTimer creates a Timer that records with the given function
+func NewTimer(record func(millis float64)) *Timer {
+	if record == nil {
+		panic("record = nil, Timer needs a record function")
+	}
+	return &Timer{record}
+}
+
+// Record adds a duration in millis
+func (timer *Timer) Record(millis float64) {
+	timer.record(millis)
+}
+
+// Time runs the subject function and records its duration, returns the recorded millis. If subject panics, no
+// duration is recorded.
+func (timer *Timer) Time(subject func()) float64 {
+	context := timer.Start()
+	subject()
+	return context.Stop()
+}
+
+// Start starts timing, the duration is recorded when Stop is called on the result
+func (timer *Timer) Start() api.TimerContext {
+	return &timerContext{timer: timer, stopwatch: StartNewStopwatch()}
+}
+
+type timerContext struct {
+	timer     *Timer
+	stopwatch *Stopwatch
+}
+
+func (context *timerContext) Stop() float64 {
+	millis := context.stopwatch.ElapsedMillis()
+	context.timer.record(millis)
+	return millis
+}
+
+// Histogram implements api.Histogram on top of a function that records a sample
+type Histogram struct {
+	record func(value float64)
+}
+
+// NewHistogram creates a Histogram that records with the given function
+func NewHistogram(record func(value float64)) *Histogram {
+	if record == nil {
+		panic("record = nil, Histogram needs a record function")
+	}
+	return &Histogram{record}
+}
+
+// Record adds a sample
+func (histogram *Histogram) Record(value float64) {
+	histogram.record(value)
+}
@@ -0,0 +1,65 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	var recorded []float64
+	timer := NewTimer(func(millis float64) {
+		recorded = append(recorded, millis)
+	})
+	timer.Record(3)
+	millis := timer.Time(func() {
+		time.Sleep(10 * time.Millisecond)
+	})
+	context := timer.Start()
+	time.Sleep(5 * time.Millisecond)
+	stopped := context.Stop()
+	if len(recorded) != 3 || recorded[0] != 3 || recorded[1] != millis || recorded[2] != stopped {
+		t.Errorf("expected 3, %v and %v to be recorded but got %v", millis, stopped, recorded)
+	}
+	if millis < 10 || stopped < 5 {
+		t.Errorf("expected at least 10 and 5 millis but got %v and %v", millis, stopped)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	sum := 0.0
+	histogram := NewHistogram(func(value float64) {
+		sum += value
+	})
+	histogram.Record(2)
+	histogram.Record(3)
+	if sum != 5 {
+		t.Error("expected 2 and 3 to be recorded but got a sum of", sum)
+	}
+}
+
+func TestNewTimerWithNilRecord(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewTimer should panic when record=nil, but no panic")
+		}
+	}()
+	NewTimer(nil)
+}
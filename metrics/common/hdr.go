@@ -0,0 +1,387 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// HdrDistribution is a distribution backed by a High Dynamic Range histogram. Percentiles are exact within the
+// configured number of significant figures, for all values between the lowest and highest trackable value. Sample
+// count, minimum, maximum, mean and standard deviation are tracked exactly.
+type HdrDistribution struct {
+	moments   *Distribution
+	histogram *hdrHistogram
+	lowest    float64
+	highest   float64
+}
+
+// NewHdrDistribution creates a new HdrDistribution. Lowest is the smallest value that can be distinguished from 0,
+// highest is the largest value that can be tracked, larger values are counted as highest and negative values as 0. The histogram keeps
+// values accurate to significantFigures (1 to 5) significant decimal figures. Panics if the configuration is invalid.
+func NewHdrDistribution(lowest, highest float64, significantFigures int) *HdrDistribution {
+	if lowest <= 0 {
+		panic(fmt.Sprint("lowest trackable value must be > 0 but was ", lowest))
+	}
+	if highest < 2*lowest {
+		panic(fmt.Sprint("highest trackable value must be >= 2 * lowest trackable value but was ", highest))
+	}
+	if significantFigures < 1 || significantFigures > 5 {
+		panic(fmt.Sprint("significant figures must be between 1 and 5 but was ", significantFigures))
+	}
+	return &HdrDistribution{
+		moments:   newMoments(),
+		histogram: newHdrHistogram(int64(math.Ceil(highest/lowest)), significantFigures),
+		lowest:    lowest,
+		highest:   highest,
+	}
+}
+
+// HdrDistributionFactory returns a factory for HdrDistributions with the given configuration, see NewHdrDistribution.
+// Panics immediately if the configuration is invalid.
+func HdrDistributionFactory(lowest, highest float64, significantFigures int) DistributionFactory {
+	NewHdrDistribution(lowest, highest, significantFigures)
+	return func() Recorder {
+		return NewHdrDistribution(lowest, highest, significantFigures)
+	}
+}
+
+// SampleCount returns the number of samples taken
+func (dist *HdrDistribution) SampleCount() int64 {
+	return dist.moments.SampleCount()
+}
+
+// Min returns the minimum value recorded
+func (dist *HdrDistribution) Min() float64 {
+	return dist.moments.Min()
+}
+
+// Max returns the maximum value recorded
+func (dist *HdrDistribution) Max() float64 {
+	return dist.moments.Max()
+}
+
+// Avg returns the average value (json marshalled as mean)
+func (dist *HdrDistribution) Avg() float64 {
+	return dist.moments.Avg()
+}
+
+// StdDev returns the standard deviation of the distribution.
+func (dist *HdrDistribution) StdDev() float64 {
+	return dist.moments.StdDev()
+}
+
+// Percentile returns the value below which a fraction q (0 <= q <= 1) of the samples fall, within the precision of
+// the histogram. The result always lies between Min() and Max(). Returns 0 if there are no samples.
+func (dist *HdrDistribution) Percentile(q float64) float64 {
+	switch {
+	case dist.SampleCount() == 0:
+		return 0
+	case q <= 0:
+		return dist.Min()
+	case q >= 1:
+		return dist.Max()
+	}
+	value := float64(dist.histogram.valueAtQuantile(q)) * dist.lowest
+	return max(dist.Min(), min(dist.Max(), value))
+}
+
+// AddSample updates the distribution to contain the value
+func (dist *HdrDistribution) AddSample(value float64) {
+	dist.moments.AddSample(value)
+	dist.histogram.recordCount(dist.histogramValue(value), 1)
+}
+
+// histogramValue converts value to the integer domain of the histogram, in which 1 equals the lowest trackable value
+func (dist *HdrDistribution) histogramValue(value float64) int64 {
+	return int64(math.Floor(min(max(value, 0), dist.highest)/dist.lowest + 0.5))
+}
+
+// Copy returns a copy of the distribution that is not affected by samples added to the original
+func (dist *HdrDistribution) Copy() *HdrDistribution {
+	distCopy := *dist
+	distCopy.moments = dist.moments.Copy()
+	distCopy.histogram = dist.histogram.copy()
+	return &distCopy
+}
+
+// Snapshot returns a copy of the distribution, see Copy
+func (dist *HdrDistribution) Snapshot() api.Distribution {
+	return dist.Copy()
+}
+
+// Merge updates the distribution to also contain all samples of other. When both distributions have the same
+// configuration the histograms are combined exactly, otherwise the values of other are added with the precision of
+// this distribution.
+func (dist *HdrDistribution) Merge(other *HdrDistribution) {
+	dist.moments.Merge(other.moments)
+	if dist.sameConfiguration(other) {
+		dist.histogram.merge(other.histogram)
+		return
+	}
+	for index, count := range other.histogram.counts {
+		if count > 0 {
+			value := float64(other.histogram.valueFromIndex(index)) * other.lowest
+			dist.histogram.recordCount(dist.histogramValue(value), count)
+		}
+	}
+}
+
+// Subtract updates the distribution to only contain the samples that were added after other was copied from it, so
+// other must be an earlier copy of dist, see Copy and Distribution.Subtract. Panics if other has a different
+// configuration.
+func (dist *HdrDistribution) Subtract(other *HdrDistribution) {
+	if !dist.sameConfiguration(other) {
+		panic("cannot subtract a HdrDistribution with a different configuration")
+	}
+	dist.moments.Subtract(other.moments)
+	dist.histogram.subtract(other.histogram)
+	dist.narrowRange()
+}
+
+// narrowRange bounds the minimum and maximum, which Distribution.Subtract keeps when they were recorded before the
+// subtracted copy, by the range of the values left in the histogram
+func (dist *HdrDistribution) narrowRange() {
+	lowestValue, highestValue, ok := dist.histogram.recordedRange()
+	if !ok {
+		return
+	}
+	// values are rounded to the nearest multiple of lowest, negative values are counted as 0 and values above
+	// highest as highest, so only bound the range where no value can be outside the counted range
+	if lowestValue > 0 {
+		dist.moments.Minimum = max(dist.moments.Minimum, (float64(lowestValue)-0.5)*dist.lowest)
+	}
+	if dist.moments.Maximum <= dist.highest {
+		dist.moments.Maximum = min(dist.moments.Maximum, (float64(highestValue)+0.5)*dist.lowest)
+	}
+	dist.moments.Maximum = max(dist.moments.Minimum, dist.moments.Maximum)
+}
+
+// sameConfiguration returns true when the histograms of dist and other have the same layout, so their counts can be
+// combined index by index
+func (dist *HdrDistribution) sameConfiguration(other *HdrDistribution) bool {
+	return dist.lowest == other.lowest &&
+		dist.histogram.subBucketCount == other.histogram.subBucketCount &&
+		len(dist.histogram.counts) == len(other.histogram.counts)
+}
+
+// Percentiles returns an iterator over the percentiles of the distribution. The steps between the reported
+// percentiles halve every time the distance to 100% halves, ticksPerHalfDistance is the number of steps taken
+// in each half.
+func (dist *HdrDistribution) Percentiles(ticksPerHalfDistance int) *PercentileIterator {
+	if ticksPerHalfDistance < 1 {
+		ticksPerHalfDistance = 1
+	}
+	return &PercentileIterator{dist: dist, ticksPerHalfDistance: ticksPerHalfDistance}
+}
+
+// MarshalJSON writes the distribution in the same format as the java version, followed by the DefaultQuantiles
+func (dist *HdrDistribution) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&distributionJSON{
+		Samples:      dist.SampleCount(),
+		Minimum:      dist.Min(),
+		Maximum:      dist.Max(),
+		Mean:         dist.Avg(),
+		StdDeviation: dist.StdDev(),
+		Variance:     dist.moments.Variance(),
+		Percentiles:  percentiles(dist, DefaultQuantiles),
+	})
+}
+
+// PercentileIterator steps through the percentiles of a HdrDistribution, starting at 0 and ending at 1 (100%).
+// example:
+//
+//	for it := dist.Percentiles(5); it.Next(); {
+//	    fmt.Println(it.Quantile(), it.Value())
+//	}
+type PercentileIterator struct {
+	dist                 *HdrDistribution
+	ticksPerHalfDistance int
+	quantile             float64
+	value                float64
+	started              bool
+	done                 bool
+}
+
+// Next moves the iterator to the next percentile, returns false when there are no more percentiles
+func (it *PercentileIterator) Next() bool {
+	if it.done || it.dist.SampleCount() == 0 {
+		return false
+	}
+	if it.started {
+		ticks := float64(it.ticksPerHalfDistance) * math.Pow(2, math.Floor(math.Log2(1/(1-it.quantile)))+1)
+		it.quantile += 1 / ticks
+	}
+	it.started = true
+	it.value = it.dist.Percentile(it.quantile)
+	if it.quantile >= 1 || it.value >= it.dist.Max() {
+		it.quantile = 1
+		it.value = it.dist.Max()
+		it.done = true
+	}
+	return true
+}
+
+// Quantile returns the current quantile, between 0 and 1
+func (it *PercentileIterator) Quantile() float64 {
+	return it.quantile
+}
+
+// Value returns the value at the current quantile
+func (it *PercentileIterator) Value() float64 {
+	return it.value
+}
+
+// hdrHistogram counts integer values between 1 and highest in buckets that keep significantFigures of precision.
+// Each bucket covers twice the range of the previous one, and is divided into equally sized sub buckets.
+type hdrHistogram struct {
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	subBucketCount              int64
+	highest                     int64
+	totalCount                  int64
+	counts                      []int64
+}
+
+func newHdrHistogram(highest int64, significantFigures int) *hdrHistogram {
+	largestValueWithSingleUnitResolution := 2 * int64(math.Pow10(significantFigures))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestValueWithSingleUnitResolution))))
+	subBucketHalfCountMagnitude := subBucketCountMagnitude - 1
+	subBucketCount := int64(1) << subBucketCountMagnitude
+
+	bucketCount := 1
+	for smallestUntrackableValue := subBucketCount; smallestUntrackableValue <= highest; smallestUntrackableValue <<= 1 {
+		bucketCount++
+		if smallestUntrackableValue > math.MaxInt64/2 {
+			break
+		}
+	}
+
+	return &hdrHistogram{
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketMask:               subBucketCount - 1,
+		subBucketCount:              subBucketCount,
+		highest:                     highest,
+		counts:                      make([]int64, (int64(bucketCount)+1)*(subBucketCount/2)),
+	}
+}
+
+func (histogram *hdrHistogram) recordCount(value, count int64) {
+	histogram.counts[histogram.countsIndex(value)] += count
+	histogram.totalCount += count
+}
+
+// merge adds the counts of other, which must have the same layout
+func (histogram *hdrHistogram) merge(other *hdrHistogram) {
+	for index, count := range other.counts {
+		histogram.counts[index] += count
+	}
+	histogram.totalCount += other.totalCount
+}
+
+// subtract removes the counts of other, which must have the same layout
+func (histogram *hdrHistogram) subtract(other *hdrHistogram) {
+	histogram.totalCount = 0
+	for index, count := range other.counts {
+		histogram.counts[index] -= count
+		if histogram.counts[index] < 0 {
+			histogram.counts[index] = 0
+		}
+		histogram.totalCount += histogram.counts[index]
+	}
+}
+
+// valueAtQuantile returns the highest value that is equivalent to the value at quantile q (0 < q < 1)
+func (histogram *hdrHistogram) valueAtQuantile(q float64) int64 {
+	countAtQuantile := int64(math.Ceil(q * float64(histogram.totalCount)))
+	if countAtQuantile < 1 {
+		countAtQuantile = 1
+	}
+	cumulative := int64(0)
+	for index, count := range histogram.counts {
+		cumulative += count
+		if cumulative >= countAtQuantile {
+			return histogram.highestEquivalentValue(histogram.valueFromIndex(index))
+		}
+	}
+	return histogram.highest
+}
+
+// recordedRange returns the lowest equivalent value of the lowest recorded value and the highest equivalent value of
+// the highest recorded value, ok is false when nothing was recorded
+func (histogram *hdrHistogram) recordedRange() (lowest, highest int64, ok bool) {
+	first, last := -1, -1
+	for index, count := range histogram.counts {
+		if count > 0 {
+			if first < 0 {
+				first = index
+			}
+			last = index
+		}
+	}
+	if first < 0 {
+		return 0, 0, false
+	}
+	return histogram.valueFromIndex(first), histogram.highestEquivalentValue(histogram.valueFromIndex(last)), true
+}
+
+func (histogram *hdrHistogram) copy() *hdrHistogram {
+	histogramCopy := *histogram
+	histogramCopy.counts = append([]int64(nil), histogram.counts...)
+	return &histogramCopy
+}
+
+func (histogram *hdrHistogram) bucketIndex(value int64) int64 {
+	pow2Ceiling := int64(64 - bits.LeadingZeros64(uint64(value|histogram.subBucketMask)))
+	return pow2Ceiling - int64(histogram.subBucketHalfCountMagnitude+1)
+}
+
+func (histogram *hdrHistogram) countsIndex(value int64) int64 {
+	bucketIndex := histogram.bucketIndex(value)
+	subBucketIndex := value >> uint(bucketIndex)
+	bucketBaseIndex := (bucketIndex + 1) << histogram.subBucketHalfCountMagnitude
+	return bucketBaseIndex + subBucketIndex - histogram.subBucketHalfCount
+}
+
+func (histogram *hdrHistogram) valueFromIndex(index int) int64 {
+	bucketIndex := int64(index>>histogram.subBucketHalfCountMagnitude) - 1
+	subBucketIndex := int64(index)&(histogram.subBucketHalfCount-1) + histogram.subBucketHalfCount
+	if bucketIndex < 0 {
+		subBucketIndex -= histogram.subBucketHalfCount
+		bucketIndex = 0
+	}
+	return subBucketIndex << uint(bucketIndex)
+}
+
+func (histogram *hdrHistogram) highestEquivalentValue(value int64) int64 {
+	bucketIndex := histogram.bucketIndex(value)
+	subBucketIndex := value >> uint(bucketIndex)
+	lowestEquivalentValue := subBucketIndex << uint(bucketIndex)
+	if subBucketIndex >= histogram.subBucketCount {
+		bucketIndex++
+	}
+	return lowestEquivalentValue + (int64(1) << uint(bucketIndex)) - 1
+}
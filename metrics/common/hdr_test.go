@@ -0,0 +1,217 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common/commontest"
+)
+
+func TestNewHdrDistributionWithInvalidConfiguration(t *testing.T) {
+	configurations := [][]float64{{0, 100, 3}, {1, 1, 3}, {1, 100, 0}, {1, 100, 6}}
+	for _, config := range configurations {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("NewHdrDistribution%v should panic", config)
+				}
+			}()
+			NewHdrDistribution(config[0], config[1], int(config[2]))
+		}()
+	}
+}
+
+func TestHdrDistributionMoments(t *testing.T) {
+	dist := NewHdrDistribution(0.001, 3600000, 3)
+	for i := 1; i <= 10; i++ {
+		dist.AddSample(float64(i))
+	}
+	expDeviation := math.Sqrt((2*4.5*4.5 + 2*3.5*3.5 + 2*2.5*2.5 + 2*1.5*1.5 + 2*0.5*0.5) / 9)
+	commontest.AssertDistributionHasValues(dist, 10, 1.0, 10, 5.5, expDeviation, t)
+}
+
+func TestHdrDistributionPercentilesWithinPrecision(t *testing.T) {
+	dist := NewHdrDistribution(0.001, 3600000, 3)
+	for i := 1; i <= 100000; i++ {
+		dist.AddSample(float64(i) / 100)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		expected := q * 1000
+		if p := dist.Percentile(q); math.Abs(p-expected) > expected*0.001 {
+			t.Errorf("Percentile(%v) = %v, expected %v within 3 significant figures", q, p, expected)
+		}
+	}
+	if dist.Percentile(0) != 0.01 || dist.Percentile(1) != 1000 {
+		t.Errorf("Percentile(0) and Percentile(1) should be the minimum and maximum but were %v and %v", dist.Percentile(0), dist.Percentile(1))
+	}
+}
+
+func TestHdrDistributionValuesOutOfRange(t *testing.T) {
+	dist := NewHdrDistribution(1, 100, 2)
+	dist.AddSample(-5)
+	dist.AddSample(5000)
+	commontest.AssertDistributionHasValues(dist, 2, -5, 5000, 2497.5, 3539.07, t)
+	if p := dist.Percentile(0.5); p != 0 {
+		t.Errorf("negative values should be counted as 0, but Percentile(0.5) = %v", p)
+	}
+	if p := dist.Percentile(0.99); p != 100 {
+		t.Errorf("values above highest should be counted as highest, but Percentile(0.99) = %v", p)
+	}
+}
+
+func TestEmptyHdrDistribution(t *testing.T) {
+	dist := NewHdrDistribution(1, 100, 2)
+	if dist.Percentile(0.5) != 0 {
+		t.Error("Percentile of an empty distribution should be 0")
+	}
+	if dist.Percentiles(5).Next() {
+		t.Error("an empty distribution has no percentiles to iterate")
+	}
+}
+
+func TestHdrDistributionPercentileIterator(t *testing.T) {
+	dist := NewHdrDistribution(1, 10000, 3)
+	for i := 1; i <= 1000; i++ {
+		dist.AddSample(float64(i))
+	}
+	var quantiles, values []float64
+	for it := dist.Percentiles(1); it.Next(); {
+		quantiles = append(quantiles, it.Quantile())
+		values = append(values, it.Value())
+	}
+	expectedQuantiles := []float64{0, 0.5, 0.75, 0.875, 0.9375}
+	for i, q := range expectedQuantiles {
+		if !commontest.FloatEquals(quantiles[i], q) {
+			t.Errorf("step %v should report quantile %v but reported %v", i, q, quantiles[i])
+		}
+	}
+	if quantiles[len(quantiles)-1] != 1 || values[len(values)-1] != 1000 {
+		t.Errorf("the last step should report the maximum at quantile 1, got %v at %v", values[len(values)-1], quantiles[len(quantiles)-1])
+	}
+	for i := 1; i < len(values); i++ {
+		if values[i] < values[i-1] || quantiles[i] <= quantiles[i-1] {
+			t.Errorf("percentiles should be increasing, step %v went from %v@%v to %v@%v", i, values[i-1], quantiles[i-1], values[i], quantiles[i])
+		}
+	}
+}
+
+func TestHdrDistributionCopyIsDisconnected(t *testing.T) {
+	dist := NewHdrDistribution(1, 10000, 3)
+	dist.AddSample(10)
+	distCopy := dist.Copy()
+	dist.AddSample(5000)
+	commontest.AssertDistributionHasValues(distCopy, 1, 10, 10, 10, 0, t)
+	if p := distCopy.Percentile(0.99); p != 10 {
+		t.Errorf("the percentiles of the copy should not change, p99 = %v", p)
+	}
+}
+
+func TestHdrDistributionMarshalJSON(t *testing.T) {
+	var dist api.Distribution = NewHdrDistribution(1, 10000, 3)
+	dist.(Recorder).AddSample(7)
+	data, err := json.Marshal(dist)
+	if err != nil {
+		t.Fatal("marshalling a distribution failed", err)
+	}
+	jsonString := string(data)
+	if !strings.HasPrefix(jsonString, `{"sampleCount":1,"minimum":7,"maximum":7,"mean":7,"stdDeviation":0,"variance":0,"percentiles":{`) ||
+		!strings.Contains(jsonString, `"p99":7`) {
+		t.Errorf("json output does not contain the expected fields: %v", jsonString)
+	}
+}
+
+func TestHdrDistributionMerge(t *testing.T) {
+	dist := NewHdrDistribution(1, 10000, 3)
+	other := NewHdrDistribution(1, 10000, 3)
+	differentConfig := NewHdrDistribution(0.5, 100000, 2)
+	for i := 1; i <= 100; i++ {
+		dist.AddSample(float64(i))
+		other.AddSample(float64(i + 100))
+		differentConfig.AddSample(float64(i + 200))
+	}
+	dist.Merge(other)
+	dist.Merge(differentConfig)
+	if dist.SampleCount() != 300 || dist.Min() != 1 || dist.Max() != 300 {
+		t.Errorf("merged distribution has count %v, min %v and max %v", dist.SampleCount(), dist.Min(), dist.Max())
+	}
+	if p := dist.Percentile(0.5); p != 150 {
+		t.Errorf("merged median should be 150 but was %v", p)
+	}
+	if p := dist.Percentile(0.9); math.Abs(p-270) > 1 {
+		t.Errorf("merged p90 should be close to 270 but was %v", p)
+	}
+}
+
+func TestHdrDistributionSubtract(t *testing.T) {
+	dist := NewHdrDistribution(1, 1000, 3)
+	for i := 1; i <= 100; i++ {
+		dist.AddSample(float64(i))
+	}
+	previous := dist.Copy()
+	for i := 101; i <= 200; i++ {
+		dist.AddSample(float64(i))
+	}
+	dist.Subtract(previous)
+	if dist.SampleCount() != 100 || !commontest.FloatEquals(dist.Avg(), 150.5) {
+		t.Errorf("expected 100 samples with mean 150.5 but got %v and %v", dist.SampleCount(), dist.Avg())
+	}
+	if p50 := dist.Percentile(0.5); p50 < 149.9 || p50 > 150.1 {
+		t.Error("expected the p50 of the remaining samples to be 150 but got", p50)
+	}
+}
+
+func TestHdrDistributionSubtractNarrowsRange(t *testing.T) {
+	dist := NewHdrDistribution(1, 1000, 3)
+	for i := 1; i <= 100; i++ {
+		dist.AddSample(float64(i))
+	}
+	previous := dist.Copy()
+	for i := 40; i <= 60; i++ {
+		dist.AddSample(float64(i))
+	}
+	dist.Subtract(previous)
+	if dist.Min() < 39.5 || dist.Min() > 40 || dist.Max() < 60 || dist.Max() > 60.5 {
+		t.Errorf("expected the range of the remaining samples to be about 40 to 60 but got %v to %v", dist.Min(), dist.Max())
+	}
+}
+
+func TestHdrDistributionDoesNotEstimateWithSketch(t *testing.T) {
+	dist := NewHdrDistribution(1, 1000, 3)
+	dist.AddSample(10)
+	if dist.moments.sketch != nil {
+		t.Error("the histogram estimates the percentiles, the moments should not keep a sketch")
+	}
+}
+
+func TestHdrDistributionSameConfiguration(t *testing.T) {
+	dist := NewHdrDistribution(1, 1000, 3)
+	if !dist.sameConfiguration(NewHdrDistribution(1, 1000, 3)) {
+		t.Error("distributions with the same configuration should have the same layout")
+	}
+	for _, other := range []*HdrDistribution{NewHdrDistribution(2, 1000, 3), NewHdrDistribution(1, 1000, 2), NewHdrDistribution(1, 100000, 3)} {
+		if dist.sameConfiguration(other) {
+			t.Errorf("distributions with a different configuration should differ, lowest %v and %v sub buckets", other.lowest, other.histogram.subBucketCount)
+		}
+	}
+}
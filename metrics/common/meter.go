@@ -0,0 +1,161 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// meterTickInterval is the interval at which the moving averages of a Meter are updated
+const meterTickInterval = 5 * time.Second
+
+// Meter measures the rate of events. It keeps exponentially weighted moving averages over the last 1, 5 and 15
+// minutes, like the unix load average, and the mean rate since the meter was created. The moving averages are
+// updated lazily, every time the meter is marked or a snapshot is taken, so a meter needs no go-routine. A Meter
+// is not safe for concurrent use.
+type Meter struct {
+	count   int64
+	started time.Time
+	now     func() time.Time
+
+	lastTick  time.Time
+	uncounted int64
+	m1        ewma
+	m5        ewma
+	m15       ewma
+}
+
+// NewMeter creates a new Meter that starts measuring immediately
+func NewMeter() *Meter {
+	return newMeterWithClock(time.Now)
+}
+
+func newMeterWithClock(now func() time.Time) *Meter {
+	started := now()
+	return &Meter{
+		started:  started,
+		now:      now,
+		lastTick: started,
+		m1:       newEWMA(time.Minute),
+		m5:       newEWMA(5 * time.Minute),
+		m15:      newEWMA(15 * time.Minute),
+	}
+}
+
+// Mark records the occurrence of n events
+func (meter *Meter) Mark(n int64) {
+	meter.tick()
+	meter.count += n
+	meter.uncounted += n
+}
+
+// Snapshot returns the current count and rates of the meter
+func (meter *Meter) Snapshot() *MeterSnapshot {
+	meter.tick()
+	snapshot := &MeterSnapshot{
+		Events:  meter.count,
+		M1:      meter.m1.rate,
+		M5:      meter.m5.rate,
+		M15:     meter.m15.rate,
+		Started: meter.started.UnixNano() / time.Millisecond.Nanoseconds(),
+	}
+	if elapsed := meter.now().Sub(meter.started).Seconds(); elapsed > 0 {
+		snapshot.Mean = float64(meter.count) / elapsed
+	}
+	return snapshot
+}
+
+// tick updates the moving averages for every tick interval that passed since the last tick
+func (meter *Meter) tick() {
+	ticks := int64(meter.now().Sub(meter.lastTick) / meterTickInterval)
+	if ticks <= 0 {
+		return
+	}
+	meter.lastTick = meter.lastTick.Add(time.Duration(ticks) * meterTickInterval)
+	// the events since the last tick are attributed to the first interval, the others only decay the averages
+	instantRate := float64(meter.uncounted) / meterTickInterval.Seconds()
+	meter.uncounted = 0
+	meter.m1.update(instantRate, ticks-1)
+	meter.m5.update(instantRate, ticks-1)
+	meter.m15.update(instantRate, ticks-1)
+}
+
+// ewma is an exponentially weighted moving average of a rate in events per second
+type ewma struct {
+	alpha       float64
+	rate        float64
+	initialized bool
+}
+
+func newEWMA(window time.Duration) ewma {
+	return ewma{alpha: 1 - math.Exp(-meterTickInterval.Seconds()/window.Seconds())}
+}
+
+// update adds an interval with the given rate, followed by idleTicks intervals without events
+func (average *ewma) update(instantRate float64, idleTicks int64) {
+	if average.initialized {
+		average.rate += average.alpha * (instantRate - average.rate)
+	} else {
+		average.rate = instantRate
+		average.initialized = true
+	}
+	average.rate *= math.Pow(1-average.alpha, float64(idleTicks))
+}
+
+// MeterSnapshot contains the count and rates of a Meter at the moment the snapshot was taken. Rates are in events
+// per second and Started is the timestamp (millis since epoch) on which the meter was created.
+type MeterSnapshot struct {
+	Events  int64   `json:"count"`
+	M1      float64 `json:"m1Rate"`
+	M5      float64 `json:"m5Rate"`
+	M15     float64 `json:"m15Rate"`
+	Mean    float64 `json:"meanRate"`
+	Started int64   `json:"timestampStarted,omitempty"`
+}
+
+// Count returns the total number of events
+func (meter *MeterSnapshot) Count() int64 {
+	return meter.Events
+}
+
+// Rate1 returns the moving average rate over the last minute
+func (meter *MeterSnapshot) Rate1() float64 {
+	return meter.M1
+}
+
+// Rate5 returns the moving average rate over the last 5 minutes
+func (meter *MeterSnapshot) Rate5() float64 {
+	return meter.M5
+}
+
+// Rate15 returns the moving average rate over the last 15 minutes
+func (meter *MeterSnapshot) Rate15() float64 {
+	return meter.M15
+}
+
+// RateMean returns the mean rate since the meter was created
+func (meter *MeterSnapshot) RateMean() float64 {
+	return meter.Mean
+}
+
+// StartedTimestamp returns the timestamp (millis since epoch) on which the meter was created, or 0 when unknown
+func (meter *MeterSnapshot) StartedTimestamp() int64 {
+	return meter.Started
+}
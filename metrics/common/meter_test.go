@@ -0,0 +1,114 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when advanced
+type fakeClock struct {
+	current time.Time
+}
+
+func (clock *fakeClock) now() time.Time {
+	return clock.current
+}
+
+func (clock *fakeClock) advance(duration time.Duration) {
+	clock.current = clock.current.Add(duration)
+}
+
+func TestMeterConstantRate(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(1000, 0)}
+	meter := newMeterWithClock(clock.now)
+	for i := 0; i < 360; i++ { // 30 minutes of 10 events per second
+		meter.Mark(50)
+		clock.advance(5 * time.Second)
+	}
+	snapshot := meter.Snapshot()
+	if snapshot.Count() != 18000 {
+		t.Error("expected 18000 events but got", snapshot.Count())
+	}
+	if !closeTo(snapshot.Rate1(), 10, 0.001) || !closeTo(snapshot.Rate5(), 10, 0.001) {
+		t.Errorf("1 and 5 minute rates should be 10 but were %v and %v", snapshot.Rate1(), snapshot.Rate5())
+	}
+	if !closeTo(snapshot.Rate15(), 10, 0.001) {
+		t.Errorf("15 minute rate should be 10 but was %v", snapshot.Rate15())
+	}
+	if !closeTo(snapshot.RateMean(), 10, 0.001) {
+		t.Errorf("mean rate should be 10 but was %v", snapshot.RateMean())
+	}
+}
+
+func TestMeterDecaysWithoutEvents(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(1000, 0)}
+	meter := newMeterWithClock(clock.now)
+	meter.Mark(50)
+	clock.advance(5 * time.Second)
+	meter.Snapshot()
+	clock.advance(time.Minute)
+	snapshot := meter.Snapshot()
+	if !closeTo(snapshot.Rate1(), 10*math.Exp(-1), 0.0001) {
+		t.Errorf("after a minute without events the 1 minute rate should be 10/e but was %v", snapshot.Rate1())
+	}
+	if !closeTo(snapshot.Rate5(), 10*math.Exp(-0.2), 0.0001) {
+		t.Errorf("after a minute without events the 5 minute rate should be 10/e^0.2 but was %v", snapshot.Rate5())
+	}
+	if !closeTo(snapshot.RateMean(), 50/65.0, 0.0001) {
+		t.Errorf("mean rate should be 50/65 but was %v", snapshot.RateMean())
+	}
+	if again := meter.Snapshot(); *again != *snapshot {
+		t.Errorf("taking a snapshot should not change the meter, got %v after %v", again, snapshot)
+	}
+}
+
+func TestMeterRatesBeforeFirstTick(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(1000, 0)}
+	meter := newMeterWithClock(clock.now)
+	meter.Mark(3)
+	snapshot := meter.Snapshot()
+	if snapshot.Count() != 3 || snapshot.Rate1() != 0 || snapshot.RateMean() != 0 {
+		t.Errorf("before the first tick only the count should be known, got %+v", snapshot)
+	}
+}
+
+func TestMeterSnapshotHasTheCreationTime(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(1000, 0)}
+	meter := newMeterWithClock(clock.now)
+	clock.advance(time.Minute)
+	if started := meter.Snapshot().StartedTimestamp(); started != 1000000 {
+		t.Error("expected the meter to start at 1000000 millis but got", started)
+	}
+}
+
+func TestMeterSnapshotJSON(t *testing.T) {
+	data, _ := json.Marshal(&MeterSnapshot{Events: 5, M1: 1, M5: 2, M15: 3, Mean: 4})
+	expected := `{"count":5,"m1Rate":1,"m5Rate":2,"m15Rate":3,"meanRate":4}`
+	if string(data) != expected {
+		t.Errorf("marshalled meter was %v, expected %v", string(data), expected)
+	}
+}
+
+func closeTo(value, expected, epsilon float64) bool {
+	return math.Abs(value-expected) <= epsilon
+}
@@ -0,0 +1,203 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// relative error of the quantile estimates
+	sketchRelativeAccuracy = 0.01
+	// upper bound on the number of buckets kept by a sketch, bounds the memory used per distribution
+	sketchMaxBuckets = 2048
+	// values closer to zero than this are counted as zero
+	sketchMinIndexableValue = 1e-9
+)
+
+// quantileSketch estimates quantiles of a stream of values using constant memory. Values are counted in buckets
+// with logarithmically growing boundaries, so each estimate is within sketchRelativeAccuracy of the real value.
+// When more than sketchMaxBuckets buckets are needed, the buckets closest to zero are collapsed.
+type quantileSketch struct {
+	gamma    float64
+	logGamma float64
+	positive map[int]int64
+	negative map[int]int64
+	zeros    int64
+	count    int64
+}
+
+func newQuantileSketch() *quantileSketch {
+	gamma := (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	return &quantileSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]int64),
+		negative: make(map[int]int64),
+	}
+}
+
+func (sketch *quantileSketch) add(value float64) {
+	switch {
+	case value > sketchMinIndexableValue:
+		sketch.positive[sketch.index(value)]++
+	case value < -sketchMinIndexableValue:
+		sketch.negative[sketch.index(-value)]++
+	default:
+		sketch.zeros++
+	}
+	sketch.count++
+	sketch.collapse()
+}
+
+// quantile returns the estimated value at quantile q (0 <= q <= 1), or 0 if the sketch is empty
+func (sketch *quantileSketch) quantile(q float64) float64 {
+	if sketch.count == 0 {
+		return 0
+	}
+	// nearest rank, the value at quantile q is the smallest value with at least q * count values at or below it
+	rank := int64(math.Ceil(q * float64(sketch.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	cumulative := int64(0)
+	negatives := sortedIndexes(sketch.negative)
+	for i := len(negatives) - 1; i >= 0; i-- {
+		cumulative += sketch.negative[negatives[i]]
+		if cumulative >= rank {
+			return -sketch.value(negatives[i])
+		}
+	}
+	cumulative += sketch.zeros
+	if cumulative >= rank {
+		return 0
+	}
+	positives := sortedIndexes(sketch.positive)
+	for _, index := range positives {
+		cumulative += sketch.positive[index]
+		if cumulative >= rank {
+			return sketch.value(index)
+		}
+	}
+	return sketch.value(positives[len(positives)-1])
+}
+
+// merge adds all values counted by other to the sketch
+func (sketch *quantileSketch) merge(other *quantileSketch) {
+	for index, count := range other.positive {
+		sketch.positive[index] += count
+	}
+	for index, count := range other.negative {
+		sketch.negative[index] += count
+	}
+	sketch.zeros += other.zeros
+	sketch.count += other.count
+	sketch.collapse()
+}
+
+// subtract removes the values counted by other, which must have been counted by the sketch before. Counts of buckets
+// that were collapsed in the meantime are subtracted from the bucket they were collapsed into.
+func (sketch *quantileSketch) subtract(other *quantileSketch) {
+	subtractBuckets(sketch.positive, other.positive)
+	subtractBuckets(sketch.negative, other.negative)
+	sketch.zeros -= other.zeros
+	if sketch.zeros < 0 {
+		sketch.zeros = 0
+	}
+	sketch.count = sketch.zeros
+	for _, count := range sketch.positive {
+		sketch.count += count
+	}
+	for _, count := range sketch.negative {
+		sketch.count += count
+	}
+}
+
+func (sketch *quantileSketch) copy() *quantileSketch {
+	sketchCopy := *sketch
+	sketchCopy.positive = copyBuckets(sketch.positive)
+	sketchCopy.negative = copyBuckets(sketch.negative)
+	return &sketchCopy
+}
+
+func (sketch *quantileSketch) index(magnitude float64) int {
+	return int(math.Ceil(math.Log(magnitude) / sketch.logGamma))
+}
+
+// value returns the magnitude that represents all values in the bucket with the given index
+func (sketch *quantileSketch) value(index int) float64 {
+	return 2 * math.Pow(sketch.gamma, float64(index)) / (sketch.gamma + 1)
+}
+
+// collapse merges the buckets closest to zero until the sketch is within its memory bound again
+func (sketch *quantileSketch) collapse() {
+	for len(sketch.positive)+len(sketch.negative) > sketchMaxBuckets {
+		buckets := sketch.positive
+		if len(sketch.negative) > len(sketch.positive) {
+			buckets = sketch.negative
+		}
+		indexes := sortedIndexes(buckets)
+		buckets[indexes[1]] += buckets[indexes[0]]
+		delete(buckets, indexes[0])
+	}
+}
+
+func sortedIndexes(buckets map[int]int64) []int {
+	indexes := make([]int, 0, len(buckets))
+	for index := range buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func copyBuckets(source map[int]int64) map[int]int64 {
+	bucketsCopy := make(map[int]int64, len(source))
+	for index, count := range source {
+		bucketsCopy[index] = count
+	}
+	return bucketsCopy
+}
+
+// subtractBuckets subtracts the counts of other from buckets. Collapsing moves counts to the next bucket away from
+// zero, so counts that cannot be subtracted from their own bucket are subtracted from the next ones.
+func subtractBuckets(buckets, other map[int]int64) {
+	union := make(map[int]int64, len(buckets)+len(other))
+	for index := range buckets {
+		union[index] = 0
+	}
+	for index := range other {
+		union[index] = 0
+	}
+	deficit := int64(0)
+	for _, index := range sortedIndexes(union) {
+		deficit += other[index]
+		subtracted := deficit
+		if buckets[index] < subtracted {
+			subtracted = buckets[index]
+		}
+		deficit -= subtracted
+		if buckets[index] == subtracted {
+			delete(buckets, index)
+		} else {
+			buckets[index] -= subtracted
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuantileSketchEmpty(t *testing.T) {
+	if q := newQuantileSketch().quantile(0.5); q != 0 {
+		t.Errorf("an empty sketch should return 0 but returned %v", q)
+	}
+}
+
+func TestQuantileSketchWithinRelativeAccuracy(t *testing.T) {
+	sketch := newQuantileSketch()
+	for i := 1; i <= 10000; i++ {
+		sketch.add(float64(i))
+	}
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99, 0.999} {
+		expected := q*9999 + 1
+		if estimate := sketch.quantile(q); math.Abs(estimate-expected) > expected*sketchRelativeAccuracy {
+			t.Errorf("quantile %v was estimated at %v, expected %v within 1%%", q, estimate, expected)
+		}
+	}
+}
+
+func TestQuantileSketchNegativeAndZeroValues(t *testing.T) {
+	sketch := newQuantileSketch()
+	for _, value := range []float64{-100, -10, 0, 10, 100} {
+		sketch.add(value)
+	}
+	expectations := map[float64]float64{0: -100, 0.25: -10, 0.5: 0, 0.75: 10, 1: 100}
+	for q, expected := range expectations {
+		if estimate := sketch.quantile(q); math.Abs(estimate-expected) > math.Abs(expected)*sketchRelativeAccuracy {
+			t.Errorf("quantile %v was estimated at %v, expected %v", q, estimate, expected)
+		}
+	}
+}
+
+func TestQuantileSketchMemoryIsBounded(t *testing.T) {
+	sketch := newQuantileSketch()
+	for exponent := -300; exponent <= 300; exponent++ {
+		for i := 1; i < 10; i++ {
+			sketch.add(float64(i) * math.Pow(10, float64(exponent)))
+		}
+	}
+	if buckets := len(sketch.positive) + len(sketch.negative); buckets > sketchMaxBuckets {
+		t.Errorf("sketch uses %v buckets, should be at most %v", buckets, sketchMaxBuckets)
+	}
+	if sketch.count != 601*9 {
+		t.Errorf("collapsing buckets should not lose samples, count = %v", sketch.count)
+	}
+}
+
+func TestQuantileSketchCopyIsDisconnected(t *testing.T) {
+	sketch := newQuantileSketch()
+	sketch.add(10)
+	sketchCopy := sketch.copy()
+	sketch.add(1000)
+	sketch.add(1000)
+	if sketchCopy.count != 1 || math.Abs(sketchCopy.quantile(0.5)-10) > 0.1 {
+		t.Error("samples added to the original sketch should not affect the copy")
+	}
+}
+
+func TestQuantileSketchSubtractCollapsedBuckets(t *testing.T) {
+	buckets := map[int]int64{5: 3, 7: 2}
+	// the counts of bucket 4 were collapsed into bucket 5
+	subtractBuckets(buckets, map[int]int64{4: 2, 7: 1})
+	if len(buckets) != 2 || buckets[5] != 1 || buckets[7] != 1 {
+		t.Errorf("expected 1 remaining value in bucket 5 and 7 but got %v", buckets)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// SeriesKey returns the canonical identity of key combined with tags, which is the key followed by the tags sorted
+// by name, for example http.requests{method=GET,status=200}. Without tags, the series key is the key itself, as it
+// always was. The characters \ , = { and } in tag names and values are escaped with a backslash, and so are \ and {
+// in the key of a tagged series. A plain key that is written like a tagged series key, such as
+// http.requests{method=GET}, is the same series as the tagged one. Snapshots of a facade record which series are
+// tagged, see Snapshot.Series.
+func SeriesKey(key string, tags api.Tags) string {
+	if len(tags) == 0 {
+		return key
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var series strings.Builder
+	series.WriteString(escapeKey(key))
+	series.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			series.WriteByte(',')
+		}
+		series.WriteString(escapeTag(name))
+		series.WriteByte('=')
+		series.WriteString(escapeTag(tags[name]))
+	}
+	series.WriteByte('}')
+	return series.String()
+}
+
+// ParseSeriesKey splits a series key created with SeriesKey in its key and tags. Returns nil tags if the series key
+// has no tags. A plain key that is written like a tagged series key is read as a tagged series, use Snapshot.Series
+// to tell them apart.
+func ParseSeriesKey(series string) (string, api.Tags) {
+	start := tagsStart(series)
+	if start < 0 {
+		return series, nil
+	}
+	if !strings.HasSuffix(series, "}") {
+		return series, nil
+	}
+	tags := make(api.Tags)
+	var name, current strings.Builder
+	inValue := false
+	body := series[start+1 : len(series)-1]
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\\' && i+1 < len(body):
+			i++
+			current.WriteByte(body[i])
+		case c == '=' && !inValue:
+			name.WriteString(current.String())
+			current.Reset()
+			inValue = true
+		case c == ',' && inValue:
+			tags[name.String()] = current.String()
+			name.Reset()
+			current.Reset()
+			inValue = false
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if !inValue {
+		return series, nil
+	}
+	tags[name.String()] = current.String()
+	return unescapeKey(series[:start]), tags
+}
+
+// SplitSeriesKey returns the key and tags of a series key, as recorded in the tagged series of its snapshot, see
+// api.Snapshot.Series. A series key that is not one of the tagged series is a plain key.
+func SplitSeriesKey(series map[string]api.Series, seriesKey string) (string, api.Tags) {
+	if tagged, exists := series[seriesKey]; exists {
+		return tagged.Key, tagged.Tags
+	}
+	return seriesKey, nil
+}
+
+// tagsStart returns the index of the first { that is not escaped, or -1 when there is none
+func tagsStart(series string) int {
+	for i := 0; i < len(series); i++ {
+		switch series[i] {
+		case '\\':
+			i++
+		case '{':
+			return i
+		}
+	}
+	return -1
+}
+
+var keyEscaper = strings.NewReplacer(`\`, `\\`, `{`, `\{`)
+
+func escapeKey(key string) string {
+	if !strings.ContainsAny(key, `\{`) {
+		return key
+	}
+	return keyEscaper.Replace(key)
+}
+
+func unescapeKey(key string) string {
+	if strings.IndexByte(key, '\\') < 0 {
+		return key
+	}
+	unescaped := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		if key[i] == '\\' && i+1 < len(key) {
+			i++
+		}
+		unescaped = append(unescaped, key[i])
+	}
+	return string(unescaped)
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `=`, `\=`, `{`, `\{`, `}`, `\}`)
+
+func escapeTag(value string) string {
+	return tagEscaper.Replace(value)
+}
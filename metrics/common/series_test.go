@@ -0,0 +1,96 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+func TestSeriesKey(t *testing.T) {
+	expectations := []struct {
+		key      string
+		tags     api.Tags
+		expected string
+	}{
+		{"http.requests", nil, "http.requests"},
+		{"http.requests", api.Tags{}, "http.requests"},
+		{"http.requests", api.Tags{"status": "200", "method": "GET"}, "http.requests{method=GET,status=200}"},
+		{"odd", api.Tags{"a=b": "c,d", "e": `{f\}`}, `odd{a\=b=c\,d,e=\{f\\\}}`},
+		{"empty", api.Tags{"tag": ""}, "empty{tag=}"},
+	}
+	for _, expectation := range expectations {
+		series := SeriesKey(expectation.key, expectation.tags)
+		if series != expectation.expected {
+			t.Errorf("SeriesKey(%v, %v) = %v, expected %v", expectation.key, expectation.tags, series, expectation.expected)
+		}
+		key, tags := ParseSeriesKey(series)
+		if key != expectation.key {
+			t.Errorf("ParseSeriesKey(%v) returned key %v, expected %v", series, key, expectation.key)
+		}
+		if len(expectation.tags) > 0 && !reflect.DeepEqual(tags, expectation.tags) {
+			t.Errorf("ParseSeriesKey(%v) returned tags %v, expected %v", series, tags, expectation.tags)
+		}
+		if len(expectation.tags) == 0 && tags != nil {
+			t.Errorf("ParseSeriesKey(%v) should return nil tags but returned %v", series, tags)
+		}
+	}
+}
+
+func TestParseSeriesKeyWithoutTags(t *testing.T) {
+	for _, series := range []string{"plain.key", "with{brace", "with{}", "no{tags}"} {
+		if key, tags := ParseSeriesKey(series); key != series || tags != nil {
+			t.Errorf("ParseSeriesKey(%v) = %v, %v, expected the series itself without tags", series, key, tags)
+		}
+	}
+}
+
+func TestSeriesKeyKeepsPlainKeys(t *testing.T) {
+	for _, key := range []string{"pool{x}", `C:\dir`, `back\slash{`, "http.requests{method=GET}"} {
+		if series := SeriesKey(key, nil); series != key {
+			t.Errorf("SeriesKey(%v, nil) = %v, expected the key itself", key, series)
+		}
+	}
+	if key, tags := ParseSeriesKey(`C:\dir`); key != `C:\dir` || tags != nil {
+		t.Errorf("ParseSeriesKey should keep a plain key with a backslash as it is, got %v %v", key, tags)
+	}
+}
+
+func TestSeriesKeyEscapesKey(t *testing.T) {
+	expectations := []struct {
+		key      string
+		tags     api.Tags
+		expected string
+	}{
+		{`a{b\c`, api.Tags{"d": "e"}, `a\{b\\c{d=e}`},
+		{`back\slash`, api.Tags{"d": "e"}, `back\\slash{d=e}`},
+	}
+	for _, expectation := range expectations {
+		series := SeriesKey(expectation.key, expectation.tags)
+		if series != expectation.expected {
+			t.Errorf("SeriesKey(%v, %v) = %v, expected %v", expectation.key, expectation.tags, series, expectation.expected)
+		}
+		key, tags := ParseSeriesKey(series)
+		if key != expectation.key || !reflect.DeepEqual(tags, expectation.tags) {
+			t.Errorf("ParseSeriesKey(%v) = %v, %v, expected %v, %v", series, key, tags, expectation.key, expectation.tags)
+		}
+	}
+}
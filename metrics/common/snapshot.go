@@ -18,7 +18,12 @@
 
 package common
 
-import "github.com/toefel18/go-patan/metrics/api"
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
 
 // Snapshot contains a copy of all the measurements recorded between TimestampStarted and TimestampCreated
 type Snapshot struct {
@@ -27,6 +32,15 @@ type Snapshot struct {
 	DurationsSnapshot map[string]api.Distribution `json:"durations"`
 	CountersSnapshot  map[string]int64            `json:"counters"`
 	SamplesSnapshot   map[string]api.Distribution `json:"samples"`
+	GaugesSnapshot    map[string]float64          `json:"gauges,omitempty"`
+	MetersSnapshot    map[string]api.Meter        `json:"meters,omitempty"`
+	// SeriesSnapshot contains the key and tags of the tagged series, it is nil when the snapshot does not know which
+	// series are tagged, see Series
+	SeriesSnapshot map[string]api.Series `json:"series,omitempty"`
+	// resets is the number of times the store was reset before the snapshot was taken, when tracksResets is true.
+	// Snapshots that are read or created by hand do not track resets.
+	resets       uint64
+	tracksResets bool
 }
 
 // CreatedTimestamp returns the timestamp (millis since epoch) on which the snapshot was created
@@ -53,3 +67,411 @@ func (sh *Snapshot) Counters() map[string]int64 {
 func (sh *Snapshot) Samples() map[string]api.Distribution {
 	return sh.SamplesSnapshot
 }
+
+// Gauges returns the map of gauge values
+func (sh *Snapshot) Gauges() map[string]float64 {
+	if sh.GaugesSnapshot == nil {
+		return map[string]float64{}
+	}
+	return sh.GaugesSnapshot
+}
+
+// Meters returns the map of meters
+func (sh *Snapshot) Meters() map[string]api.Meter {
+	if sh.MetersSnapshot == nil {
+		return map[string]api.Meter{}
+	}
+	return sh.MetersSnapshot
+}
+
+// Series returns the key and tags of every tagged counter, duration, sample, gauge and meter, indexed by series key.
+// The tagged series are taken from SeriesSnapshot, which facades fill in. Snapshots without SeriesSnapshot, such as
+// those of the java version, do not know which series are tagged, the series keys are parsed with ParseSeriesKey then.
+func (sh *Snapshot) Series() map[string]api.Series {
+	series := make(map[string]api.Series)
+	if sh.SeriesSnapshot != nil {
+		for seriesKey, tagged := range sh.SeriesSnapshot {
+			if sh.contains(seriesKey) {
+				series[seriesKey] = tagged
+			}
+		}
+		return series
+	}
+	addSeries := func(seriesKey string) {
+		if key, tags := ParseSeriesKey(seriesKey); tags != nil {
+			series[seriesKey] = api.Series{Key: key, Tags: tags}
+		}
+	}
+	for seriesKey := range sh.DurationsSnapshot {
+		addSeries(seriesKey)
+	}
+	for seriesKey := range sh.CountersSnapshot {
+		addSeries(seriesKey)
+	}
+	for seriesKey := range sh.SamplesSnapshot {
+		addSeries(seriesKey)
+	}
+	for seriesKey := range sh.GaugesSnapshot {
+		addSeries(seriesKey)
+	}
+	for seriesKey := range sh.MetersSnapshot {
+		addSeries(seriesKey)
+	}
+	return series
+}
+
+// contains returns true when the snapshot has a counter, duration, sample, gauge or meter with the series key
+func (sh *Snapshot) contains(seriesKey string) bool {
+	if _, exists := sh.CountersSnapshot[seriesKey]; exists {
+		return true
+	}
+	if _, exists := sh.DurationsSnapshot[seriesKey]; exists {
+		return true
+	}
+	if _, exists := sh.SamplesSnapshot[seriesKey]; exists {
+		return true
+	}
+	if _, exists := sh.GaugesSnapshot[seriesKey]; exists {
+		return true
+	}
+	_, exists := sh.MetersSnapshot[seriesKey]
+	return exists
+}
+
+// MarshalJSON writes the snapshot in the format read by ParseSnapshot. JSON has no NaN and infinite numbers, so gauges
+// with such a value are left out, and so are distributions and meters that cannot be encoded, such as a distribution
+// with a NaN sample, instead of failing the whole snapshot.
+func (sh *Snapshot) MarshalJSON() ([]byte, error) {
+	type snapshotJSON Snapshot
+	encoded := snapshotJSON(*sh)
+	for _, value := range sh.GaugesSnapshot {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			encoded.GaugesSnapshot = finiteGauges(sh.GaugesSnapshot)
+			break
+		}
+	}
+	data, err := json.Marshal(&encoded)
+	if err == nil {
+		return data, nil
+	}
+	encoded.DurationsSnapshot = encodableDistributions(sh.DurationsSnapshot)
+	encoded.SamplesSnapshot = encodableDistributions(sh.SamplesSnapshot)
+	encoded.MetersSnapshot = encodableMeters(sh.MetersSnapshot)
+	return json.Marshal(&encoded)
+}
+
+// finiteGauges returns a copy of gauges without the NaN and infinite values
+func finiteGauges(gauges map[string]float64) map[string]float64 {
+	finite := make(map[string]float64, len(gauges))
+	for key, value := range gauges {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			finite[key] = value
+		}
+	}
+	return finite
+}
+
+// encodableDistributions returns a copy of distributions without the distributions that json.Marshal fails on, or nil
+// when distributions is nil
+func encodableDistributions(distributions map[string]api.Distribution) map[string]api.Distribution {
+	if distributions == nil {
+		return nil
+	}
+	encodable := make(map[string]api.Distribution, len(distributions))
+	for key, dist := range distributions {
+		if _, err := json.Marshal(dist); err == nil {
+			encodable[key] = dist
+		}
+	}
+	return encodable
+}
+
+// encodableMeters returns a copy of meters without the meters that json.Marshal fails on, or nil when meters is nil
+func encodableMeters(meters map[string]api.Meter) map[string]api.Meter {
+	if meters == nil {
+		return nil
+	}
+	encodable := make(map[string]api.Meter, len(meters))
+	for key, meter := range meters {
+		if _, err := json.Marshal(meter); err == nil {
+			encodable[key] = meter
+		}
+	}
+	return encodable
+}
+
+// ParseSnapshot reads a snapshot from its json representation, as written by json.Marshal or by the java version of
+// patan. Distributions are read as *Distribution, see Distribution.UnmarshalJSON.
+func ParseSnapshot(data []byte) (api.Snapshot, error) {
+	var parsed struct {
+		TimestampStarted int64                     `json:"timestampStarted"`
+		TimestampCreated int64                     `json:"timestampTaken"`
+		Durations        map[string]*Distribution  `json:"durations"`
+		Counters         map[string]int64          `json:"counters"`
+		Samples          map[string]*Distribution  `json:"samples"`
+		Gauges           map[string]float64        `json:"gauges"`
+		Meters           map[string]*MeterSnapshot `json:"meters"`
+		Series           map[string]api.Series     `json:"series"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{
+		TimestampStarted:  parsed.TimestampStarted,
+		TimestampCreated:  parsed.TimestampCreated,
+		DurationsSnapshot: toAPIDistributions(parsed.Durations),
+		CountersSnapshot:  parsed.Counters,
+		SamplesSnapshot:   toAPIDistributions(parsed.Samples),
+		GaugesSnapshot:    parsed.Gauges,
+		SeriesSnapshot:    parsed.Series,
+	}
+	if snapshot.CountersSnapshot == nil {
+		snapshot.CountersSnapshot = make(map[string]int64)
+	}
+	if len(parsed.Meters) > 0 {
+		snapshot.MetersSnapshot = make(map[string]api.Meter, len(parsed.Meters))
+		for key, meter := range parsed.Meters {
+			if meter != nil {
+				snapshot.MetersSnapshot[key] = meter
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+func toAPIDistributions(source map[string]*Distribution) map[string]api.Distribution {
+	distributions := make(map[string]api.Distribution, len(source))
+	for key, distribution := range source {
+		if distribution != nil {
+			distributions[key] = distribution
+		}
+	}
+	return distributions
+}
+
+// FilterSnapshot returns a copy of snapshot that only contains the durations, counters, samples, gauges and meters whose key
+// is accepted by include
+func FilterSnapshot(snapshot api.Snapshot, include func(key string) bool) api.Snapshot {
+	filtered := &Snapshot{
+		TimestampStarted:  snapshot.StartedTimestamp(),
+		TimestampCreated:  snapshot.CreatedTimestamp(),
+		DurationsSnapshot: make(map[string]api.Distribution),
+		CountersSnapshot:  make(map[string]int64),
+		SamplesSnapshot:   make(map[string]api.Distribution),
+		GaugesSnapshot:    make(map[string]float64),
+		MetersSnapshot:    make(map[string]api.Meter),
+	}
+	filtered.resets, filtered.tracksResets = resetCount(snapshot)
+	for key, distribution := range snapshot.Durations() {
+		if include(key) {
+			filtered.DurationsSnapshot[key] = distribution
+		}
+	}
+	for key, value := range snapshot.Counters() {
+		if include(key) {
+			filtered.CountersSnapshot[key] = value
+		}
+	}
+	for key, distribution := range snapshot.Samples() {
+		if include(key) {
+			filtered.SamplesSnapshot[key] = distribution
+		}
+	}
+	for key, value := range snapshot.Gauges() {
+		if include(key) {
+			filtered.GaugesSnapshot[key] = value
+		}
+	}
+	for key, meter := range snapshot.Meters() {
+		if include(key) {
+			filtered.MetersSnapshot[key] = meter
+		}
+	}
+	filtered.SeriesSnapshot = filtered.seriesOf(snapshot)
+	return filtered
+}
+
+// MergeSnapshots combines snapshots, of different processes for example, into one fleet-wide snapshot. Counters,
+// gauges and the counts and rates of meters with the same key are summed and durations and samples with the same key
+// are merged. The time window of the result starts at the earliest started timestamp and ends at the latest created
+// timestamp. The given snapshots are not modified.
+func MergeSnapshots(snapshots ...api.Snapshot) api.Snapshot {
+	merged := &Snapshot{
+		DurationsSnapshot: make(map[string]api.Distribution),
+		CountersSnapshot:  make(map[string]int64),
+		SamplesSnapshot:   make(map[string]api.Distribution),
+		GaugesSnapshot:    make(map[string]float64),
+		MetersSnapshot:    make(map[string]api.Meter),
+		tracksResets:      len(snapshots) > 0,
+	}
+	for i, snapshot := range snapshots {
+		// the merged snapshot changes its reset count when any of the snapshots does
+		resets, tracksResets := resetCount(snapshot)
+		merged.resets += resets
+		merged.tracksResets = merged.tracksResets && tracksResets
+		if i == 0 || snapshot.StartedTimestamp() < merged.TimestampStarted {
+			merged.TimestampStarted = snapshot.StartedTimestamp()
+		}
+		if i == 0 || snapshot.CreatedTimestamp() > merged.TimestampCreated {
+			merged.TimestampCreated = snapshot.CreatedTimestamp()
+		}
+		for key, value := range snapshot.Counters() {
+			merged.CountersSnapshot[key] += value
+		}
+		for key, value := range snapshot.Gauges() {
+			merged.GaugesSnapshot[key] += value
+		}
+		for key, meter := range snapshot.Meters() {
+			mergeMeter(merged.MetersSnapshot, key, meter)
+		}
+		mergeDistributions(merged.DurationsSnapshot, snapshot.Durations())
+		mergeDistributions(merged.SamplesSnapshot, snapshot.Samples())
+	}
+	merged.SeriesSnapshot = make(map[string]api.Series)
+	for _, snapshot := range snapshots {
+		for seriesKey, tagged := range snapshot.Series() {
+			merged.SeriesSnapshot[seriesKey] = tagged
+		}
+	}
+	return merged
+}
+
+func mergeDistributions(destination, source map[string]api.Distribution) {
+	for key, distribution := range source {
+		if existing, exists := destination[key]; exists {
+			destination[key] = mergeDistribution(existing, distribution)
+		} else if hdr, ok := distribution.(*HdrDistribution); ok {
+			destination[key] = hdr.Copy()
+		} else {
+			destination[key] = DistributionOf(distribution)
+		}
+	}
+}
+
+// mergeDistribution merges other into target, which is owned by the caller. HdrDistributions are only kept when both
+// are HdrDistributions, otherwise the result is a *Distribution
+func mergeDistribution(target, other api.Distribution) api.Distribution {
+	if targetHdr, ok := target.(*HdrDistribution); ok {
+		if otherHdr, ok := other.(*HdrDistribution); ok {
+			targetHdr.Merge(otherHdr)
+			return targetHdr
+		}
+	}
+	result, ok := target.(*Distribution)
+	if !ok {
+		result = DistributionOf(target)
+	}
+	if otherDist, ok := other.(*Distribution); ok {
+		result.Merge(otherDist)
+	} else {
+		result.Merge(DistributionOf(other))
+	}
+	return result
+}
+
+// mergeMeter adds the count and rates of meter to the meter with the same key in destination, the merged meter starts
+// when the earliest of them was created
+func mergeMeter(destination map[string]api.Meter, key string, meter api.Meter) {
+	merged, exists := destination[key].(*MeterSnapshot)
+	if !exists {
+		merged = &MeterSnapshot{}
+		destination[key] = merged
+	}
+	merged.Events += meter.Count()
+	merged.M1 += meter.Rate1()
+	merged.M5 += meter.Rate5()
+	merged.M15 += meter.Rate15()
+	merged.Mean += meter.RateMean()
+	if started := meter.StartedTimestamp(); started != 0 && (merged.Started == 0 || started < merged.Started) {
+		merged.Started = started
+	}
+}
+
+// SubtractSnapshot returns what changed between two snapshots of the same facade, where previous was taken before
+// current. Counters contain the difference, durations and samples only contain the samples added in between, see
+// Distribution.Subtract, and keys without new samples are left out. Gauges and meters are taken from current. The
+// time window of the result starts when previous was created. When the facade was reset in between, current is
+// returned as is, it contains everything since the reset. Resets are detected with the reset count of snapshots
+// taken from a store, and with the started timestamp otherwise. The given snapshots are not modified.
+func SubtractSnapshot(current, previous api.Snapshot) api.Snapshot {
+	if previous == nil || wasReset(current, previous) {
+		return current
+	}
+	delta := &Snapshot{
+		TimestampStarted:  previous.CreatedTimestamp(),
+		TimestampCreated:  current.CreatedTimestamp(),
+		DurationsSnapshot: subtractDistributions(current.Durations(), previous.Durations()),
+		CountersSnapshot:  make(map[string]int64, len(current.Counters())),
+		SamplesSnapshot:   subtractDistributions(current.Samples(), previous.Samples()),
+		GaugesSnapshot:    current.Gauges(),
+		MetersSnapshot:    current.Meters(),
+	}
+	previousCounters := previous.Counters()
+	for key, value := range current.Counters() {
+		delta.CountersSnapshot[key] = value - previousCounters[key]
+	}
+	delta.SeriesSnapshot = delta.seriesOf(current)
+	return delta
+}
+
+// seriesOf returns the tagged series of source that sh contains
+func (sh *Snapshot) seriesOf(source api.Snapshot) map[string]api.Series {
+	series := make(map[string]api.Series)
+	for seriesKey, tagged := range source.Series() {
+		if sh.contains(seriesKey) {
+			series[seriesKey] = tagged
+		}
+	}
+	return series
+}
+
+// wasReset returns true when the store of the snapshots was reset between taking previous and current
+func wasReset(current, previous api.Snapshot) bool {
+	currentResets, currentTracks := resetCount(current)
+	previousResets, previousTracks := resetCount(previous)
+	if currentTracks && previousTracks {
+		return currentResets != previousResets
+	}
+	// the started timestamp only has a resolution of a millisecond
+	return current.StartedTimestamp() != previous.StartedTimestamp()
+}
+
+// resetCount returns the number of resets of the store of snapshot, ok is false when the snapshot does not track them
+func resetCount(snapshot api.Snapshot) (resets uint64, ok bool) {
+	if typed, isSnapshot := snapshot.(*Snapshot); isSnapshot {
+		return typed.resets, typed.tracksResets
+	}
+	return 0, false
+}
+
+func subtractDistributions(current, previous map[string]api.Distribution) map[string]api.Distribution {
+	delta := make(map[string]api.Distribution, len(current))
+	for key, distribution := range current {
+		previousDistribution, exists := previous[key]
+		if !exists {
+			delta[key] = distribution
+		} else if distribution.SampleCount() > previousDistribution.SampleCount() {
+			delta[key] = subtractDistribution(distribution, previousDistribution)
+		}
+	}
+	return delta
+}
+
+// subtractDistribution subtracts previous from a copy of current. HdrDistributions are only kept when both are
+// HdrDistributions with the same configuration, otherwise the result is a *Distribution
+func subtractDistribution(current, previous api.Distribution) api.Distribution {
+	if currentHdr, ok := current.(*HdrDistribution); ok {
+		if previousHdr, ok := previous.(*HdrDistribution); ok && currentHdr.sameConfiguration(previousHdr) {
+			delta := currentHdr.Copy()
+			delta.Subtract(previousHdr)
+			return delta
+		}
+	}
+	delta := DistributionOf(current)
+	if previousDist, ok := previous.(*Distribution); ok {
+		delta.Subtract(previousDist)
+	} else {
+		delta.Subtract(DistributionOf(previous))
+	}
+	return delta
+}
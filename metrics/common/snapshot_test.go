@@ -19,8 +19,12 @@
 package common
 
 import (
+	"encoding/json"
 	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common/commontest"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +55,420 @@ func TestSnapshot(t *testing.T) {
 		t.Error("Counters returns a different instance than expected")
 	}
 }
+
+func TestMergeSnapshots(t *testing.T) {
+	first := NewDistribution()
+	first.AddSample(10)
+	second := NewDistribution()
+	second.AddSample(20)
+	hdr := NewHdrDistribution(1, 1000, 3)
+	hdr.AddSample(30)
+	snapshot1 := &Snapshot{
+		TimestampStarted:  1000,
+		TimestampCreated:  5000,
+		DurationsSnapshot: map[string]api.Distribution{"duration": first, "only.in.first": first},
+		CountersSnapshot:  map[string]int64{"counter": 5, "first.counter": 1},
+		SamplesSnapshot:   map[string]api.Distribution{"sample": hdr},
+		GaugesSnapshot:    map[string]float64{"queue.depth": 3, "pool.size": 8},
+	}
+	snapshot2 := &Snapshot{
+		TimestampStarted:  500,
+		TimestampCreated:  4000,
+		DurationsSnapshot: map[string]api.Distribution{"duration": second},
+		CountersSnapshot:  map[string]int64{"counter": -2},
+		SamplesSnapshot:   map[string]api.Distribution{"sample": second},
+		GaugesSnapshot:    map[string]float64{"queue.depth": 4},
+	}
+	merged := MergeSnapshots(snapshot1, snapshot2)
+	if merged.StartedTimestamp() != 500 || merged.CreatedTimestamp() != 5000 {
+		t.Errorf("merged time window should be widened to 500-5000 but was %v-%v", merged.StartedTimestamp(), merged.CreatedTimestamp())
+	}
+	if merged.Counters()["counter"] != 3 || merged.Counters()["first.counter"] != 1 {
+		t.Errorf("counters should be summed but got %v", merged.Counters())
+	}
+	if merged.Gauges()["queue.depth"] != 7 || merged.Gauges()["pool.size"] != 8 {
+		t.Errorf("gauges should be summed but got %v", merged.Gauges())
+	}
+	commontest.AssertDistributionHasValues(merged.Durations()["duration"], 2, 10, 20, 15, 7.0710, t)
+	commontest.AssertDistributionHasValues(merged.Durations()["only.in.first"], 1, 10, 10, 10, 0, t)
+	commontest.AssertDistributionHasValues(merged.Samples()["sample"], 2, 20, 30, 25, 7.0710, t)
+	commontest.AssertDistributionHasValues(first, 1, 10, 10, 10, 0, t)
+	commontest.AssertDistributionHasValues(hdr, 1, 30, 30, 30, 0, t)
+}
+
+func TestMergeNoSnapshots(t *testing.T) {
+	merged := MergeSnapshots()
+	if len(merged.Counters())+len(merged.Durations())+len(merged.Samples()) != 0 {
+		t.Error("merging no snapshots should result in an empty snapshot")
+	}
+}
+
+// readmeSnapshot is the example output in README.md
+const readmeSnapshot = `{
+  "timestampStarted": 1480792554683,
+  "timestampTaken": 1480792556683,
+  "durations": {
+    "my.heavy.operation": {
+      "sampleCount": 1,
+      "minimum": 2000.129942,
+      "maximum": 2000.129942,
+      "mean": 2000.129942,
+      "stdDeviation": 0,
+      "variance": 0,
+      "percentiles": {
+        "p50": 2000.129942,
+        "p90": 2000.129942,
+        "p95": 2000.129942,
+        "p99": 2000.129942,
+        "p999": 2000.129942
+      }
+    }
+  },
+  "counters": {
+    "active.sessions": 131
+  },
+  "samples": {
+    "mem.allocations": {
+      "sampleCount": 3,
+      "minimum": 111.9216,
+      "maximum": 167.0334,
+      "mean": 137.47453333333334,
+      "stdDeviation": 27.77342707001304,
+      "variance": 771.3632512133331,
+      "percentiles": {
+        "p50": 132.968599622102,
+        "p90": 165.69029301849304,
+        "p95": 165.69029301849304,
+        "p99": 165.69029301849304,
+        "p999": 165.69029301849304
+      }
+    },
+    "mem.collects": {
+      "sampleCount": 1,
+      "minimum": 2,
+      "maximum": 2,
+      "mean": 2,
+      "stdDeviation": 0,
+      "variance": 0,
+      "percentiles": {
+        "p50": 2,
+        "p90": 2,
+        "p95": 2,
+        "p99": 2,
+        "p999": 2
+      }
+    }
+  }
+}
+`
+
+// javaSnapshot is the output of the java version of patan, which lacks variance and percentiles
+const javaSnapshot = `{
+  "timestampStarted": 1480792554683,
+  "timestampTaken": 1480792556683,
+  "durations": {
+    "my.heavy.operation": {"sampleCount": 1, "minimum": 2000.129942, "maximum": 2000.129942, "mean": 2000.129942, "stdDeviation": 0}
+  },
+  "counters": {"active.sessions": 131},
+  "samples": {
+    "mem.allocations": {"sampleCount": 3, "minimum": 111.9216, "maximum": 167.0334, "mean": 137.47453333333334, "stdDeviation": 27.77342707001304}
+  }
+}`
+
+func TestParseSnapshot(t *testing.T) {
+	for _, data := range []string{readmeSnapshot, javaSnapshot} {
+		snapshot, err := ParseSnapshot([]byte(data))
+		if err != nil {
+			t.Fatal("parsing snapshot failed", err)
+		}
+		if snapshot.StartedTimestamp() != 1480792554683 || snapshot.CreatedTimestamp() != 1480792556683 {
+			t.Errorf("timestamps were parsed as %v and %v", snapshot.StartedTimestamp(), snapshot.CreatedTimestamp())
+		}
+		if snapshot.Counters()["active.sessions"] != 131 {
+			t.Errorf("counter active.sessions should be 131 but was %v", snapshot.Counters()["active.sessions"])
+		}
+		commontest.AssertDistributionHasValues(snapshot.Durations()["my.heavy.operation"], 1, 2000.129942, 2000.129942, 2000.129942, 0, t)
+		allocations := snapshot.Samples()["mem.allocations"]
+		commontest.AssertDistributionHasValues(allocations, 3, 111.9216, 167.0334, 137.47453333333334, 27.77342707001304, t)
+		if variance := allocations.(*Distribution).Variance(); !commontest.FloatEquals(variance, 771.3632512133331) {
+			t.Errorf("variance of mem.allocations should be 771.363 but was %v", variance)
+		}
+	}
+}
+
+func TestParseSnapshotRoundTrip(t *testing.T) {
+	snapshot, err := ParseSnapshot([]byte(readmeSnapshot))
+	if err != nil {
+		t.Fatal("parsing snapshot failed", err)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		t.Fatal("marshalling parsed snapshot failed", err)
+	}
+	if string(data)+"\n" != readmeSnapshot {
+		t.Errorf("round trip changed the snapshot, got:\n%v", string(data))
+	}
+}
+
+func TestParseSnapshotRoundTripMergesLikeOriginal(t *testing.T) {
+	dist := NewDistribution()
+	for i := 1; i <= 10; i++ {
+		dist.AddSample(float64(i * i))
+	}
+	original := &Snapshot{
+		DurationsSnapshot: map[string]api.Distribution{"duration": dist},
+		CountersSnapshot:  map[string]int64{},
+		SamplesSnapshot:   map[string]api.Distribution{},
+	}
+	data, _ := json.Marshal(original)
+	parsed, err := ParseSnapshot(data)
+	if err != nil {
+		t.Fatal("parsing snapshot failed", err)
+	}
+	expected := MergeSnapshots(original, original).Durations()["duration"]
+	merged := MergeSnapshots(parsed, parsed).Durations()["duration"]
+	commontest.AssertDistributionHasValues(merged, expected.SampleCount(), expected.Min(), expected.Max(), expected.Avg(), expected.StdDev(), t)
+}
+
+func TestParseInvalidSnapshot(t *testing.T) {
+	if _, err := ParseSnapshot([]byte(`{"counters": {"x": "y"}}`)); err == nil {
+		t.Error("parsing an invalid snapshot should fail")
+	}
+}
+
+func TestFilterSnapshot(t *testing.T) {
+	dist := NewDistribution()
+	snapshot := &Snapshot{
+		TimestampStarted:  1000,
+		TimestampCreated:  2000,
+		DurationsSnapshot: map[string]api.Distribution{"db.query": dist, "api.call": dist},
+		CountersSnapshot:  map[string]int64{"db.connections": 3, "api.sessions": 4},
+		SamplesSnapshot:   map[string]api.Distribution{"db.rows": dist, "mem.allocations": dist},
+		GaugesSnapshot:    map[string]float64{"db.pool.size": 10, "queue.depth": 2},
+	}
+	filtered := FilterSnapshot(snapshot, func(key string) bool { return strings.HasPrefix(key, "db.") })
+	if filtered.StartedTimestamp() != 1000 || filtered.CreatedTimestamp() != 2000 {
+		t.Error("filtering should keep the timestamps")
+	}
+	if len(filtered.Durations()) != 1 || filtered.Durations()["db.query"] != dist {
+		t.Errorf("durations should only contain db.query but contains %v", filtered.Durations())
+	}
+	if len(filtered.Counters()) != 1 || filtered.Counters()["db.connections"] != 3 {
+		t.Errorf("counters should only contain db.connections but contains %v", filtered.Counters())
+	}
+	if len(filtered.Samples()) != 1 || filtered.Samples()["db.rows"] != dist {
+		t.Errorf("samples should only contain db.rows but contains %v", filtered.Samples())
+	}
+	if len(filtered.Gauges()) != 1 || filtered.Gauges()["db.pool.size"] != 10 {
+		t.Errorf("gauges should only contain db.pool.size but contains %v", filtered.Gauges())
+	}
+	if len(snapshot.Durations())+len(snapshot.Counters())+len(snapshot.Samples())+len(snapshot.Gauges()) != 8 {
+		t.Error("filtering should not modify the original snapshot")
+	}
+}
+
+func TestSnapshotSeries(t *testing.T) {
+	dist := NewDistribution()
+	snapshot := &Snapshot{
+		DurationsSnapshot: map[string]api.Distribution{"http.requests{method=GET}": dist, "plain.duration": dist},
+		CountersSnapshot:  map[string]int64{"http.requests{method=POST,status=500}": 1},
+		SamplesSnapshot:   map[string]api.Distribution{},
+	}
+	expected := map[string]api.Series{
+		"http.requests{method=GET}":             {Key: "http.requests", Tags: api.Tags{"method": "GET"}},
+		"http.requests{method=POST,status=500}": {Key: "http.requests", Tags: api.Tags{"method": "POST", "status": "500"}},
+	}
+	if !reflect.DeepEqual(snapshot.Series(), expected) {
+		t.Errorf("Series() = %v, expected %v", snapshot.Series(), expected)
+	}
+}
+
+func TestSnapshotWithRecordedSeries(t *testing.T) {
+	tagged := api.Series{Key: "http.requests", Tags: api.Tags{"method": "GET"}}
+	snapshot := &Snapshot{
+		DurationsSnapshot: map[string]api.Distribution{},
+		CountersSnapshot:  map[string]int64{"http.requests{method=GET}": 1, "pool{x=1}": 2},
+		SamplesSnapshot:   map[string]api.Distribution{},
+		SeriesSnapshot:    map[string]api.Series{"http.requests{method=GET}": tagged},
+	}
+	expected := map[string]api.Series{"http.requests{method=GET}": tagged}
+	if !reflect.DeepEqual(snapshot.Series(), expected) {
+		t.Errorf("Series() = %v, expected only the recorded series %v", snapshot.Series(), expected)
+	}
+	data, _ := json.Marshal(snapshot)
+	parsed, err := ParseSnapshot(data)
+	if err != nil {
+		t.Fatal("parsing snapshot failed", err)
+	}
+	if !reflect.DeepEqual(parsed.Series(), expected) {
+		t.Errorf("parsed Series() = %v, expected %v", parsed.Series(), expected)
+	}
+	filtered := FilterSnapshot(snapshot, func(key string) bool { return key == "pool{x=1}" })
+	if len(filtered.Series()) != 0 {
+		t.Errorf("filtered Series() = %v, expected no series", filtered.Series())
+	}
+	if merged := MergeSnapshots(snapshot, filtered); !reflect.DeepEqual(merged.Series(), expected) {
+		t.Errorf("merged Series() = %v, expected %v", merged.Series(), expected)
+	}
+}
+
+func TestParseSnapshotWithGauges(t *testing.T) {
+	snapshot, err := ParseSnapshot([]byte(`{"timestampStarted":1,"timestampTaken":2,"gauges":{"queue.depth":12.5}}`))
+	if err != nil {
+		t.Fatal("parsing a snapshot with gauges failed", err)
+	}
+	if snapshot.Gauges()["queue.depth"] != 12.5 {
+		t.Errorf("expected gauge queue.depth to be 12.5 but got %v", snapshot.Gauges())
+	}
+}
+
+func TestSnapshotWithoutGaugesOmitsGaugesSection(t *testing.T) {
+	data, _ := json.Marshal(&Snapshot{GaugesSnapshot: map[string]float64{}})
+	if strings.Contains(string(data), "gauges") {
+		t.Errorf("a snapshot without gauges should not contain a gauges section, got %v", string(data))
+	}
+	data, _ = json.Marshal(&Snapshot{GaugesSnapshot: map[string]float64{"pool.size": 4}})
+	if !strings.Contains(string(data), `"gauges":{"pool.size":4}`) {
+		t.Errorf("expected a gauges section, got %v", string(data))
+	}
+}
+
+func TestMarshalSnapshotLeavesOutNonFiniteGauges(t *testing.T) {
+	snapshot := &Snapshot{
+		CountersSnapshot: map[string]int64{},
+		GaugesSnapshot:   map[string]float64{"queue.depth": 2, "nan": math.NaN(), "inf": math.Inf(1), "-inf": math.Inf(-1)},
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal("marshalling a snapshot with non-finite gauges failed", err)
+	}
+	parsed, err := ParseSnapshot(data)
+	if err != nil {
+		t.Fatal("parsing snapshot failed", err)
+	}
+	if !reflect.DeepEqual(parsed.Gauges(), map[string]float64{"queue.depth": 2}) {
+		t.Errorf("expected only the finite gauge queue.depth but got %v", parsed.Gauges())
+	}
+	if len(snapshot.Gauges()) != 4 {
+		t.Error("marshalling should not modify the snapshot")
+	}
+}
+
+func TestMarshalSnapshotLeavesOutDistributionsThatCannotBeEncoded(t *testing.T) {
+	valid := NewDistribution()
+	valid.AddSample(1)
+	invalid := NewDistribution()
+	invalid.AddSample(math.NaN())
+	snapshot := &Snapshot{
+		DurationsSnapshot: map[string]api.Distribution{"valid": valid, "invalid": invalid},
+		CountersSnapshot:  map[string]int64{"counter": 1},
+		SamplesSnapshot:   map[string]api.Distribution{"invalid": invalid},
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal("marshalling a snapshot with a NaN sample failed", err)
+	}
+	parsed, err := ParseSnapshot(data)
+	if err != nil {
+		t.Fatal("parsing snapshot failed", err)
+	}
+	if len(parsed.Durations()) != 1 || parsed.Durations()["valid"] == nil || len(parsed.Samples()) != 0 {
+		t.Errorf("expected only the valid duration but got %v", string(data))
+	}
+	if parsed.Counters()["counter"] != 1 {
+		t.Errorf("expected the counter to be kept but got %v", string(data))
+	}
+}
+
+func TestMergeSnapshotsWithMeters(t *testing.T) {
+	snapshot1 := &Snapshot{MetersSnapshot: map[string]api.Meter{"requests": &MeterSnapshot{Events: 10, M1: 1, M5: 2, M15: 3, Mean: 4, Started: 200}}}
+	snapshot2 := &Snapshot{MetersSnapshot: map[string]api.Meter{"requests": &MeterSnapshot{Events: 5, M1: 1, M5: 1, M15: 1, Mean: 1, Started: 100}}}
+	merged := MergeSnapshots(snapshot1, snapshot2).Meters()["requests"]
+	if *merged.(*MeterSnapshot) != (MeterSnapshot{Events: 15, M1: 2, M5: 3, M15: 4, Mean: 5, Started: 100}) {
+		t.Errorf("meter counts and rates should be summed but got %+v", merged)
+	}
+	if snapshot1.Meters()["requests"].Count() != 10 {
+		t.Error("merging should not modify the original snapshot")
+	}
+}
+
+func TestParseSnapshotWithMeters(t *testing.T) {
+	data, _ := json.Marshal(&Snapshot{MetersSnapshot: map[string]api.Meter{"requests": &MeterSnapshot{Events: 10, M1: 1, M5: 2, M15: 3, Mean: 4}}})
+	snapshot, err := ParseSnapshot(data)
+	if err != nil {
+		t.Fatal("parsing a snapshot with meters failed", err)
+	}
+	meter := snapshot.Meters()["requests"]
+	if meter == nil || meter.Count() != 10 || meter.Rate1() != 1 || meter.Rate5() != 2 || meter.Rate15() != 3 || meter.RateMean() != 4 {
+		t.Errorf("parsed meter was %+v", meter)
+	}
+}
+
+func TestSubtractSnapshot(t *testing.T) {
+	dist := NewDistribution()
+	dist.AddSample(10)
+	unchanged := dist.Copy()
+	previous := &Snapshot{
+		TimestampStarted:  1000,
+		TimestampCreated:  2000,
+		DurationsSnapshot: map[string]api.Distribution{"unchanged": unchanged},
+		CountersSnapshot:  map[string]int64{"counter": 5},
+		SamplesSnapshot:   map[string]api.Distribution{"sample": dist.Copy()},
+	}
+	dist.AddSample(20)
+	dist.AddSample(30)
+	current := &Snapshot{
+		TimestampStarted:  1000,
+		TimestampCreated:  3000,
+		DurationsSnapshot: map[string]api.Distribution{"unchanged": unchanged, "new": unchanged},
+		CountersSnapshot:  map[string]int64{"counter": 8, "new.counter": 1},
+		SamplesSnapshot:   map[string]api.Distribution{"sample": dist},
+		GaugesSnapshot:    map[string]float64{"gauge": 3},
+	}
+	delta := SubtractSnapshot(current, previous)
+	if delta.StartedTimestamp() != 2000 || delta.CreatedTimestamp() != 3000 {
+		t.Errorf("the delta should cover 2000-3000 but covers %v-%v", delta.StartedTimestamp(), delta.CreatedTimestamp())
+	}
+	if delta.Counters()["counter"] != 3 || delta.Counters()["new.counter"] != 1 {
+		t.Errorf("expected counter differences 3 and 1 but got %v", delta.Counters())
+	}
+	if _, exists := delta.Durations()["unchanged"]; exists || delta.Durations()["new"] != unchanged {
+		t.Errorf("durations without new samples should be left out and new ones kept, got %v", delta.Durations())
+	}
+	sample := delta.Samples()["sample"]
+	if sample.SampleCount() != 2 || !commontest.FloatEquals(sample.Avg(), 25) || !commontest.FloatEquals(sample.StdDev(), 7.0710) {
+		t.Errorf("expected the delta of sample to contain 20 and 30, got %v", sample)
+	}
+	if math.Abs(sample.Min()-20) > 20*sketchRelativeAccuracy || sample.Max() != 30 {
+		t.Errorf("expected the delta of sample to range from about 20 to 30 but got %v-%v", sample.Min(), sample.Max())
+	}
+	if delta.Gauges()["gauge"] != 3 {
+		t.Error("gauges should be taken from the current snapshot")
+	}
+	commontest.AssertDistributionHasValues(dist, 3, 10, 30, 20, 10, t)
+}
+
+func TestSubtractSnapshotAfterReset(t *testing.T) {
+	previous := &Snapshot{TimestampStarted: 1000, CountersSnapshot: map[string]int64{"counter": 5}}
+	current := &Snapshot{TimestampStarted: 2000, CountersSnapshot: map[string]int64{"counter": 2}}
+	if delta := SubtractSnapshot(current, previous); delta != current {
+		t.Error("after a reset the current snapshot should be returned as is")
+	}
+}
+
+func TestSubtractSnapshotAfterResetInTheSameMillisecond(t *testing.T) {
+	state := NewState(DefaultDistributionFactory, DefaultDistributionFactory)
+	state.AddToCounter("counter", 5)
+	previous := state.Snapshot()
+	state.Reset()
+	state.AddToCounter("counter", 2)
+	current := state.Snapshot()
+	current.TimestampStarted = previous.StartedTimestamp()
+	if delta := SubtractSnapshot(current, previous); delta != current {
+		t.Errorf("a reset within the same millisecond should be detected, got counters %v", delta.Counters())
+	}
+	merged := MergeSnapshots(previous, previous)
+	if delta := SubtractSnapshot(MergeSnapshots(current, previous), merged); delta.Counters()["counter"] != 7 {
+		t.Errorf("a reset of one of the merged snapshots should be detected, got counters %v", delta.Counters())
+	}
+}
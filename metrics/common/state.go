@@ -0,0 +1,253 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import "github.com/toefel18/go-patan/metrics/api"
+
+// State holds the counters, durations, samples, gauges and meters of a store. It is not safe for concurrent use, the
+// store that owns it must make sure only one go-routine uses it at a time, by holding a lock or by owning it from a
+// single go-routine. Counter cells are the exception, they are updated without the store.
+type State struct {
+	timestampStarted int64
+	// generation changes on every reset and when distributions expire, so handles know when their cached
+	// distribution is no longer in the state
+	generation uint64
+	// resets counts the resets, so snapshots can tell whether a reset happened in between, see SubtractSnapshot
+	resets uint64
+
+	durations map[string]Recorder
+	counters  map[string]int64
+	samples   map[string]Recorder
+	gauges    map[string]float64
+	meters    map[string]*Meter
+
+	gaugeFuncs   map[string]func() float64
+	counterCells map[string]*AtomicCounter
+
+	newDuration DistributionFactory
+	newSample   DistributionFactory
+}
+
+// NewState creates an empty state that creates the distributions of new durations and samples with the given
+// factories. Panics if a factory is nil.
+func NewState(newDuration, newSample DistributionFactory) *State {
+	if newDuration == nil || newSample == nil {
+		panic("distribution factories cannot be nil")
+	}
+	return &State{
+		timestampStarted: CurrentTimeMillis(),
+		durations:        make(map[string]Recorder),
+		counters:         make(map[string]int64),
+		samples:          make(map[string]Recorder),
+		gauges:           make(map[string]float64),
+		meters:           make(map[string]*Meter),
+		gaugeFuncs:       make(map[string]func() float64),
+		counterCells:     make(map[string]*AtomicCounter),
+		newDuration:      newDuration,
+		newSample:        newSample,
+	}
+}
+
+// DistributionHandle caches the distribution of a key, so recording needs no lookup as long as the state is not
+// reset, see State.RecordTo
+type DistributionHandle struct {
+	key        string
+	duration   bool
+	recorder   Recorder
+	generation uint64
+}
+
+// NewDurationHandle creates a handle to the duration distribution identified by key
+func NewDurationHandle(key string) *DistributionHandle {
+	return &DistributionHandle{key: key, duration: true}
+}
+
+// NewSampleHandle creates a handle to the sample distribution identified by key
+func NewSampleHandle(key string) *DistributionHandle {
+	return &DistributionHandle{key: key}
+}
+
+// RecordTo adds value to the distribution of the handle, which is looked up again after a reset
+func (state *State) RecordTo(handle *DistributionHandle, value float64) {
+	if handle.recorder == nil || handle.generation != state.generation {
+		if handle.duration {
+			handle.recorder = distribution(state.durations, state.newDuration, handle.key)
+		} else {
+			handle.recorder = distribution(state.samples, state.newSample, handle.key)
+		}
+		handle.generation = state.generation
+	}
+	handle.recorder.AddSample(value)
+}
+
+// AddDuration adds value to the duration distribution identified by key
+func (state *State) AddDuration(key string, value float64) {
+	distribution(state.durations, state.newDuration, key).AddSample(value)
+}
+
+// AddSample adds value to the sample distribution identified by key
+func (state *State) AddSample(key string, value float64) {
+	distribution(state.samples, state.newSample, key).AddSample(value)
+}
+
+// AddToCounter adds value to the counter identified by key
+func (state *State) AddToCounter(key string, value int64) {
+	state.counters[key] += value
+}
+
+// CounterCell returns the cell of the counter identified by key, which can be updated without the store
+func (state *State) CounterCell(key string) *AtomicCounter {
+	cell, exists := state.counterCells[key]
+	if !exists {
+		cell = &AtomicCounter{}
+		state.counterCells[key] = cell
+	}
+	return cell
+}
+
+// SetGauge sets the gauge identified by key to value
+func (state *State) SetGauge(key string, value float64) {
+	state.gauges[key] = value
+}
+
+// RegisterGaugeFunc registers the function that determines the value of the gauge identified by key
+func (state *State) RegisterGaugeFunc(key string, gauge func() float64) {
+	state.gaugeFuncs[key] = gauge
+}
+
+// MarkMeter marks the occurrence of n events on the meter identified by key
+func (state *State) MarkMeter(key string, n int64) {
+	meter, exists := state.meters[key]
+	if !exists {
+		meter = NewMeter()
+		state.meters[key] = meter
+	}
+	meter.Mark(n)
+}
+
+// Snapshot copies the state, without the gauge functions, see GaugeFuncs. Distributions without samples, such as
+// windowed distributions whose window emptied, are left out and removed, so their keys expire.
+func (state *State) Snapshot() *Snapshot {
+	return state.snapshot(false)
+}
+
+// SnapshotAndReset copies the state and then clears the counters, durations and samples. Every counter cell is
+// zeroed in the same atomic operation that reads it, so no update is lost.
+func (state *State) SnapshotAndReset() *Snapshot {
+	snapshot := state.snapshot(true)
+	state.reset()
+	return snapshot
+}
+
+// Reset clears the counters, counter cells, durations and samples, gauges and meters are kept
+func (state *State) Reset() {
+	state.reset()
+	for _, cell := range state.counterCells {
+		cell.Reset()
+	}
+}
+
+// GaugeFuncs returns a copy of the registered gauge functions. Evaluate them with EvaluateGaugeFuncs after the store
+// is released, so gauge functions can use the store themselves.
+func (state *State) GaugeFuncs() map[string]func() float64 {
+	gaugeFuncsCopy := make(map[string]func() float64, len(state.gaugeFuncs))
+	for key, gauge := range state.gaugeFuncs {
+		gaugeFuncsCopy[key] = gauge
+	}
+	return gaugeFuncsCopy
+}
+
+// EvaluateGaugeFuncs adds the values of the gauge functions to the snapshot
+func EvaluateGaugeFuncs(snapshot *Snapshot, gaugeFuncs map[string]func() float64) {
+	for key, gauge := range gaugeFuncs {
+		snapshot.GaugesSnapshot[key] = gauge()
+	}
+}
+
+func (state *State) snapshot(resetCounterCells bool) *Snapshot {
+	countersCopy := make(map[string]int64, len(state.counters)+len(state.counterCells))
+	for key, counter := range state.counters {
+		countersCopy[key] = counter
+	}
+	for key, cell := range state.counterCells {
+		if resetCounterCells {
+			countersCopy[key] += cell.Reset()
+		} else {
+			countersCopy[key] += cell.Value()
+		}
+	}
+	gaugesCopy := make(map[string]float64, len(state.gauges))
+	for key, value := range state.gauges {
+		gaugesCopy[key] = value
+	}
+	metersCopy := make(map[string]api.Meter, len(state.meters))
+	for key, meter := range state.meters {
+		metersCopy[key] = meter.Snapshot()
+	}
+	return &Snapshot{
+		TimestampStarted:  state.timestampStarted,
+		TimestampCreated:  CurrentTimeMillis(),
+		DurationsSnapshot: state.snapshotDistributions(state.durations),
+		CountersSnapshot:  countersCopy,
+		SamplesSnapshot:   state.snapshotDistributions(state.samples),
+		GaugesSnapshot:    gaugesCopy,
+		MetersSnapshot:    metersCopy,
+		resets:            state.resets,
+		tracksResets:      true,
+	}
+}
+
+func (state *State) reset() {
+	state.timestampStarted = CurrentTimeMillis()
+	state.generation++
+	state.resets++
+	state.durations = make(map[string]Recorder)
+	state.counters = make(map[string]int64)
+	state.samples = make(map[string]Recorder)
+}
+
+// distribution returns the distribution identified by key, and creates it if it does not exist yet
+func distribution(destination map[string]Recorder, newDistribution DistributionFactory, key string) Recorder {
+	recorder, exists := destination[key]
+	if !exists {
+		recorder = newDistribution()
+		destination[key] = recorder
+	}
+	return recorder
+}
+
+// snapshotDistributions copies the distributions of source and removes the ones without samples
+func (state *State) snapshotDistributions(source map[string]Recorder) map[string]api.Distribution {
+	distributions := make(map[string]api.Distribution, len(source))
+	expired := false
+	for key, recorder := range source {
+		distribution := recorder.Snapshot()
+		if distribution.SampleCount() == 0 {
+			delete(source, key)
+			expired = true
+			continue
+		}
+		distributions[key] = distribution
+	}
+	if expired {
+		// handles of the removed distributions have to look up their key again
+		state.generation++
+	}
+	return distributions
+}
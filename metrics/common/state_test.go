@@ -0,0 +1,77 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import "testing"
+
+func TestStateRecords(t *testing.T) {
+	state := NewState(DefaultDistributionFactory, DefaultDistributionFactory)
+	state.AddDuration("duration", 5)
+	state.AddSample("sample", 7)
+	state.AddToCounter("counter", 2)
+	state.CounterCell("counter").Add(3)
+	state.SetGauge("gauge", 4)
+	state.MarkMeter("meter", 6)
+	state.RegisterGaugeFunc("gauge.func", func() float64 { return 8 })
+	snapshot := state.Snapshot()
+	EvaluateGaugeFuncs(snapshot, state.GaugeFuncs())
+	if snapshot.Durations()["duration"].Max() != 5 || snapshot.Samples()["sample"].Max() != 7 {
+		t.Errorf("expected the duration and sample to be recorded but got %v and %v", snapshot.Durations(), snapshot.Samples())
+	}
+	if snapshot.Counters()["counter"] != 5 {
+		t.Error("expected the counter and its cell to add up to 5 but got", snapshot.Counters()["counter"])
+	}
+	if snapshot.Gauges()["gauge"] != 4 || snapshot.Gauges()["gauge.func"] != 8 || snapshot.Meters()["meter"].Count() != 6 {
+		t.Errorf("expected the gauges and meter to be recorded but got %v and %v", snapshot.Gauges(), snapshot.Meters())
+	}
+}
+
+func TestStateSnapshotAndReset(t *testing.T) {
+	state := NewState(DefaultDistributionFactory, DefaultDistributionFactory)
+	handle := NewDurationHandle("duration")
+	state.RecordTo(handle, 1)
+	state.CounterCell("counter").Inc()
+	state.SetGauge("gauge", 4)
+	snapshot := state.SnapshotAndReset()
+	if snapshot.Durations()["duration"].SampleCount() != 1 || snapshot.Counters()["counter"] != 1 {
+		t.Errorf("expected the recordings before the reset but got %v and %v", snapshot.Durations(), snapshot.Counters())
+	}
+	state.RecordTo(handle, 2)
+	snapshot = state.Snapshot()
+	if duration := snapshot.Durations()["duration"]; duration == nil || duration.SampleCount() != 1 || duration.Max() != 2 {
+		t.Errorf("expected the handle to record into a new distribution after the reset but got %v", snapshot.Durations())
+	}
+	if snapshot.Counters()["counter"] != 0 || snapshot.Gauges()["gauge"] != 4 {
+		t.Error("expected the counter cell to be zeroed and the gauge to be kept")
+	}
+	state.CounterCell("counter").Inc()
+	state.Reset()
+	if snapshot = state.Snapshot(); len(snapshot.Durations())+len(snapshot.Samples()) != 0 || snapshot.Counters()["counter"] != 0 {
+		t.Error("expected Reset to clear the distributions and counter cells")
+	}
+}
+
+func TestNewStateWithNilFactory(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewState should panic when a factory is nil, but no panic")
+		}
+	}()
+	NewState(DefaultDistributionFactory, nil)
+}
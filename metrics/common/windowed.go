@@ -0,0 +1,142 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// WindowedDistribution is a distribution of the samples of a sliding time window. The window is divided into a ring
+// of buckets, every bucket covers an equal part of the window. Samples are added to the newest bucket, and the oldest
+// bucket is dropped when a new one starts. The reported statistics cover the samples of the last window, where the
+// newest bucket is still filling up, so they span between window - window/buckets and window of time. Stores leave a
+// windowed distribution out of their snapshots once its window is empty, see State.Snapshot.
+type WindowedDistribution struct {
+	buckets        []*Distribution
+	bucketDuration time.Duration
+	current        int
+	currentStart   time.Time
+	now            func() time.Time
+}
+
+// NewWindowedDistribution creates a new WindowedDistribution over the last window of time, divided into the given
+// number of buckets. Panics if window or buckets is not positive.
+func NewWindowedDistribution(window time.Duration, buckets int) *WindowedDistribution {
+	return newWindowedDistributionWithClock(window, buckets, time.Now)
+}
+
+func newWindowedDistributionWithClock(window time.Duration, buckets int, now func() time.Time) *WindowedDistribution {
+	if buckets < 1 {
+		panic(fmt.Sprint("a windowed distribution needs at least 1 bucket but got ", buckets))
+	}
+	if window < time.Duration(buckets) {
+		panic(fmt.Sprint("window must be at least 1 nanosecond per bucket but was ", window))
+	}
+	dist := &WindowedDistribution{
+		buckets:        make([]*Distribution, buckets),
+		bucketDuration: window / time.Duration(buckets),
+		currentStart:   now(),
+		now:            now,
+	}
+	for i := range dist.buckets {
+		dist.buckets[i] = NewDistribution()
+	}
+	return dist
+}
+
+// WindowedDistributionFactory returns a factory for WindowedDistributions with the given configuration, see
+// NewWindowedDistribution. For example, to report the durations of the last minute in buckets of 10 seconds:
+// NewStoreWithDistributions(common.WindowedDistributionFactory(time.Minute, 6), common.DefaultDistributionFactory).
+// Panics immediately if the configuration is invalid.
+func WindowedDistributionFactory(window time.Duration, buckets int) DistributionFactory {
+	NewWindowedDistribution(window, buckets)
+	return func() Recorder {
+		return NewWindowedDistribution(window, buckets)
+	}
+}
+
+// AddSample adds the value to the newest bucket
+func (dist *WindowedDistribution) AddSample(value float64) {
+	dist.rotate()
+	dist.buckets[dist.current].AddSample(value)
+}
+
+// Snapshot returns a *Distribution that contains the samples of the last window
+func (dist *WindowedDistribution) Snapshot() api.Distribution {
+	return dist.window()
+}
+
+// SampleCount returns the number of samples in the last window
+func (dist *WindowedDistribution) SampleCount() int64 {
+	return dist.window().SampleCount()
+}
+
+// Min returns the minimum value of the last window
+func (dist *WindowedDistribution) Min() float64 {
+	return dist.window().Min()
+}
+
+// Max returns the maximum value of the last window
+func (dist *WindowedDistribution) Max() float64 {
+	return dist.window().Max()
+}
+
+// Avg returns the average value of the last window
+func (dist *WindowedDistribution) Avg() float64 {
+	return dist.window().Avg()
+}
+
+// StdDev returns the standard deviation of the last window
+func (dist *WindowedDistribution) StdDev() float64 {
+	return dist.window().StdDev()
+}
+
+// Percentile returns the estimated value below which a fraction q (0 <= q <= 1) of the samples of the last window fall
+func (dist *WindowedDistribution) Percentile(q float64) float64 {
+	return dist.window().Percentile(q)
+}
+
+// window merges the buckets of the last window into a new *Distribution
+func (dist *WindowedDistribution) window() *Distribution {
+	dist.rotate()
+	merged := NewDistribution()
+	for _, bucket := range dist.buckets {
+		merged.Merge(bucket)
+	}
+	return merged
+}
+
+// rotate starts a new bucket for every bucket duration that passed since the current bucket started
+func (dist *WindowedDistribution) rotate() {
+	passed := int64(dist.now().Sub(dist.currentStart) / dist.bucketDuration)
+	if passed <= 0 {
+		return
+	}
+	dist.currentStart = dist.currentStart.Add(time.Duration(passed) * dist.bucketDuration)
+	if passed > int64(len(dist.buckets)) {
+		passed = int64(len(dist.buckets))
+	}
+	for i := int64(0); i < passed; i++ {
+		dist.current = (dist.current + 1) % len(dist.buckets)
+		dist.buckets[dist.current] = NewDistribution()
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/common/commontest"
+)
+
+func TestWindowedDistributionReportsLastWindow(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(1000, 0)}
+	dist := newWindowedDistributionWithClock(time.Minute, 6, clock.now)
+	dist.AddSample(100)
+	clock.advance(10 * time.Second)
+	for i := 1; i <= 5; i++ {
+		dist.AddSample(float64(i))
+		clock.advance(10 * time.Second)
+	}
+	// the sample of 100 is now 60 seconds old and dropped
+	commontest.AssertDistributionHasValues(dist.Snapshot(), 5, 1, 5, 3, 1.5811, t)
+	if p := dist.Percentile(0.99); p < 4.9 || p > 5.1 {
+		t.Error("expected p99 of the window to be 5 but got", p)
+	}
+	clock.advance(25 * time.Second)
+	commontest.AssertDistributionHasValues(dist.Snapshot(), 3, 3, 5, 4, 1, t)
+	clock.advance(10 * time.Minute)
+	if dist.SampleCount() != 0 {
+		t.Error("after a long idle period the window should be empty but contains", dist.SampleCount())
+	}
+	dist.AddSample(7)
+	commontest.AssertDistributionHasValues(dist, 1, 7, 7, 7, 0, t)
+}
+
+func TestWindowedDistributionSnapshotsAreDisconnected(t *testing.T) {
+	dist := NewWindowedDistribution(time.Minute, 6)
+	dist.AddSample(1)
+	snapshot := dist.Snapshot()
+	dist.AddSample(3)
+	commontest.AssertDistributionHasValues(snapshot, 1, 1, 1, 1, 0, t)
+	commontest.AssertDistributionHasValues(dist.Snapshot(), 2, 1, 3, 2, 1.4142, t)
+}
+
+func TestWindowedDistributionFactoryWithInvalidConfig(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("WindowedDistributionFactory should panic when buckets < 1, but no panic")
+		}
+	}()
+	WindowedDistributionFactory(time.Minute, 0)
+}
+
+func TestWindowedDistributionExpiresFromState(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(1000, 0)}
+	windowed := func() Recorder { return newWindowedDistributionWithClock(time.Minute, 6, clock.now) }
+	state := NewState(windowed, DefaultDistributionFactory)
+	handle := NewDurationHandle("handled")
+	state.RecordTo(handle, 1)
+	state.AddDuration("added", 2)
+	if durations := state.Snapshot().Durations(); len(durations) != 2 {
+		t.Errorf("expected 2 durations within the window but got %v", durations)
+	}
+	clock.advance(2 * time.Minute)
+	if durations := state.Snapshot().Durations(); len(durations) != 0 {
+		t.Errorf("expected the durations to be left out once their window is empty but got %v", durations)
+	}
+	state.RecordTo(handle, 3)
+	duration := state.Snapshot().Durations()["handled"]
+	if duration == nil || duration.SampleCount() != 1 || duration.Max() != 3 {
+		t.Errorf("expected the handle to record into a new distribution after the key expired but got %v", duration)
+	}
+}
@@ -23,6 +23,7 @@
 package metrics
 
 import (
+	"context"
 	"log"
 
 	"github.com/toefel18/go-patan/metrics/api"
@@ -69,6 +70,36 @@ func MeasureFuncCanPanic(key string, subject func()) float64 {
 	return std.MeasureFuncCanPanic(key, subject)
 }
 
+// MeasureFuncErr runs the subject function and records its duration under key when it succeeds, or under key with the
+// suffix of the error classifier appended when it returns an error, for example key.error or key.timeout. The counter
+// key.ok or the counter with the same suffix is incremented as well.
+func MeasureFuncErr(key string, subject func() error) (float64, error) {
+	return std.MeasureFuncErr(key, subject)
+}
+
+// SetErrorClassifier sets the classifier that determines the suffix of failed MeasureFuncErr calls, nil records every
+// failure as error. See common.NewErrorClassifier
+func SetErrorClassifier(classifier api.ErrorClassifier) {
+	std.SetErrorClassifier(classifier)
+}
+
+// StartStopwatchCtx starts a stopwatch that stops when ctx is done, record it with RecordElapsedTimeCtx
+func StartStopwatchCtx(ctx context.Context) api.Stopwatch {
+	return std.StartStopwatchCtx(ctx)
+}
+
+// RecordElapsedTimeCtx records the elapsed time of the stopwatch under key with the outcome of ctx and err appended:
+// .ok, .error, .canceled or .deadline_exceeded
+func RecordElapsedTimeCtx(ctx context.Context, key string, stopwatch api.Stopwatch, err error) float64 {
+	return std.RecordElapsedTimeCtx(ctx, key, stopwatch, err)
+}
+
+// MeasureFuncCtx runs subject with ctx and records its duration under key with the outcome appended. The timing
+// stops when ctx is done, even when subject returns later.
+func MeasureFuncCtx(ctx context.Context, key string, subject func(ctx context.Context) error) (float64, error) {
+	return std.MeasureFuncCtx(ctx, key, subject)
+}
+
 // IncrementCounter increments the counter identified by key by 1
 func IncrementCounter(key string) {
 	std.IncrementCounter(key)
@@ -84,13 +115,86 @@ func AddToCounter(key string, value int64) {
 	std.AddToCounter(key, value)
 }
 
+// Counter returns a handle to the counter identified by key, that updates the counter without a lock
+func Counter(key string) api.Counter {
+	return std.Counter(key)
+}
+
+// Timer returns a handle to the duration distribution identified by key, that records without looking up the key
+func Timer(key string) api.Timer {
+	return std.Timer(key)
+}
+
+// Histogram returns a handle to the sample distribution identified by key, that records without looking up the key
+func Histogram(key string) api.Histogram {
+	return std.Histogram(key)
+}
+
 // AddSample adds a sample to the distribution identified by value, if the distribution doesn't
 // exist, it will be created
 func AddSample(key string, value float64) {
 	std.AddSample(key, value)
 }
 
-// Reset clears the store
+// SetGauge sets the gauge identified by key to value
+func SetGauge(key string, value float64) {
+	std.SetGauge(key, value)
+}
+
+// RegisterGaugeFunc registers a function that is called on every snapshot to determine the value of the gauge
+// identified by key
+func RegisterGaugeFunc(key string, gauge func() float64) {
+	std.RegisterGaugeFunc(key, gauge)
+}
+
+// MarkMeter marks the occurrence of n events on the meter identified by key
+func MarkMeter(key string, n int64) {
+	std.MarkMeter(key, n)
+}
+
+// RecordElapsedTimeWithTags records the elapsed time of the stopwatch under the distribution identified with key
+// and tags
+func RecordElapsedTimeWithTags(key string, tags api.Tags, stopwatch api.Stopwatch) float64 {
+	return std.RecordElapsedTimeWithTags(key, tags, stopwatch)
+}
+
+// MeasureFuncWithTags runs the subject function and records it's execution duration under the distribution
+// identified with key and tags
+func MeasureFuncWithTags(key string, tags api.Tags, subject func()) float64 {
+	return std.MeasureFuncWithTags(key, tags, subject)
+}
+
+// IncrementCounterWithTags increments the counter identified by key and tags by 1
+func IncrementCounterWithTags(key string, tags api.Tags) {
+	std.IncrementCounterWithTags(key, tags)
+}
+
+// DecrementCounterWithTags decrements the counter identified by key and tags by 1
+func DecrementCounterWithTags(key string, tags api.Tags) {
+	std.DecrementCounterWithTags(key, tags)
+}
+
+// AddToCounterWithTags adds value to the counter identified by key and tags, value can be negative
+func AddToCounterWithTags(key string, tags api.Tags, value int64) {
+	std.AddToCounterWithTags(key, tags, value)
+}
+
+// AddSampleWithTags adds a sample to the distribution identified by key and tags
+func AddSampleWithTags(key string, tags api.Tags, value float64) {
+	std.AddSampleWithTags(key, tags, value)
+}
+
+// SetGaugeWithTags sets the gauge identified by key and tags to value
+func SetGaugeWithTags(key string, tags api.Tags, value float64) {
+	std.SetGaugeWithTags(key, tags, value)
+}
+
+// MarkMeterWithTags marks the occurrence of n events on the meter identified by key and tags
+func MarkMeterWithTags(key string, tags api.Tags, n int64) {
+	std.MarkMeterWithTags(key, tags, n)
+}
+
+// Reset clears the store, gauges and meters are kept
 func Reset() {
 	std.Reset()
 }
@@ -101,6 +205,11 @@ func Snapshot() api.Snapshot {
 	return std.Snapshot()
 }
 
+// Cursor returns a cursor that returns what changed since its previous read, without resetting the store
+func Cursor() api.Cursor {
+	return std.Cursor()
+}
+
 // SnapshotAndReset returns a snapshot of all the counters, durations and samples recorded
 // since creation or the last reset, and then clears the internal state
 func SnapshotAndReset() api.Snapshot {
@@ -18,13 +18,16 @@
 package metrics
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/toefel18/go-patan/metrics/api"
 	"github.com/toefel18/go-patan/metrics/common"
 	"github.com/toefel18/go-patan/metrics/lockbased"
 )
@@ -117,6 +120,21 @@ func BenchmarkTimer(b *testing.B) {
 	}
 }
 
+func BenchmarkTimerHandle(b *testing.B) {
+	timer := Timer("some.duration")
+	stopwatch := StartStopwatch()
+	for i := 0; i < b.N; i++ {
+		timer.Record(stopwatch.ElapsedMillis())
+	}
+}
+
+func BenchmarkHistogramHandle(b *testing.B) {
+	histogram := Histogram("some.sample")
+	for i := 0; i < b.N; i++ {
+		histogram.Record(float64(i))
+	}
+}
+
 func BenchmarkAddToCounter(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		AddToCounter("some.counter", int64(i))
@@ -145,6 +163,13 @@ func BenchmarkIncrementCounter(b *testing.B) {
 	}
 }
 
+func BenchmarkCounterInc(b *testing.B) {
+	counter := Counter("some.counter")
+	for i := 0; i < b.N; i++ {
+		counter.Inc()
+	}
+}
+
 func BenchmarkDecrementCounter(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		DecrementCounter("some.counter")
@@ -258,3 +283,104 @@ func TestJsonize(t *testing.T) {
 		t.Error("json marshalling failed", err)
 	}
 }
+
+func TestGlobalWithTags(t *testing.T) {
+	Reset()
+	tags := api.Tags{"db": "users"}
+	IncrementCounterWithTags("db.queries", tags)
+	AddToCounterWithTags("db.queries", tags, 2)
+	DecrementCounterWithTags("db.queries", tags)
+	AddSampleWithTags("db.rows", tags, 10)
+	RecordElapsedTimeWithTags("db.duration", tags, StartStopwatch())
+	MeasureFuncWithTags("db.duration", tags, func() {})
+	SetGaugeWithTags("db.connections", tags, 4)
+	MarkMeterWithTags("db.calls", tags, 1)
+	snapshot := SnapshotAndReset()
+	if snapshot.Gauges()["db.connections{db=users}"] != 4 || snapshot.Meters()["db.calls{db=users}"] == nil {
+		t.Error("tagged gauges and meters were not recorded")
+	}
+	if snapshot.Counters()["db.queries{db=users}"] != 2 {
+		t.Error("expected tagged counter to be 2 but got", snapshot.Counters()["db.queries{db=users}"])
+	}
+	if snapshot.Samples()["db.rows{db=users}"].SampleCount() != 1 || snapshot.Durations()["db.duration{db=users}"].SampleCount() != 2 {
+		t.Error("tagged samples and durations were not recorded")
+	}
+}
+
+func TestGlobalGauges(t *testing.T) {
+	SetGauge("global.queue.depth", 7)
+	RegisterGaugeFunc("global.pool.size", func() float64 { return 3 })
+	Reset()
+	snapshot := Snapshot()
+	if snapshot.Gauges()["global.queue.depth"] != 7 || snapshot.Gauges()["global.pool.size"] != 3 {
+		t.Errorf("expected gauges to be kept after a reset but got %v", snapshot.Gauges())
+	}
+}
+
+func TestGlobalMeters(t *testing.T) {
+	MarkMeter("global.requests", 2)
+	Reset()
+	MarkMeter("global.requests", 1)
+	if count := Snapshot().Meters()["global.requests"].Count(); count != 3 {
+		t.Error("expected meters to be kept after a reset with 3 events but got", count)
+	}
+}
+
+func TestGlobalCounter(t *testing.T) {
+	Reset()
+	counter := Counter("global.counter")
+	counter.Add(5)
+	IncrementCounter("global.counter")
+	if value := SnapshotAndReset().Counters()["global.counter"]; value != 6 {
+		t.Error("expected the counter to be 6 but got", value)
+	}
+}
+
+func TestGlobalTimerAndHistogram(t *testing.T) {
+	Reset()
+	Timer("global.timer").Record(12)
+	Histogram("global.histogram").Record(3)
+	snapshot := SnapshotAndReset()
+	if snapshot.Durations()["global.timer"].Max() != 12 || snapshot.Samples()["global.histogram"].Max() != 3 {
+		t.Error("expected the timer and histogram handles to record into the global instance")
+	}
+}
+
+func TestGlobalCursor(t *testing.T) {
+	Reset()
+	IncrementCounter("global.cursor")
+	cursor := Cursor()
+	AddToCounter("global.cursor", 2)
+	if value := cursor.Next().Counters()["global.cursor"]; value != 2 {
+		t.Error("expected the cursor to return the difference of 2 but got", value)
+	}
+	if value := Snapshot().Counters()["global.cursor"]; value != 3 {
+		t.Error("reading the cursor should not reset the global instance, got", value)
+	}
+}
+
+func TestGlobalMeasureFuncCtx(t *testing.T) {
+	Reset()
+	MeasureFuncCtx(context.Background(), "global.ctx", func(ctx context.Context) error { return nil })
+	sw := StartStopwatchCtx(context.Background())
+	RecordElapsedTimeCtx(context.Background(), "global.ctx", sw, errors.New("failed"))
+	durations := SnapshotAndReset().Durations()
+	if durations["global.ctx.ok"] == nil || durations["global.ctx.error"] == nil {
+		t.Errorf("expected durations for the ok and error outcomes but got %v", durations)
+	}
+}
+
+func TestGlobalMeasureFuncErr(t *testing.T) {
+	Reset()
+	SetErrorClassifier(common.NewErrorClassifier([]common.ErrorRule{{Target: context.DeadlineExceeded, Suffix: "timeout"}}))
+	defer SetErrorClassifier(nil)
+	MeasureFuncErr("global.err", func() error { return nil })
+	MeasureFuncErr("global.err", func() error { return context.DeadlineExceeded })
+	snapshot := SnapshotAndReset()
+	if snapshot.Durations()["global.err"] == nil || snapshot.Durations()["global.err.timeout"] == nil {
+		t.Errorf("expected durations for the success and the timeout but got %v", snapshot.Durations())
+	}
+	if snapshot.Counters()["global.err.ok"] != 1 || snapshot.Counters()["global.err.timeout"] != 1 {
+		t.Errorf("expected outcome counters for the success and the timeout but got %v", snapshot.Counters())
+	}
+}
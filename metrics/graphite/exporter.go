@@ -0,0 +1,179 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package graphite
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// Protocol is the protocol in which an Exporter sends snapshots to Carbon
+type Protocol int
+
+const (
+	// Plaintext sends lines of "path value timestamp", see Encode
+	Plaintext Protocol = iota
+	// Pickle sends pickled lists of datapoints, which Carbon parses more efficiently, see EncodePickle
+	Pickle
+)
+
+const (
+	// DefaultPlaintextAddress is the address of Carbon when Config.Address is empty and the protocol is Plaintext
+	DefaultPlaintextAddress = "127.0.0.1:2003"
+	// DefaultPickleAddress is the address of Carbon when Config.Address is empty and the protocol is Pickle
+	DefaultPickleAddress = "127.0.0.1:2004"
+	// DefaultTimeout is the timeout for connecting and sending when Config.Timeout is 0
+	DefaultTimeout = 5 * time.Second
+	// DefaultMinBackoff is the time to wait before reconnecting after the first failure when Config.MinBackoff is 0
+	DefaultMinBackoff = time.Second
+	// DefaultMaxBackoff is the maximum time to wait before reconnecting when Config.MaxBackoff is 0
+	DefaultMaxBackoff = time.Minute
+)
+
+// Config configures an Exporter, fields with a zero value get a default
+type Config struct {
+	// Address is the host:port of Carbon, defaults to DefaultPlaintextAddress or DefaultPickleAddress
+	Address string
+	// Prefix is prepended to every path, separated by a dot
+	Prefix string
+	// Protocol is the protocol to send the snapshots in, defaults to Plaintext
+	Protocol Protocol
+	// Timeout is the timeout for connecting and sending a snapshot, defaults to DefaultTimeout
+	Timeout time.Duration
+	// MinBackoff is the time to wait before reconnecting after the first failure, it doubles after every next failure
+	// up to MaxBackoff. Defaults to DefaultMinBackoff
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum time to wait before reconnecting, defaults to DefaultMaxBackoff
+	MaxBackoff time.Duration
+}
+
+// Exporter sends snapshots to Carbon over TCP. It connects on the first snapshot and keeps the connection open. When
+// connecting or sending fails, snapshots are dropped until the backoff has passed, so a Carbon outage does not block
+// the caller. Exporter implements reporter.Sink.
+type Exporter struct {
+	address    string
+	prefix     string
+	protocol   Protocol
+	timeout    time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	conn        net.Conn
+	backoff     time.Duration
+	nextAttempt time.Time
+	// now is the clock of the backoff
+	now  func() time.Time
+	lock sync.Mutex
+}
+
+// NewExporter creates an Exporter, it does not connect until the first snapshot is reported
+func NewExporter(config Config) *Exporter {
+	if config.Address == "" {
+		config.Address = DefaultPlaintextAddress
+		if config.Protocol == Pickle {
+			config.Address = DefaultPickleAddress
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		panic("the maximum backoff must be >= the minimum backoff")
+	}
+	return &Exporter{
+		address:    config.Address,
+		prefix:     config.Prefix,
+		protocol:   config.Protocol,
+		timeout:    config.Timeout,
+		minBackoff: config.MinBackoff,
+		maxBackoff: config.MaxBackoff,
+		now:        time.Now,
+	}
+}
+
+// Report sends the snapshot to Carbon, it connects first when there is no connection. Returns an error without
+// sending when the backoff after a failure has not passed yet.
+func (exporter *Exporter) Report(snapshot api.Snapshot) error {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	if exporter.conn == nil {
+		if wait := exporter.nextAttempt.Sub(exporter.now()); wait > 0 {
+			return fmt.Errorf("graphite: not connected to %v, reconnecting in %v", exporter.address, wait)
+		}
+		conn, err := net.DialTimeout("tcp", exporter.address, exporter.timeout)
+		if err != nil {
+			exporter.failed()
+			return err
+		}
+		exporter.conn = conn
+		log.Println("[METRICS] connected to graphite at", exporter.address)
+	}
+	exporter.conn.SetWriteDeadline(time.Now().Add(exporter.timeout))
+	if err := exporter.encode(exporter.conn, snapshot); err != nil {
+		exporter.conn.Close()
+		exporter.conn = nil
+		exporter.failed()
+		return err
+	}
+	exporter.backoff = 0
+	return nil
+}
+
+func (exporter *Exporter) encode(w io.Writer, snapshot api.Snapshot) error {
+	if exporter.protocol == Pickle {
+		return EncodePickle(w, exporter.prefix, snapshot)
+	}
+	return Encode(w, exporter.prefix, snapshot)
+}
+
+// failed doubles the backoff, starting at the minimum backoff, and schedules the next attempt to connect
+func (exporter *Exporter) failed() {
+	exporter.backoff *= 2
+	if exporter.backoff < exporter.minBackoff {
+		exporter.backoff = exporter.minBackoff
+	}
+	if exporter.backoff > exporter.maxBackoff {
+		exporter.backoff = exporter.maxBackoff
+	}
+	exporter.nextAttempt = exporter.now().Add(exporter.backoff)
+}
+
+// Close closes the connection to Carbon, a next snapshot opens a new connection
+func (exporter *Exporter) Close() error {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	if exporter.conn == nil {
+		return nil
+	}
+	err := exporter.conn.Close()
+	exporter.conn = nil
+	return err
+}
@@ -0,0 +1,228 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/reporter"
+)
+
+// carbon is a local TCP listener that plays Carbon, it passes the connections it accepts and the lines it receives
+type carbon struct {
+	listener    net.Listener
+	connections chan net.Conn
+	lines       chan string
+}
+
+func startCarbon(t *testing.T) *carbon {
+	return startCarbonOn(t, "127.0.0.1:0")
+}
+
+func startCarbonOn(t *testing.T, address string) *carbon {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal("could not listen on tcp", err)
+	}
+	server := &carbon{listener, make(chan net.Conn, 10), make(chan string, 100)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.connections <- conn
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					server.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return server
+}
+
+func (server *carbon) receive(t *testing.T) string {
+	select {
+	case line := <-server.lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("carbon did not receive a line")
+		return ""
+	}
+}
+
+func counterSnapshot(value int64) *common.Snapshot {
+	return &common.Snapshot{TimestampCreated: 5000, CountersSnapshot: map[string]int64{"requests": value}}
+}
+
+func TestExporterImplementsSink(t *testing.T) {
+	var _ reporter.Sink = NewExporter(Config{})
+}
+
+func TestExporterSendsPlaintext(t *testing.T) {
+	server := startCarbon(t)
+	exporter := NewExporter(Config{Address: server.listener.Addr().String(), Prefix: "api"})
+	defer exporter.Close()
+	if err := exporter.Report(counterSnapshot(1)); err != nil {
+		t.Fatal("reporting failed", err)
+	}
+	if err := exporter.Report(counterSnapshot(2)); err != nil {
+		t.Fatal("reporting failed", err)
+	}
+	if line := server.receive(t); line != "api.requests 1 5" {
+		t.Error("unexpected line", line)
+	}
+	if line := server.receive(t); line != "api.requests 2 5" {
+		t.Error("unexpected line", line)
+	}
+	if len(server.connections) != 1 {
+		t.Error("the exporter should keep its connection open, got connections:", len(server.connections))
+	}
+}
+
+func TestExporterBacksOffAndReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close() // nothing listens on the address until carbon starts
+
+	now := time.Unix(1000, 0)
+	exporter := NewExporter(Config{Address: address, MinBackoff: time.Second, MaxBackoff: 3 * time.Second})
+	exporter.now = func() time.Time { return now }
+	defer exporter.Close()
+
+	if err := exporter.Report(counterSnapshot(1)); err == nil {
+		t.Fatal("reporting without carbon should fail")
+	}
+	if err := exporter.Report(counterSnapshot(1)); err == nil || !strings.Contains(err.Error(), "reconnecting in 1s") {
+		t.Error("expected to wait 1s before reconnecting but got", err)
+	}
+	now = now.Add(time.Second)
+	exporter.Report(counterSnapshot(1))
+	if exporter.backoff != 2*time.Second {
+		t.Error("expected the backoff to double to 2s but got", exporter.backoff)
+	}
+	now = now.Add(2 * time.Second)
+	exporter.Report(counterSnapshot(1))
+	if exporter.backoff != 3*time.Second {
+		t.Error("expected the backoff to be limited to 3s but got", exporter.backoff)
+	}
+
+	server := startCarbonOn(t, address)
+	now = now.Add(3 * time.Second)
+	if err := exporter.Report(counterSnapshot(7)); err != nil {
+		t.Fatal("reporting after carbon started should reconnect, but got", err)
+	}
+	if line := server.receive(t); line != "requests 7 5" {
+		t.Error("unexpected line", line)
+	}
+	if exporter.backoff != 0 {
+		t.Error("the backoff should be reset after a successful report")
+	}
+}
+
+func TestExporterReconnectsAfterConnectionLoss(t *testing.T) {
+	server := startCarbon(t)
+	now := time.Unix(1000, 0)
+	exporter := NewExporter(Config{Address: server.listener.Addr().String()})
+	exporter.now = func() time.Time { return now }
+	defer exporter.Close()
+	exporter.Report(counterSnapshot(1))
+	server.receive(t)
+	(<-server.connections).Close()
+
+	// writing to a connection that was closed by the other side fails within a few writes
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		time.Sleep(time.Millisecond)
+		err = exporter.Report(counterSnapshot(2))
+	}
+	if err == nil {
+		t.Fatal("expected reporting to a closed connection to fail")
+	}
+	now = now.Add(DefaultMinBackoff)
+	if err := exporter.Report(counterSnapshot(3)); err != nil {
+		t.Fatal("expected the exporter to reconnect but got", err)
+	}
+	select {
+	case <-server.connections:
+	case <-time.After(2 * time.Second):
+		t.Error("the exporter did not reconnect")
+	}
+}
+
+func TestExporterPickle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buffer := make([]byte, 1024)
+		n, _ := conn.Read(buffer)
+		received <- buffer[:n]
+	}()
+	exporter := NewExporter(Config{Address: listener.Addr().String(), Protocol: Pickle})
+	defer exporter.Close()
+	if err := exporter.Report(counterSnapshot(1)); err != nil {
+		t.Fatal("reporting failed", err)
+	}
+	select {
+	case data := <-received:
+		if len(data) < 4 || int(data[3]) != len(data)-4 || !strings.Contains(string(data), "requests") {
+			t.Errorf("expected a length prefixed pickle but got %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("carbon did not receive the pickle")
+	}
+}
+
+func TestNewExporterDefaults(t *testing.T) {
+	if exporter := NewExporter(Config{}); exporter.address != DefaultPlaintextAddress || exporter.timeout != DefaultTimeout {
+		t.Error("expected the plaintext defaults but got", exporter.address, exporter.timeout)
+	}
+	if exporter := NewExporter(Config{Protocol: Pickle}); exporter.address != DefaultPickleAddress {
+		t.Error("expected the pickle address to be the default but got", exporter.address)
+	}
+}
+
+func TestNewExporterInvalidBackoff(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewExporter should panic when the maximum backoff is smaller than the minimum, but no panic")
+		}
+	}()
+	NewExporter(Config{MinBackoff: time.Minute, MaxBackoff: time.Second})
+}
@@ -0,0 +1,179 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package graphite pushes patan snapshots to Carbon, the storage daemon of Graphite, in the plaintext or the pickle
+// protocol. example:
+// r := reporter.Start(metrics.New(), time.Minute, reporter.Reset, graphite.NewExporter(graphite.Config{Prefix: "api"}))
+// defer r.Stop()
+package graphite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// datapoint is a single value of a snapshot with its Graphite path
+type datapoint struct {
+	path  string
+	value float64
+}
+
+// Encode writes the snapshot to w in the Graphite plaintext protocol, a line of "path value timestamp" per value.
+// Counters and gauges are written under their key, distributions are flattened into key.count, key.min, key.max,
+// key.mean and key.stddev, and meters into key.count, key.m1_rate, key.m5_rate, key.m15_rate and key.mean_rate. Every
+// path starts with prefix, when it is not empty, and the tags of tagged series are written in the Graphite tag
+// syntax, for example db.query.mean;db=users. The timestamp is the time the snapshot was created, in seconds.
+func Encode(w io.Writer, prefix string, snapshot api.Snapshot) error {
+	writer := bufio.NewWriter(w)
+	timestamp := " " + strconv.FormatInt(snapshot.CreatedTimestamp()/1000, 10) + "\n"
+	for _, point := range datapoints(prefix, snapshot) {
+		writer.WriteString(point.path)
+		writer.WriteByte(' ')
+		writer.WriteString(formatFloat(point.value))
+		writer.WriteString(timestamp)
+	}
+	return writer.Flush()
+}
+
+// EncodePickle writes the same values as Encode to w in the Graphite pickle protocol, which is a 4 byte big-endian
+// length followed by a pickled list of (path, (timestamp, value)) tuples.
+func EncodePickle(w io.Writer, prefix string, snapshot api.Snapshot) error {
+	var payload bytes.Buffer
+	timestamp := snapshot.CreatedTimestamp() / 1000
+	payload.WriteString("\x80\x02") // protocol 2
+	payload.WriteString("](")       // an empty list and a mark for the appends
+	for _, point := range datapoints(prefix, snapshot) {
+		writePickleString(&payload, point.path)
+		writePickleInt(&payload, timestamp)
+		writePickleFloat(&payload, point.value)
+		payload.WriteString("\x86\x86") // (timestamp, value) and (path, (timestamp, value))
+	}
+	payload.WriteString("e.") // append everything since the mark and stop
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(payload.Len()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+func writePickleString(payload *bytes.Buffer, value string) {
+	payload.WriteByte('X') // BINUNICODE
+	binary.Write(payload, binary.LittleEndian, uint32(len(value)))
+	payload.WriteString(value)
+}
+
+func writePickleInt(payload *bytes.Buffer, value int64) {
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		writePickleFloat(payload, float64(value))
+		return
+	}
+	payload.WriteByte('J') // BININT
+	binary.Write(payload, binary.LittleEndian, int32(value))
+}
+
+func writePickleFloat(payload *bytes.Buffer, value float64) {
+	payload.WriteByte('G') // BINFLOAT
+	binary.Write(payload, binary.BigEndian, value)
+}
+
+// datapoints flattens the snapshot into datapoints sorted by path
+func datapoints(prefix string, snapshot api.Snapshot) []datapoint {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	series := snapshot.Series()
+	var points []datapoint
+	for key, value := range snapshot.Counters() {
+		points = append(points, datapoint{path(prefix, series, key, ""), float64(value)})
+	}
+	for key, value := range snapshot.Gauges() {
+		points = append(points, datapoint{path(prefix, series, key, ""), value})
+	}
+	for key, meter := range snapshot.Meters() {
+		points = append(points,
+			datapoint{path(prefix, series, key, "count"), float64(meter.Count())},
+			datapoint{path(prefix, series, key, "m1_rate"), meter.Rate1()},
+			datapoint{path(prefix, series, key, "m5_rate"), meter.Rate5()},
+			datapoint{path(prefix, series, key, "m15_rate"), meter.Rate15()},
+			datapoint{path(prefix, series, key, "mean_rate"), meter.RateMean()})
+	}
+	points = appendDistributions(points, prefix, series, snapshot.Durations())
+	points = appendDistributions(points, prefix, series, snapshot.Samples())
+	sort.Slice(points, func(i, j int) bool { return points[i].path < points[j].path })
+	return points
+}
+
+func appendDistributions(points []datapoint, prefix string, series map[string]api.Series,
+	distributions map[string]api.Distribution) []datapoint {
+	for key, dist := range distributions {
+		points = append(points, datapoint{path(prefix, series, key, "count"), float64(dist.SampleCount())})
+		if dist.SampleCount() > 0 {
+			points = append(points,
+				datapoint{path(prefix, series, key, "min"), dist.Min()},
+				datapoint{path(prefix, series, key, "max"), dist.Max()},
+				datapoint{path(prefix, series, key, "mean"), dist.Avg()},
+				datapoint{path(prefix, series, key, "stddev"), dist.StdDev()})
+		}
+	}
+	return points
+}
+
+// path returns the Graphite path of the series key with field appended to the key, followed by the tags, see
+// common.SplitSeriesKey
+func path(prefix string, series map[string]api.Series, seriesKey, field string) string {
+	key, tags := common.SplitSeriesKey(series, seriesKey)
+	var path strings.Builder
+	path.WriteString(prefix)
+	path.WriteString(pathReplacer.Replace(key))
+	if field != "" {
+		path.WriteByte('.')
+		path.WriteString(field)
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path.WriteByte(';')
+		path.WriteString(tagReplacer.Replace(name))
+		path.WriteByte('=')
+		path.WriteString(tagReplacer.Replace(tags[name]))
+	}
+	return path.String()
+}
+
+var (
+	pathReplacer = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_")
+	tagReplacer  = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_", "=", "_", "~", "_")
+)
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
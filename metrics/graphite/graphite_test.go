@@ -0,0 +1,92 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package graphite
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+func testSnapshot() api.Snapshot {
+	dist := common.NewDistribution()
+	dist.AddSample(10)
+	dist.AddSample(20)
+	return &common.Snapshot{
+		TimestampCreated:  1480792556683,
+		CountersSnapshot:  map[string]int64{"http.requests{method=GET,status=200}": 3, "active sessions": 2},
+		DurationsSnapshot: map[string]api.Distribution{"db.query": dist, "db.empty": common.NewDistribution()},
+		GaugesSnapshot:    map[string]float64{"queue.depth": 1.5},
+		MetersSnapshot:    map[string]api.Meter{"logins": &common.MeterSnapshot{Events: 4, M1: 0.5, M5: 0.25, M15: 0.125, Mean: 1}},
+	}
+}
+
+func TestEncode(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, "api", testSnapshot()); err != nil {
+		t.Fatal("encoding failed", err)
+	}
+	expected := `api.active_sessions 2 1480792556
+api.db.empty.count 0 1480792556
+api.db.query.count 2 1480792556
+api.db.query.max 20 1480792556
+api.db.query.mean 15 1480792556
+api.db.query.min 10 1480792556
+api.db.query.stddev 7.0710678118654755 1480792556
+api.http.requests;method=GET;status=200 3 1480792556
+api.logins.count 4 1480792556
+api.logins.m15_rate 0.125 1480792556
+api.logins.m1_rate 0.5 1480792556
+api.logins.m5_rate 0.25 1480792556
+api.logins.mean_rate 1 1480792556
+api.queue.depth 1.5 1480792556
+`
+	if buffer.String() != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, buffer.String())
+	}
+}
+
+func TestEncodeWithoutPrefix(t *testing.T) {
+	var buffer bytes.Buffer
+	snapshot := &common.Snapshot{TimestampCreated: 2000, CountersSnapshot: map[string]int64{"requests": 1}}
+	Encode(&buffer, "", snapshot)
+	if buffer.String() != "requests 1 2\n" {
+		t.Errorf("expected no prefix, got %q", buffer.String())
+	}
+}
+
+func TestEncodePickle(t *testing.T) {
+	var buffer bytes.Buffer
+	snapshot := &common.Snapshot{TimestampCreated: 2000, CountersSnapshot: map[string]int64{"requests": 1}}
+	if err := EncodePickle(&buffer, "api.", snapshot); err != nil {
+		t.Fatal("encoding failed", err)
+	}
+	// pickle.dumps([("api.requests", (2, 1.0))], protocol=2) with BINUNICODE, BININT and BINFLOAT
+	payload := "\x80\x02](" +
+		"X\x0c\x00\x00\x00api.requests" +
+		"J\x02\x00\x00\x00" +
+		"G\x3f\xf0\x00\x00\x00\x00\x00\x00" +
+		"\x86\x86e."
+	expected := string([]byte{0, 0, 0, byte(len(payload))}) + payload
+	if buffer.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buffer.String())
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// Handler returns a http.Handler that serves snapshots of the global instance as json, see NewHandler
+func Handler() http.Handler {
+	return NewHandler(std)
+}
+
+// NewHandler returns a http.Handler that serves snapshots of facade as json. The following query parameters are
+// supported:
+//
+//	reset=true    takes the snapshot with SnapshotAndReset() instead of Snapshot()
+//	prefix=db.    only includes keys that start with db., can be repeated
+//	glob=api.*.ok only includes keys that match the glob, * matches any characters and ? a single one, can be repeated
+//	pretty=true   indents the json output
+//
+// When both prefix and glob are given, keys that match any of them are included. Values that JSON cannot hold, such as
+// a NaN gauge, are left out, see common.Snapshot.MarshalJSON, so a reset does not discard a snapshot that fails to
+// encode.
+func NewHandler(facade api.Facade) http.Handler {
+	if facade == nil {
+		panic("facade = nil, Handler needs a facade")
+	}
+	return &snapshotHandler{facade: facade}
+}
+
+// DeltaHandler returns a http.Handler that serves what changed in the global instance since the previous request,
+// see NewDeltaHandler
+func DeltaHandler() http.Handler {
+	return NewDeltaHandler(std)
+}
+
+// NewDeltaHandler returns a http.Handler that serves what changed in facade since the previous request as json,
+// without resetting the facade, see api.Cursor. The query parameters prefix, glob and pretty are supported, see
+// NewHandler.
+func NewDeltaHandler(facade api.Facade) http.Handler {
+	if facade == nil {
+		panic("facade = nil, Handler needs a facade")
+	}
+	return &snapshotHandler{facade: facade, cursor: facade.Cursor()}
+}
+
+type snapshotHandler struct {
+	facade api.Facade
+	cursor api.Cursor
+}
+
+func (handler *snapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	reset, err := parseBoolParameter(query.Get("reset"))
+	if err != nil {
+		http.Error(w, "reset: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	pretty, err := parseBoolParameter(query.Get("pretty"))
+	if err != nil {
+		http.Error(w, "pretty: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reset && handler.cursor != nil {
+		http.Error(w, "reset: not supported when serving deltas", http.StatusBadRequest)
+		return
+	}
+	include := keyFilter(query["prefix"], query["glob"])
+
+	var snapshot api.Snapshot
+	if handler.cursor != nil {
+		snapshot = handler.cursor.Next()
+	} else if reset {
+		snapshot = handler.facade.SnapshotAndReset()
+	} else {
+		snapshot = handler.facade.Snapshot()
+	}
+	if include != nil {
+		snapshot = common.FilterSnapshot(snapshot, include)
+	}
+
+	var data []byte
+	if pretty {
+		data, err = json.MarshalIndent(snapshot, "", "  ")
+	} else {
+		data, err = json.Marshal(snapshot)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func parseBoolParameter(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// keyFilter returns a function that accepts keys that have one of the prefixes or match one of the globs, or nil
+// if there are neither prefixes nor globs
+func keyFilter(prefixes, globs []string) func(key string) bool {
+	if len(prefixes) == 0 && len(globs) == 0 {
+		return nil
+	}
+	patterns := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		patterns = append(patterns, globToRegexp(glob))
+	}
+	return func(key string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		for _, pattern := range patterns {
+			if pattern.MatchString(key) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func globToRegexp(glob string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.Replace(pattern, `\*`, `.*`, -1)
+	pattern = strings.Replace(pattern, `\?`, `.`, -1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
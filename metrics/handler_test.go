@@ -0,0 +1,167 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+func TestHandlerServesGlobalSnapshot(t *testing.T) {
+	Reset()
+	IncrementCounter("handler.counter")
+	body := serve(Handler(), "/metrics", 200, t)
+	snapshot, err := common.ParseSnapshot([]byte(body))
+	if err != nil {
+		t.Fatal("the handler did not serve a valid snapshot", err, body)
+	}
+	if snapshot.Counters()["handler.counter"] != 1 {
+		t.Errorf("the snapshot of the global instance should contain handler.counter, got %v", body)
+	}
+}
+
+func TestHandlerReset(t *testing.T) {
+	facade := New()
+	facade.AddSample("sample", 10)
+	body := serve(NewHandler(facade), "/metrics", 200, t)
+	if !strings.Contains(body, `"sample"`) || len(facade.Snapshot().Samples()) != 1 {
+		t.Error("without reset, the facade should keep its state")
+	}
+	body = serve(NewHandler(facade), "/metrics?reset=true", 200, t)
+	if !strings.Contains(body, `"sample"`) || len(facade.Snapshot().Samples()) != 0 {
+		t.Error("reset=true should serve the snapshot and reset the facade")
+	}
+}
+
+func TestHandlerResetWithNonFiniteValues(t *testing.T) {
+	facade := New()
+	facade.IncrementCounter("counter")
+	facade.AddSample("sample", math.NaN())
+	facade.SetGauge("gauge", math.Inf(1))
+	body := serve(NewHandler(facade), "/metrics?reset=true", 200, t)
+	snapshot, err := common.ParseSnapshot([]byte(body))
+	if err != nil {
+		t.Fatal("the handler did not serve a valid snapshot", err, body)
+	}
+	if snapshot.Counters()["counter"] != 1 || len(snapshot.Samples()) != 0 || len(snapshot.Gauges()) != 0 {
+		t.Errorf("expected the counter without the non-finite sample and gauge, got %v", body)
+	}
+}
+
+func TestDeltaHandler(t *testing.T) {
+	facade := New()
+	facade.IncrementCounter("counter")
+	handler := NewDeltaHandler(facade)
+	facade.AddToCounter("counter", 2)
+	facade.AddSample("db.rows", 10)
+	body := serve(handler, "/metrics?prefix=db.", 200, t)
+	if strings.Contains(body, `"counter"`) || !strings.Contains(body, `"db.rows"`) {
+		t.Errorf("the delta handler should apply the filters, got %v", body)
+	}
+	snapshot, _ := common.ParseSnapshot([]byte(serve(handler, "/metrics", 200, t)))
+	if snapshot.Counters()["counter"] != 0 || len(snapshot.Samples()) != 0 {
+		t.Errorf("the second request should only serve what changed since the first, got %v", snapshot.Counters())
+	}
+	facade.IncrementCounter("counter")
+	snapshot, _ = common.ParseSnapshot([]byte(serve(handler, "/metrics", 200, t)))
+	if snapshot.Counters()["counter"] != 1 {
+		t.Error("expected the delta of counter to be 1 but got", snapshot.Counters()["counter"])
+	}
+	serve(handler, "/metrics?reset=true", 400, t)
+	if facade.Snapshot().Counters()["counter"] != 4 {
+		t.Error("the delta handler should not reset the facade")
+	}
+}
+
+func TestHandlerFilters(t *testing.T) {
+	facade := New()
+	facade.IncrementCounter("db.connections")
+	facade.IncrementCounter("api.music.songs.ok")
+	facade.IncrementCounter("api.music.albums.error")
+	facade.AddSample("mem.allocations", 10)
+	handler := NewHandler(facade)
+
+	expectations := map[string][]string{
+		"/metrics?prefix=db.":                          {"db.connections"},
+		"/metrics?glob=api.*.ok":                       {"api.music.songs.ok"},
+		"/metrics?glob=api.music.?????s.*":             {"api.music.albums.error"},
+		"/metrics?prefix=db.&glob=api.*.ok&prefix=mem": {"db.connections", "api.music.songs.ok", "mem.allocations"},
+		"/metrics?prefix=nothing":                      {},
+	}
+	for url, expectedKeys := range expectations {
+		snapshot, _ := common.ParseSnapshot([]byte(serve(handler, url, 200, t)))
+		if len(snapshot.Counters())+len(snapshot.Samples()) != len(expectedKeys) {
+			t.Errorf("%v should contain %v but contains %v and %v", url, expectedKeys, snapshot.Counters(), snapshot.Samples())
+		}
+		for _, key := range expectedKeys {
+			if _, exists := snapshot.Counters()[key]; !exists {
+				if _, exists := snapshot.Samples()[key]; !exists {
+					t.Errorf("%v should contain %v", url, key)
+				}
+			}
+		}
+	}
+}
+
+func TestHandlerPretty(t *testing.T) {
+	facade := New()
+	if body := serve(NewHandler(facade), "/metrics", 200, t); strings.Contains(body, "\n") {
+		t.Error("without pretty, the json should not be indented")
+	}
+	if body := serve(NewHandler(facade), "/metrics?pretty=true", 200, t); !strings.Contains(body, "\n  \"counters\"") {
+		t.Errorf("pretty=true should indent the json, got %v", body)
+	}
+}
+
+func TestHandlerInvalidParameters(t *testing.T) {
+	serve(Handler(), "/metrics?reset=maybe", 400, t)
+	serve(Handler(), "/metrics?pretty=yes", 400, t)
+}
+
+func TestNewHandlerWithNilFacade(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewHandler should panic when facade=nil, but no panic")
+		}
+	}()
+	NewHandler(nil)
+}
+
+func TestNewDeltaHandlerWithNilFacade(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewDeltaHandler should panic when facade=nil, but no panic")
+		}
+	}()
+	NewDeltaHandler(nil)
+}
+
+func serve(handler http.Handler, url string, expectedStatus int, t *testing.T) string {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	if recorder.Code != expectedStatus {
+		t.Errorf("GET %v returned status %v, expected %v", url, recorder.Code, expectedStatus)
+	}
+	return recorder.Body.String()
+}
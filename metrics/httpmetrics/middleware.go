@@ -0,0 +1,245 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package httpmetrics records the requests served by a net/http server and the calls made by a net/http client.
+// example:
+// http.ListenAndServe(":8080", httpmetrics.Middleware(httpmetrics.Config{})(mux))
+// client := &http.Client{Transport: httpmetrics.Transport(nil, httpmetrics.TransportConfig{})}
+package httpmetrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/toefel18/go-patan/metrics"
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// DefaultServerPrefix is the prefix of the keys when Config.Prefix is empty
+const DefaultServerPrefix = "http.server"
+
+// RouteExtractor returns the name of the route of a request, which should not contain ids or other variable parts of
+// the path, so the number of series stays small. It is called after the request was served.
+type RouteExtractor func(r *http.Request) string
+
+// DefaultRouteExtractor returns the pattern of the http.ServeMux route that served the request, without the method,
+// for example /users/{id}. Returns unmatched when no pattern matched, which is always the case when the patterns of
+// http.ServeMux are disabled, for example with GODEBUG=httpmuxgo121=1.
+func DefaultRouteExtractor(r *http.Request) string {
+	pattern := r.Pattern
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " ")
+	}
+	if pattern == "" {
+		return "unmatched"
+	}
+	return pattern
+}
+
+// Config configures a middleware, fields with a zero value get a default
+type Config struct {
+	// Prefix is the prefix of the keys, defaults to DefaultServerPrefix
+	Prefix string
+	// RouteExtractor determines the route tag of a request, defaults to DefaultRouteExtractor
+	RouteExtractor RouteExtractor
+}
+
+// recorder is the part of api.Facade the middleware and the transport record with, so they can record in the global
+// metrics instance
+type recorder interface {
+	RecordElapsedTimeWithTags(key string, tags api.Tags, stopwatch api.Stopwatch) float64
+	AddSampleWithTags(key string, tags api.Tags, value float64)
+	IncrementCounterWithTags(key string, tags api.Tags)
+	RegisterGaugeFunc(key string, gauge func() float64)
+}
+
+// global records in the global metrics instance
+type global struct{}
+
+func (global) RecordElapsedTimeWithTags(key string, tags api.Tags, stopwatch api.Stopwatch) float64 {
+	return metrics.RecordElapsedTimeWithTags(key, tags, stopwatch)
+}
+
+func (global) AddSampleWithTags(key string, tags api.Tags, value float64) {
+	metrics.AddSampleWithTags(key, tags, value)
+}
+
+func (global) IncrementCounterWithTags(key string, tags api.Tags) {
+	metrics.IncrementCounterWithTags(key, tags)
+}
+
+func (global) RegisterGaugeFunc(key string, gauge func() float64) {
+	metrics.RegisterGaugeFunc(key, gauge)
+}
+
+// Middleware returns a middleware that records the requests in the global metrics instance, see NewMiddleware
+func Middleware(config Config) func(http.Handler) http.Handler {
+	return newMiddleware(global{}, config)
+}
+
+// NewMiddleware returns a middleware that records the duration of every request in facade under prefix.duration and
+// the size of its response body in bytes under prefix.response.size, both tagged with the method, the route and the
+// status class (2xx, 4xx, ...) of the request. Requests with a non-standard method are tagged with the method OTHER.
+// The number of requests in flight is the gauge prefix.in_flight, which is shared by all middlewares with the same
+// facade and prefix. When a handler panics, the request is recorded with the status class 5xx before the panic continues.
+func NewMiddleware(facade api.Facade, config Config) func(http.Handler) http.Handler {
+	if facade == nil {
+		panic("facade = nil, Middleware needs a facade")
+	}
+	return newMiddleware(facade, config)
+}
+
+func newMiddleware(recorder recorder, config Config) func(http.Handler) http.Handler {
+	if config.Prefix == "" {
+		config.Prefix = DefaultServerPrefix
+	}
+	if config.RouteExtractor == nil {
+		config.RouteExtractor = DefaultRouteExtractor
+	}
+	inFlight := inFlightCounter(recorder, config.Prefix+".in_flight")
+	durationKey := config.Prefix + ".duration"
+	sizeKey := config.Prefix + ".response.size"
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := common.StartNewStopwatch()
+			atomic.AddInt64(inFlight, 1)
+			writer := &responseWriter{ResponseWriter: w}
+			served := false
+			defer func() {
+				atomic.AddInt64(inFlight, -1)
+				status := writer.status
+				if !served {
+					status = http.StatusInternalServerError
+				}
+				tags := api.Tags{"method": Method(r.Method), "route": config.RouteExtractor(r), "status": StatusClass(status)}
+				recorder.RecordElapsedTimeWithTags(durationKey, tags, sw)
+				recorder.AddSampleWithTags(sizeKey, tags, float64(writer.size))
+			}()
+			next.ServeHTTP(writer, r)
+			served = true
+		})
+	}
+}
+
+// inFlightCounters holds the in flight counters by recorder and key, so middlewares that record under the same key
+// share the counter instead of replacing each other's gauge
+var inFlightCounters = struct {
+	sync.Mutex
+	byKey map[inFlightKey]*int64
+}{byKey: make(map[inFlightKey]*int64)}
+
+type inFlightKey struct {
+	recorder recorder
+	key      string
+}
+
+// inFlightCounter returns the counter of the gauge key in recorder, and registers the gauge when it does not exist yet
+func inFlightCounter(recorder recorder, key string) *int64 {
+	inFlightCounters.Lock()
+	defer inFlightCounters.Unlock()
+	counter, exists := inFlightCounters.byKey[inFlightKey{recorder, key}]
+	if !exists {
+		counter = new(int64)
+		inFlightCounters.byKey[inFlightKey{recorder, key}] = counter
+		recorder.RegisterGaugeFunc(key, func() float64 {
+			return float64(atomic.LoadInt64(counter))
+		})
+	}
+	return counter
+}
+
+var standardMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// Method returns method when it is one of the standard HTTP methods, and OTHER otherwise, so clients cannot create
+// an unbounded number of series
+func Method(method string) string {
+	if standardMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// StatusClass returns the class of an HTTP status code, for example 2xx for 204. A status of 0, which means nothing
+// was written, is 2xx because net/http sends 200 OK in that case.
+func StatusClass(status int) string {
+	if status == 0 {
+		return "2xx"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// responseWriter remembers the status code and counts the bytes written to the body
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (writer *responseWriter) WriteHeader(status int) {
+	// informational headers, such as 103 Early Hints, can be followed by the final status
+	if writer.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		writer.status = status
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *responseWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	n, err := writer.ResponseWriter.Write(data)
+	writer.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, when the wrapped http.ResponseWriter supports it
+func (writer *responseWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		if writer.status == 0 {
+			writer.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, when the wrapped http.ResponseWriter supports it
+func (writer *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := writer.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("httpmetrics: the http.ResponseWriter does not implement http.Hijacker")
+	}
+	if writer.status == 0 {
+		writer.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for http.ResponseController
+func (writer *responseWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
@@ -0,0 +1,206 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// the patterns of http.ServeMux are only enabled by default in modules
+//go:debug httpmuxgo121=0
+
+package httpmetrics
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics"
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/lockbased"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("user " + r.PathValue("id")))
+	})
+	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid user", http.StatusBadRequest)
+	})
+	handler := NewMiddleware(facade, Config{})(mux)
+
+	for _, request := range []*http.Request{
+		httptest.NewRequest("GET", "/users/1", nil),
+		httptest.NewRequest("GET", "/users/2", nil),
+		httptest.NewRequest("POST", "/users", nil),
+		httptest.NewRequest("GET", "/unknown", nil),
+		httptest.NewRequest("BREW", "/users/1", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	snapshot := facade.Snapshot()
+	usersTags := api.Tags{"method": "GET", "route": "/users/{id}", "status": "2xx"}
+	users := snapshot.Durations()[common.SeriesKey("http.server.duration", usersTags)]
+	if users == nil || users.SampleCount() != 2 || users.Min() < 10 {
+		t.Errorf("expected 2 durations of at least 10ms for GET /users/{id} but got %v", snapshot.Durations())
+	}
+	for _, key := range []string{
+		"http.server.duration{method=POST,route=/users,status=4xx}",
+		"http.server.duration{method=GET,route=unmatched,status=4xx}",
+		"http.server.duration{method=OTHER,route=unmatched,status=4xx}",
+	} {
+		if duration := snapshot.Durations()[key]; duration == nil || duration.SampleCount() != 1 {
+			t.Errorf("expected a duration for %v but got %v", key, snapshot.Durations())
+		}
+	}
+	size := snapshot.Samples()[common.SeriesKey("http.server.response.size", usersTags)]
+	if size == nil || size.Max() != float64(len("user 1")) {
+		t.Errorf("expected the response size to be recorded but got %v", snapshot.Samples())
+	}
+	if inFlight, exists := snapshot.Gauges()["http.server.in_flight"]; !exists || inFlight != 0 {
+		t.Error("expected no requests in flight but got", inFlight)
+	}
+}
+
+func TestMiddlewareCountsInFlightRequests(t *testing.T) {
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	inside := make(chan struct{})
+	release := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inside <- struct{}{}
+		<-release
+	})
+	handler := NewMiddleware(facade, Config{Prefix: "api"})(blocking)
+	for i := 0; i < 2; i++ {
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		<-inside
+	}
+	other := NewMiddleware(facade, Config{Prefix: "api"})(blocking)
+	go other.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-inside
+	if inFlight := facade.Snapshot().Gauges()["api.in_flight"]; inFlight != 3 {
+		t.Error("expected the middlewares with the same prefix to count 3 requests in flight but got", inFlight)
+	}
+	close(release)
+}
+
+func TestMiddlewareRouteExtractor(t *testing.T) {
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	route := func(r *http.Request) string { return strings.SplitN(r.URL.Path, "/", 3)[1] }
+	handler := NewMiddleware(facade, Config{RouteExtractor: route})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/songs/12", nil))
+	if facade.Snapshot().Durations()["http.server.duration{method=DELETE,route=songs,status=2xx}"] == nil {
+		t.Errorf("expected the route of the extractor but got %v", facade.Snapshot().Durations())
+	}
+}
+
+func TestMiddlewareRecordsPanics(t *testing.T) {
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	handler := NewMiddleware(facade, Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("failed")
+	}))
+	func() {
+		defer func() {
+			if r := recover(); r != "failed" {
+				t.Error("the panic should continue after it was recorded, got", r)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	if facade.Snapshot().Durations()["http.server.duration{method=GET,route=unmatched,status=5xx}"] == nil {
+		t.Errorf("expected the panic to be recorded as 5xx but got %v", facade.Snapshot().Durations())
+	}
+}
+
+func TestMiddlewareUsesGlobalInstance(t *testing.T) {
+	metrics.Reset()
+	handler := Middleware(Config{Prefix: "global.http"})(http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if metrics.Snapshot().Durations()["global.http.duration{method=GET,route=unmatched,status=4xx}"] == nil {
+		t.Errorf("expected the request to be recorded in the global instance but got %v", metrics.Snapshot().Durations())
+	}
+}
+
+func TestNewMiddlewareWithNilFacade(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewMiddleware should panic when facade=nil, but no panic")
+		}
+	}()
+	NewMiddleware(nil, Config{})
+}
+
+// hijackableRecorder is a ResponseRecorder that supports hijacking
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (recorder *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	recorder.hijacked = true
+	return nil, nil, nil
+}
+
+func TestResponseWriterKeepsInterfaces(t *testing.T) {
+	recorder := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+	writer := &responseWriter{ResponseWriter: recorder}
+	writer.Flush()
+	if !recorder.Flushed || writer.status != http.StatusOK {
+		t.Error("Flush should flush the wrapped writer and imply a 200")
+	}
+	if _, _, err := writer.Hijack(); err != nil || !recorder.hijacked {
+		t.Error("Hijack should hijack the wrapped writer but got", err)
+	}
+	if err := http.NewResponseController(writer).Flush(); err != nil {
+		t.Error("the ResponseController should find the wrapped writer, got", err)
+	}
+	plain := &responseWriter{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := plain.Hijack(); err == nil {
+		t.Error("hijacking a writer that does not support it should fail")
+	}
+}
+
+func TestResponseWriterStatus(t *testing.T) {
+	writer := &responseWriter{ResponseWriter: httptest.NewRecorder()}
+	writer.WriteHeader(http.StatusEarlyHints)
+	writer.WriteHeader(http.StatusNotFound)
+	if writer.status != http.StatusNotFound {
+		t.Error("expected the final status 404 but got", writer.status)
+	}
+	writer = &responseWriter{ResponseWriter: httptest.NewRecorder()}
+	writer.Write([]byte("found"))
+	writer.Write([]byte(" it"))
+	if writer.status != http.StatusOK || writer.size != 8 {
+		t.Error("expected the implied status 200 and 8 bytes but got", writer.status, writer.size)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for status, expected := range map[int]string{0: "2xx", 200: "2xx", 204: "2xx", 301: "3xx", 404: "4xx", 503: "5xx"} {
+		if class := StatusClass(status); class != expected {
+			t.Errorf("expected status %v to be %v but got %v", status, expected, class)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package httpmetrics
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// DefaultClientPrefix is the prefix of the keys when TransportConfig.Prefix is empty
+const DefaultClientPrefix = "http.client"
+
+// TransportConfig configures a transport, fields with a zero value get a default
+type TransportConfig struct {
+	// Prefix is the prefix of the keys, defaults to DefaultClientPrefix
+	Prefix string
+	// Trace enables recording the DNS lookup, connect, TLS handshake and time to first byte of every call with
+	// net/http/httptrace
+	Trace bool
+}
+
+// Transport returns a transport that records the calls in the global metrics instance, see NewTransport
+func Transport(next http.RoundTripper, config TransportConfig) http.RoundTripper {
+	return newTransport(global{}, next, config)
+}
+
+// NewTransport returns a transport that sends the requests with next, or http.DefaultTransport when next is nil, and
+// records the duration of every call in facade under prefix.duration, tagged with the host, the method and the status
+// class of the response. The duration ends when the headers of the response are received, reading the body is not
+// included. When next returns an error, the duration is recorded with the outcome of the call as status, which is
+// error, canceled or deadline_exceeded, see common.Outcome, and the counter prefix.errors is incremented with the
+// same tags.
+//
+// When tracing is enabled, prefix.dns, prefix.connect, prefix.tls and prefix.ttfb are recorded as well, tagged with
+// the host. Calls that reuse a connection only record prefix.ttfb.
+func NewTransport(facade api.Facade, next http.RoundTripper, config TransportConfig) http.RoundTripper {
+	if facade == nil {
+		panic("facade = nil, Transport needs a facade")
+	}
+	return newTransport(facade, next, config)
+}
+
+func newTransport(recorder recorder, next http.RoundTripper, config TransportConfig) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if config.Prefix == "" {
+		config.Prefix = DefaultClientPrefix
+	}
+	return &transport{
+		recorder:    recorder,
+		next:        next,
+		trace:       config.Trace,
+		durationKey: config.Prefix + ".duration",
+		errorsKey:   config.Prefix + ".errors",
+		dnsKey:      config.Prefix + ".dns",
+		connectKey:  config.Prefix + ".connect",
+		tlsKey:      config.Prefix + ".tls",
+		ttfbKey:     config.Prefix + ".ttfb",
+	}
+}
+
+// transport is the http.RoundTripper returned by NewTransport
+type transport struct {
+	recorder recorder
+	next     http.RoundTripper
+	trace    bool
+
+	durationKey, errorsKey              string
+	dnsKey, connectKey, tlsKey, ttfbKey string
+}
+
+// RoundTrip implements http.RoundTripper
+func (transport *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	sw := common.StartNewStopwatch()
+	host := r.URL.Host
+	if transport.trace {
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), transport.clientTrace(host, sw)))
+	}
+	response, err := transport.next.RoundTrip(r)
+	tags := api.Tags{"host": host, "method": Method(r.Method)}
+	if err != nil {
+		tags["status"] = common.Outcome(r.Context(), err)
+		transport.recorder.RecordElapsedTimeWithTags(transport.durationKey, tags, sw)
+		transport.recorder.IncrementCounterWithTags(transport.errorsKey, tags)
+		return response, err
+	}
+	tags["status"] = StatusClass(response.StatusCode)
+	transport.recorder.RecordElapsedTimeWithTags(transport.durationKey, tags, sw)
+	return response, nil
+}
+
+// clientTrace returns the hooks that record the phases of a call to host, the time to first byte is measured with
+// the stopwatch of the call
+func (transport *transport) clientTrace(host string, call api.Stopwatch) *httptrace.ClientTrace {
+	tags := api.Tags{"host": host}
+	var lock sync.Mutex
+	var dns, handshake api.Stopwatch
+	// connections to multiple addresses can be dialed at the same time, see RFC 8305
+	connects := make(map[string]api.Stopwatch)
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock.Lock()
+			dns = common.StartNewStopwatch()
+			lock.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			if dns != nil {
+				transport.recorder.RecordElapsedTimeWithTags(transport.dnsKey, tags, dns)
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			lock.Lock()
+			connects[network+addr] = common.StartNewStopwatch()
+			lock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if sw, exists := connects[network+addr]; exists && err == nil {
+				transport.recorder.RecordElapsedTimeWithTags(transport.connectKey, tags, sw)
+			}
+		},
+		TLSHandshakeStart: func() {
+			lock.Lock()
+			handshake = common.StartNewStopwatch()
+			lock.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if handshake != nil && err == nil {
+				transport.recorder.RecordElapsedTimeWithTags(transport.tlsKey, tags, handshake)
+			}
+		},
+		GotFirstResponseByte: func() {
+			transport.recorder.RecordElapsedTimeWithTags(transport.ttfbKey, tags, call)
+		},
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package httpmetrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics"
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/lockbased"
+)
+
+func TestNewTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	host := hostOf(server.URL)
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	client := &http.Client{Transport: NewTransport(facade, nil, TransportConfig{})}
+	for _, path := range []string{"/", "/", "/missing"} {
+		response, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal("the call should succeed but got", err)
+		}
+		response.Body.Close()
+	}
+
+	snapshot := facade.Snapshot()
+	found := snapshot.Durations()[common.SeriesKey("http.client.duration", api.Tags{"host": host, "method": "GET", "status": "2xx"})]
+	missing := snapshot.Durations()[common.SeriesKey("http.client.duration", api.Tags{"host": host, "method": "GET", "status": "4xx"})]
+	if found == nil || found.SampleCount() != 2 || missing == nil || missing.SampleCount() != 1 {
+		t.Errorf("expected 2 calls with 2xx and 1 with 4xx but got %v", snapshot.Durations())
+	}
+	if len(snapshot.Durations()) != 2 || len(snapshot.Counters()) != 0 {
+		t.Errorf("expected no traces or errors without tracing but got %v and %v", snapshot.Durations(), snapshot.Counters())
+	}
+}
+
+func TestTransportCountsErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	host := hostOf(server.URL)
+	server.Close()
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	client := &http.Client{Transport: NewTransport(facade, nil, TransportConfig{Prefix: "downstream"})}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("a call to a closed server should fail")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request, _ := http.NewRequestWithContext(ctx, "POST", server.URL, nil)
+	if _, err := client.Do(request); err == nil {
+		t.Fatal("a canceled call should fail")
+	}
+
+	snapshot := facade.Snapshot()
+	for _, tags := range []api.Tags{
+		{"host": host, "method": "GET", "status": common.OutcomeError},
+		{"host": host, "method": "POST", "status": common.OutcomeCanceled},
+	} {
+		if snapshot.Counters()[common.SeriesKey("downstream.errors", tags)] != 1 {
+			t.Errorf("expected an error for %v but got %v", tags, snapshot.Counters())
+		}
+		if snapshot.Durations()[common.SeriesKey("downstream.duration", tags)] == nil {
+			t.Errorf("expected a duration for %v but got %v", tags, snapshot.Durations())
+		}
+	}
+}
+
+func TestTransportTrace(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	tags := api.Tags{"host": hostOf(server.URL)}
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	client := &http.Client{Transport: NewTransport(facade, server.Client().Transport, TransportConfig{Trace: true})}
+	for i := 0; i < 2; i++ {
+		response, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal("the call should succeed but got", err)
+		}
+		io.Copy(io.Discard, response.Body) // the connection is only reused when the body was read
+		response.Body.Close()
+	}
+
+	snapshot := facade.Snapshot()
+	for key, count := range map[string]int64{"http.client.connect": 1, "http.client.tls": 1, "http.client.ttfb": 2} {
+		if distribution := snapshot.Durations()[common.SeriesKey(key, tags)]; distribution == nil || distribution.SampleCount() != count {
+			t.Errorf("expected %v durations of %v, the second call reuses the connection, but got %v", count, key, snapshot.Durations())
+		}
+	}
+	if _, exists := snapshot.Durations()[common.SeriesKey("http.client.dns", tags)]; exists {
+		t.Error("the address of the server needs no DNS lookup")
+	}
+}
+
+func TestTransportUsesGlobalInstance(t *testing.T) {
+	metrics.Reset()
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	client := &http.Client{Transport: Transport(nil, TransportConfig{Prefix: "global.client"})}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal("the call should succeed but got", err)
+	}
+	response.Body.Close()
+	tags := api.Tags{"host": hostOf(server.URL), "method": "GET", "status": "4xx"}
+	if metrics.Snapshot().Durations()[common.SeriesKey("global.client.duration", tags)] == nil {
+		t.Errorf("expected the call to be recorded in the global instance but got %v", metrics.Snapshot().Durations())
+	}
+}
+
+func TestNewTransportWithNilFacade(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewTransport should panic when facade=nil, but no panic")
+		}
+	}()
+	NewTransport(nil, nil, TransportConfig{})
+}
+
+func hostOf(rawURL string) string {
+	parsed, _ := url.Parse(rawURL)
+	return parsed.Host
+}
@@ -0,0 +1,154 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package influx encodes patan snapshots in the InfluxDB line protocol and writes them to the /write endpoint of
+// InfluxDB. example:
+// writer := influx.NewWriter(influx.Config{URL: "http://localhost:8086", Database: "metrics"})
+// r := reporter.Start(metrics.New(), time.Minute, reporter.Reset, writer)
+// defer r.Stop()
+package influx
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// KeyParser determines the measurement and tags of a series key
+type KeyParser func(seriesKey string) (measurement string, tags api.Tags)
+
+// DefaultKeyParser uses the key of the series as measurement and the tags of the series as tags, see
+// common.ParseSeriesKey. Encode uses the tagged series of the snapshot instead when no parser is given, see
+// api.Snapshot.Series, so plain keys that contain braces stay plain.
+func DefaultKeyParser(seriesKey string) (string, api.Tags) {
+	return common.ParseSeriesKey(seriesKey)
+}
+
+// Encode writes the snapshot to w in the InfluxDB line protocol, with a line per counter, gauge, meter, duration and
+// sample. Counters and gauges have a float value field, so a counter and a gauge with the same key do not conflict in
+// InfluxDB, meters have the fields count, m1_rate, m5_rate, m15_rate and mean_rate, and durations and samples have
+// the fields count, min, max, mean and stddev, where count is an integer. The measurement and tags of a line are
+// determined by parse, which defaults to the key and tags of the series when nil. The timestamp of every line is the
+// time the snapshot was created, in nanoseconds. InfluxDB does not accept NaN and infinite values, so those fields
+// are left out, and so are lines without any field left.
+func Encode(w io.Writer, snapshot api.Snapshot, parse KeyParser) error {
+	if parse == nil {
+		series := snapshot.Series()
+		parse = func(seriesKey string) (string, api.Tags) {
+			return common.SplitSeriesKey(series, seriesKey)
+		}
+	}
+	timestamp := " " + strconv.FormatInt(snapshot.CreatedTimestamp()*1000000, 10)
+	var lines []string
+	for key, value := range snapshot.Counters() {
+		lines = appendLine(lines, parse, key, fields{}.intAsFloat("value", value), timestamp)
+	}
+	for key, value := range snapshot.Gauges() {
+		lines = appendLine(lines, parse, key, fields{}.float("value", value), timestamp)
+	}
+	for key, meter := range snapshot.Meters() {
+		meterFields := fields{}.int("count", meter.Count()).float("m1_rate", meter.Rate1()).float("m5_rate", meter.Rate5()).
+			float("m15_rate", meter.Rate15()).float("mean_rate", meter.RateMean())
+		lines = appendLine(lines, parse, key, meterFields, timestamp)
+	}
+	lines = appendDistributions(lines, parse, snapshot.Durations(), timestamp)
+	lines = appendDistributions(lines, parse, snapshot.Samples(), timestamp)
+	sort.Strings(lines)
+
+	writer := bufio.NewWriter(w)
+	for _, line := range lines {
+		writer.WriteString(line)
+		writer.WriteByte('\n')
+	}
+	return writer.Flush()
+}
+
+func appendDistributions(lines []string, parse KeyParser, distributions map[string]api.Distribution, timestamp string) []string {
+	for key, dist := range distributions {
+		distFields := fields{}.int("count", dist.SampleCount())
+		if dist.SampleCount() > 0 {
+			distFields = distFields.float("min", dist.Min()).float("max", dist.Max()).float("mean", dist.Avg()).
+				float("stddev", dist.StdDev())
+		}
+		lines = appendLine(lines, parse, key, distFields, timestamp)
+	}
+	return lines
+}
+
+// appendLine appends the line of a series, unless it has no fields
+func appendLine(lines []string, parse KeyParser, seriesKey string, values fields, timestamp string) []string {
+	if len(values) == 0 {
+		return lines
+	}
+	return append(lines, line(parse, seriesKey, strings.Join(values, ","))+timestamp)
+}
+
+// fields contains the name=value fields of a line
+type fields []string
+
+func (f fields) int(name string, value int64) fields {
+	return append(f, name+"="+strconv.FormatInt(value, 10)+"i")
+}
+
+// intAsFloat adds an integer as a float field, InfluxDB reads numbers without the i suffix as floats
+func (f fields) intAsFloat(name string, value int64) fields {
+	return append(f, name+"="+strconv.FormatInt(value, 10))
+}
+
+// float adds a float field, NaN and infinite values are left out because InfluxDB rejects them
+func (f fields) float(name string, value float64) fields {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return f
+	}
+	return append(f, name+"="+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// line returns the measurement, tags and fields of a line, the tags are sorted by name as InfluxDB recommends
+func line(parse KeyParser, seriesKey, fields string) string {
+	measurement, tags := parse(seriesKey)
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var line strings.Builder
+	line.WriteString(measurementEscaper.Replace(measurement))
+	for _, name := range names {
+		if tags[name] == "" {
+			continue // InfluxDB does not accept empty tag values
+		}
+		line.WriteByte(',')
+		line.WriteString(tagEscaper.Replace(name))
+		line.WriteByte('=')
+		line.WriteString(tagEscaper.Replace(tags[name]))
+	}
+	line.WriteByte(' ')
+	line.WriteString(fields)
+	return line.String()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
@@ -0,0 +1,109 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+package influx
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+func encode(t *testing.T, snapshot api.Snapshot, parse KeyParser) string {
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, snapshot, parse); err != nil {
+		t.Fatal("encoding failed", err)
+	}
+	return buffer.String()
+}
+
+func TestEncodeFieldTypes(t *testing.T) {
+	dist := common.NewDistribution()
+	dist.AddSample(10)
+	dist.AddSample(20)
+	snapshot := &common.Snapshot{
+		TimestampCreated:  2,
+		CountersSnapshot:  map[string]int64{"jobs": 3, "big": 1<<53 + 1},
+		GaugesSnapshot:    map[string]float64{"jobs": 1.5},
+		DurationsSnapshot: map[string]api.Distribution{"db.query": dist},
+		SamplesSnapshot:   map[string]api.Distribution{"db.empty": common.NewDistribution()},
+		MetersSnapshot:    map[string]api.Meter{"logins": &common.MeterSnapshot{Events: 4, M1: 0.5, M5: 0.25, M15: 0.125, Mean: 1}},
+	}
+	// the value of counters and gauges is always a float, so a counter and a gauge with the same key can share a
+	// measurement, the counts of meters and distributions are always integers
+	expected := `big value=9007199254740993 2000000
+db.empty count=0i 2000000
+db.query count=2i,min=10,max=20,mean=15,stddev=7.0710678118654755 2000000
+jobs value=1.5 2000000
+jobs value=3 2000000
+logins count=4i,m1_rate=0.5,m5_rate=0.25,m15_rate=0.125,mean_rate=1 2000000
+`
+	if actual := encode(t, snapshot, nil); actual != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, actual)
+	}
+}
+
+func TestEncodeEscapesMeasurementsAndTags(t *testing.T) {
+	tags := api.Tags{"host name": "a b", "route": "/a=b,c", "empty": ""}
+	seriesKey := common.SeriesKey("http requests,total", tags)
+	snapshot := &common.Snapshot{
+		TimestampCreated: 2,
+		CountersSnapshot: map[string]int64{seriesKey: 1, "pool{x=1} size": 2},
+		GaugesSnapshot:   map[string]float64{"cache=hit ratio": 0.5},
+		SeriesSnapshot:   map[string]api.Series{seriesKey: {Key: "http requests,total", Tags: tags}},
+	}
+	// measurements escape commas and spaces, tag names and values escape equal signs as well, empty tag values are
+	// left out and plain keys are never read as tags
+	expected := `cache=hit\ ratio value=0.5 2000000
+http\ requests\,total,host\ name=a\ b,route=/a\=b\,c value=1 2000000
+pool{x=1}\ size value=2 2000000
+`
+	if actual := encode(t, snapshot, nil); actual != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, actual)
+	}
+}
+
+func TestEncodeWithKeyParser(t *testing.T) {
+	snapshot := &common.Snapshot{TimestampCreated: 2, CountersSnapshot: map[string]int64{"http.GET.200": 1}}
+	parse := func(key string) (string, api.Tags) {
+		return "http", api.Tags{"route": "/a b,c=d", "empty": ""}
+	}
+	if expected, actual := "http,route=/a\\ b\\,c\\=d value=1 2000000\n", encode(t, snapshot, parse); actual != expected {
+		t.Errorf("expected %q but got %q", expected, actual)
+	}
+}
+
+func TestEncodeLeavesOutNonFiniteFields(t *testing.T) {
+	infinite := common.NewDistribution()
+	infinite.AddSample(math.Inf(1))
+	snapshot := &common.Snapshot{
+		TimestampCreated: 2,
+		GaugesSnapshot:   map[string]float64{"nan": math.NaN(), "inf": math.Inf(-1), "finite": 1},
+		MetersSnapshot:   map[string]api.Meter{"logins": &common.MeterSnapshot{Events: 4, M1: math.Inf(1), Mean: 1}},
+		SamplesSnapshot:  map[string]api.Distribution{"infinite": infinite},
+	}
+	expected := `finite value=1 2000000
+infinite count=1i,min=1.7976931348623157e+308,stddev=0 2000000
+logins count=4i,m5_rate=0,m15_rate=0,mean_rate=1 2000000
+`
+	if actual := encode(t, snapshot, nil); actual != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, actual)
+	}
+}
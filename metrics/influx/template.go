@@ -0,0 +1,130 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package influx
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// template maps the dot separated parts of a key onto the measurement and tags, for keys that match its filter
+type template struct {
+	filter []string
+	parts  []string
+}
+
+// NewTemplateKeyParser returns a KeyParser that splits keys on dots and maps the parts onto the measurement and tags
+// with the first matching template, in the same way as the Graphite templates of InfluxDB. A template names every
+// part of a key: measurement adds the part to the measurement, measurement* adds the part and all parts after it, an
+// empty name drops the part and any other name makes the part the value of the tag with that name. Parts after the
+// end of the template are added to the measurement. For example, measurement.measurement.method.status parses
+// http.requests.GET.200 into the measurement http.requests with the tags method=GET and status=200.
+//
+// A template can be preceded by a filter and a space, so it only applies to the keys that match the filter part by
+// part, where a * matches any part, for example "http.* measurement.method". Keys that match no template are parsed
+// with DefaultKeyParser. The tags of tagged series are kept.
+func NewTemplateKeyParser(templates ...string) (KeyParser, error) {
+	parsed := make([]template, 0, len(templates))
+	for _, text := range templates {
+		fields := strings.Fields(text)
+		var parsedTemplate template
+		switch len(fields) {
+		case 1:
+			parsedTemplate.parts = strings.Split(fields[0], ".")
+		case 2:
+			parsedTemplate.filter = strings.Split(fields[0], ".")
+			parsedTemplate.parts = strings.Split(fields[1], ".")
+		default:
+			return nil, errors.New("influx: a template must be a template or a filter and a template, got " + text)
+		}
+		if err := validate(parsedTemplate.parts); err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, parsedTemplate)
+	}
+	return func(seriesKey string) (string, api.Tags) {
+		key, tags := common.ParseSeriesKey(seriesKey)
+		parts := strings.Split(key, ".")
+		for _, template := range parsed {
+			if template.matches(parts) {
+				return template.apply(parts, tags)
+			}
+		}
+		return key, tags
+	}, nil
+}
+
+func validate(parts []string) error {
+	hasMeasurement := false
+	for i, part := range parts {
+		if part == "measurement*" && i != len(parts)-1 {
+			return errors.New("influx: measurement* must be the last part of a template")
+		}
+		if part == "measurement" || part == "measurement*" {
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return errors.New("influx: a template needs a measurement, got " + strings.Join(parts, "."))
+	}
+	return nil
+}
+
+func (template template) matches(parts []string) bool {
+	if template.filter == nil {
+		return true
+	}
+	if len(parts) < len(template.filter) {
+		return false
+	}
+	for i, filter := range template.filter {
+		if matched, _ := path.Match(filter, parts[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (template template) apply(parts []string, seriesTags api.Tags) (string, api.Tags) {
+	var measurement []string
+	tags := make(api.Tags, len(seriesTags)+len(template.parts))
+	for name, value := range seriesTags {
+		tags[name] = value
+	}
+	for i, part := range parts {
+		if i >= len(template.parts) {
+			measurement = append(measurement, part)
+			continue
+		}
+		switch name := template.parts[i]; name {
+		case "measurement":
+			measurement = append(measurement, part)
+		case "measurement*":
+			return strings.Join(append(measurement, parts[i:]...), "."), tags
+		case "":
+		default:
+			tags[name] = part
+		}
+	}
+	return strings.Join(measurement, "."), tags
+}
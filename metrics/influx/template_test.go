@@ -0,0 +1,70 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package influx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+func TestTemplateKeyParser(t *testing.T) {
+	parse, err := NewTemplateKeyParser(
+		"http.* measurement.method.status",
+		"db.*.* measurement..table.measurement*",
+		"measurement.host")
+	if err != nil {
+		t.Fatal("the templates should be valid but got", err)
+	}
+	tests := []struct {
+		key         string
+		measurement string
+		tags        api.Tags
+	}{
+		{"http.GET.200", "http", api.Tags{"method": "GET", "status": "200"}},
+		{"http.GET.200.extra", "http.extra", api.Tags{"method": "GET", "status": "200"}},
+		{"http.GET{region=eu}", "http", api.Tags{"method": "GET", "region": "eu"}},
+		{"db.users.songs.query.duration", "db.query.duration", api.Tags{"table": "songs"}},
+		{"cpu.server1", "cpu", api.Tags{"host": "server1"}},
+		{"cpu", "cpu", api.Tags{}},
+	}
+	for _, test := range tests {
+		measurement, tags := parse(test.key)
+		if measurement != test.measurement || !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("expected %v to be parsed into %v %v but got %v %v", test.key, test.measurement, test.tags, measurement, tags)
+		}
+	}
+}
+
+func TestTemplateKeyParserWithoutMatch(t *testing.T) {
+	parse, _ := NewTemplateKeyParser("http.* measurement.method")
+	measurement, tags := parse("db.query{table=songs}")
+	if measurement != "db.query" || tags["table"] != "songs" {
+		t.Error("keys without a matching template should be parsed with the DefaultKeyParser, got", measurement, tags)
+	}
+}
+
+func TestTemplateKeyParserInvalidTemplates(t *testing.T) {
+	for _, template := range []string{"method.status", "measurement*.method", "a b c", "http.* method"} {
+		if _, err := NewTemplateKeyParser(template); err == nil {
+			t.Error("expected an error for the template", template)
+		}
+	}
+}
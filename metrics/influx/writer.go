@@ -0,0 +1,120 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// DefaultTimeout is the timeout of a write when Config.Client is nil
+const DefaultTimeout = 10 * time.Second
+
+// Config configures a Writer
+type Config struct {
+	// URL is the base URL of InfluxDB, for example http://localhost:8086
+	URL string
+	// Database is the database to write to
+	Database string
+	// RetentionPolicy is the retention policy to write to, InfluxDB uses the default retention policy when empty
+	RetentionPolicy string
+	// Username and Password are sent with basic authentication when Username is not empty
+	Username string
+	Password string
+	// Token is sent in the Authorization header when not empty, as used by the /write compatibility endpoint of
+	// InfluxDB 2
+	Token string
+	// KeyParser determines the measurement and tags of the keys, defaults to DefaultKeyParser
+	KeyParser KeyParser
+	// Client sends the requests, defaults to a client with DefaultTimeout
+	Client *http.Client
+}
+
+// Writer writes snapshots to the /write endpoint of InfluxDB, see Encode. Writer implements reporter.Sink.
+type Writer struct {
+	writeURL string
+	username string
+	password string
+	token    string
+	parse    KeyParser
+	client   *http.Client
+}
+
+// NewWriter creates a Writer. Panics if the URL or database is empty.
+func NewWriter(config Config) *Writer {
+	if config.URL == "" || config.Database == "" {
+		panic("the URL and database of InfluxDB are required")
+	}
+	if config.KeyParser == nil {
+		config.KeyParser = DefaultKeyParser
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	query := url.Values{"db": {config.Database}, "precision": {"ns"}}
+	if config.RetentionPolicy != "" {
+		query.Set("rp", config.RetentionPolicy)
+	}
+	return &Writer{
+		writeURL: strings.TrimSuffix(config.URL, "/") + "/write?" + query.Encode(),
+		username: config.Username,
+		password: config.Password,
+		token:    config.Token,
+		parse:    config.KeyParser,
+		client:   config.Client,
+	}
+}
+
+// Report writes the snapshot to InfluxDB. Returns an error when the request fails or InfluxDB does not respond with
+// a 2xx status.
+func (writer *Writer) Report(snapshot api.Snapshot) error {
+	var body bytes.Buffer
+	if err := Encode(&body, snapshot, writer.parse); err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, writer.writeURL, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if writer.username != "" {
+		request.SetBasicAuth(writer.username, writer.password)
+	}
+	if writer.token != "" {
+		request.Header.Set("Authorization", "Token "+writer.token)
+	}
+	response, err := writer.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("influx: writing failed with status %v: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	io.Copy(io.Discard, response.Body)
+	return nil
+}
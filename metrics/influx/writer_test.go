@@ -0,0 +1,102 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package influx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/reporter"
+)
+
+func TestWriterImplementsSink(t *testing.T) {
+	var _ reporter.Sink = NewWriter(Config{URL: "http://localhost:8086", Database: "metrics"})
+}
+
+func TestWriterWrites(t *testing.T) {
+	var request *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := NewWriter(Config{URL: server.URL + "/", Database: "metrics", RetentionPolicy: "week", Username: "user", Password: "secret"})
+	snapshot := &common.Snapshot{TimestampCreated: 2, CountersSnapshot: map[string]int64{"requests": 1}}
+	if err := writer.Report(snapshot); err != nil {
+		t.Fatal("writing failed", err)
+	}
+	if request.Method != http.MethodPost || request.URL.Path != "/write" {
+		t.Error("expected a POST to /write but got", request.Method, request.URL.Path)
+	}
+	query := request.URL.Query()
+	if query.Get("db") != "metrics" || query.Get("rp") != "week" || query.Get("precision") != "ns" {
+		t.Error("unexpected query", request.URL.RawQuery)
+	}
+	if username, password, ok := request.BasicAuth(); !ok || username != "user" || password != "secret" {
+		t.Error("expected basic authentication with the username and password")
+	}
+	if body != "requests value=1 2000000\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestWriterToken(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	NewWriter(Config{URL: server.URL, Database: "metrics", Token: "abc"}).Report(&common.Snapshot{})
+	if authorization != "Token abc" {
+		t.Error("expected the token in the Authorization header but got", authorization)
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found: \"metrics\""}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+	err := NewWriter(Config{URL: server.URL, Database: "metrics"}).Report(&common.Snapshot{})
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "database not found") {
+		t.Error("expected an error with the status and the message of InfluxDB but got", err)
+	}
+	server.Close()
+	if err := NewWriter(Config{URL: server.URL, Database: "metrics"}).Report(&common.Snapshot{}); err == nil {
+		t.Error("expected an error when InfluxDB is unavailable")
+	}
+}
+
+func TestNewWriterWithoutDatabase(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewWriter should panic without a database, but no panic")
+		}
+	}()
+	NewWriter(Config{URL: "http://localhost:8086"})
+}
@@ -19,13 +19,12 @@
 package lockbased
 
 import (
-	"github.com/toefel18/go-patan/metrics/api"
 	"github.com/toefel18/go-patan/metrics/common"
 )
 
-// Facade provides a nice API on the lockbased store
+// Facade provides a nice API on the lockbased store or sharded store, see common.Facade
 type Facade struct {
-	store *Store
+	*common.Facade
 }
 
 // NewFacade creates a new facade initialized with the store
@@ -33,78 +32,13 @@ func NewFacade(store *Store) *Facade {
 	if store == nil {
 		panic("store = nil, Facade needs a store")
 	}
-	return &Facade{store}
+	return &Facade{common.NewFacade(store)}
 }
 
-// StartStopwatch starts a new stopwatch
-func (facade *Facade) StartStopwatch() api.Stopwatch {
-	return common.StartNewStopwatch()
-}
-
-// RecordElapsedTime records the elapsed time of the stopwatch under the distribution identified with key
-func (facade *Facade) RecordElapsedTime(key string, stopwatch api.Stopwatch) float64 {
-	millis := stopwatch.ElapsedMillis()
-	facade.store.addDuration(key, millis)
-	return millis
-}
-
-// MeasureFunc runs the subject function and records it's execution duration under the distribution identified with key
-// if the function panics, no measurement is recorded! Use MeasureFuncCanPanic to get a function that can panic
-func (facade *Facade) MeasureFunc(key string, subject func()) float64 {
-	sw := common.StartNewStopwatch()
-	subject()
-	return facade.RecordElapsedTime(key, sw)
-}
-
-// MeasureFuncCanPanic runs the subject function and records it's execution duration under the distribution identified
-// with key. When subject() panics, the measurement is recorded under the same key with .panic appended. This function
-// itself will panic with the same error as the inner function.
-func (facade *Facade) MeasureFuncCanPanic(key string, subject func()) float64 {
-	sw := common.StartNewStopwatch()
-	defer func() {
-		if err := recover(); err != nil {
-			facade.RecordElapsedTime(key+".panic", sw)
-			panic(err)
-		}
-	}()
-	subject()
-	return facade.RecordElapsedTime(key, sw)
-}
-
-// IncrementCounter increments the counter identified by key by 1
-func (facade *Facade) IncrementCounter(key string) {
-	facade.AddToCounter(key, 1)
-}
-
-// DecrementCounter decrements the counter identified by key by 1
-func (facade *Facade) DecrementCounter(key string) {
-	facade.AddToCounter(key, -1)
-}
-
-// AddToCounter adds value to the counter identified by key, value can be negative
-func (facade *Facade) AddToCounter(key string, value int64) {
-	facade.store.addToCounter(key, value)
-}
-
-// AddSample adds a sample to the distribution identified by value, if the distribution doesn't
-// exist, it will be created
-func (facade *Facade) AddSample(key string, value float64) {
-	facade.store.addSample(key, value)
-}
-
-// Reset clears the store
-func (facade *Facade) Reset() {
-	facade.store.Reset()
-}
-
-// Snapshot returns a snapshot of all the counters, durations and samples recorded
-// since creation or the last reset.
-func (facade *Facade) Snapshot() api.Snapshot {
-	return facade.store.Snapshot()
-}
-
-// SnapshotAndReset returns a snapshot of all the counters, durations and samples recorded
-// since creation or the last reset, and then clears the internal state
-func (facade *Facade) SnapshotAndReset() api.Snapshot {
-	return facade.store.SnapshotAndReset()
+// NewShardedFacade creates a new facade initialized with the sharded store
+func NewShardedFacade(store *ShardedStore) *Facade {
+	if store == nil {
+		panic("store = nil, Facade needs a store")
+	}
+	return &Facade{common.NewFacade(store)}
 }
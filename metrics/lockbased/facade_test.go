@@ -53,6 +53,18 @@ func TestFacadeImplementsApiInterface(t *testing.T) {
 	}
 }
 
+func TestFacadeBehaviour(t *testing.T) {
+	commontest.RunFacadeTests(t, func() api.Facade {
+		return NewFacade(NewStore())
+	})
+}
+
+func TestShardedFacadeBehaviour(t *testing.T) {
+	commontest.RunFacadeTests(t, func() api.Facade {
+		return NewShardedFacade(NewShardedStore(4))
+	})
+}
+
 func TestFacadeHappyFlow(t *testing.T) {
 	facade := NewFacade(NewStore())
 
@@ -174,3 +186,61 @@ func assertDurationWithin(dist api.Distribution, sampleCount int64, min, max, av
 	}
 	// variance and stddev are tested elsewere
 }
+
+func TestFacadeWithTags(t *testing.T) {
+	facade := NewFacade(NewStore())
+	getTags := api.Tags{"method": "GET", "status": "200"}
+	facade.IncrementCounterWithTags("http.requests", getTags)
+	facade.AddToCounterWithTags("http.requests", api.Tags{"status": "200", "method": "GET"}, 2)
+	facade.DecrementCounterWithTags("http.requests", api.Tags{"method": "POST"})
+	facade.IncrementCounter("http.requests")
+	facade.AddSampleWithTags("response.size", getTags, 10)
+	facade.RecordElapsedTimeWithTags("http.duration", getTags, facade.StartStopwatch())
+	facade.MeasureFuncWithTags("http.duration", getTags, func() {})
+	facade.AddSampleWithTags("untagged", nil, 5)
+
+	snapshot := facade.Snapshot()
+	assertCounter(snapshot, "http.requests{method=GET,status=200}", 3, t)
+	assertCounter(snapshot, "http.requests{method=POST}", -1, t)
+	assertCounter(snapshot, "http.requests", 1, t)
+	commontest.AssertDistributionHasValues(snapshot.Samples()["response.size{method=GET,status=200}"], 1, 10, 10, 10, 0, t)
+	commontest.AssertDistributionHasValues(snapshot.Samples()["untagged"], 1, 5, 5, 5, 0, t)
+	if snapshot.Durations()["http.duration{method=GET,status=200}"].SampleCount() != 2 {
+		t.Error("the tagged duration should contain 2 samples")
+	}
+	series := snapshot.Series()["http.requests{method=POST}"]
+	if series.Key != "http.requests" || series.Tags["method"] != "POST" {
+		t.Errorf("the snapshot should expose the tags of the series, got %v", series)
+	}
+}
+
+func TestFacadeGauges(t *testing.T) {
+	facade := NewFacade(NewStore())
+	facade.SetGauge("queue.depth", 12)
+	facade.RegisterGaugeFunc("pool.size", func() float64 { return 4 })
+	facade.SetGauge("pool.size", 1)
+	snapshot := facade.SnapshotAndReset()
+	if snapshot.Gauges()["queue.depth"] != 12 {
+		t.Error("expected gauge queue.depth to be 12 but got", snapshot.Gauges()["queue.depth"])
+	}
+	if snapshot.Gauges()["pool.size"] != 4 {
+		t.Error("a gauge func should take precedence over a set value but got", snapshot.Gauges()["pool.size"])
+	}
+	if len(snapshot.Counters()) != 0 {
+		t.Error("gauges should not be counters")
+	}
+}
+
+func TestFacadeMeters(t *testing.T) {
+	facade := NewFacade(NewStore())
+	facade.MarkMeter("requests", 1)
+	facade.MarkMeter("requests", 4)
+	snapshot := facade.Snapshot()
+	meter, exists := snapshot.Meters()["requests"]
+	if !exists || meter.Count() != 5 {
+		t.Error("expected meter requests with 5 events but got", meter)
+	}
+	if len(snapshot.Counters()) != 0 {
+		t.Error("meters should not be counters")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package lockbased
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// ShardedStore is a common.Backend that spreads the keys over a number of independently locked stores, so concurrent
+// writers of different keys rarely wait for each other. The shard of a key is picked by its hash, so every key lives
+// in exactly one shard and snapshots only have to combine the shards, see common.MergeSnapshots. A single hot key is
+// not spread, use a handle such as Counter for those. Gauges and meters are kept in the first shard. Counter handles
+// are kept in the first shard as well, they are updated without a lock.
+type ShardedStore struct {
+	shards []*Store
+}
+
+// NewShardedStore creates a new sharded store with the given number of shards that uses
+// common.DefaultDistributionFactory for its durations and samples. Use runtime.GOMAXPROCS(0) shards when in doubt,
+// see NewDefaultShardedStore.
+func NewShardedStore(shards int) *ShardedStore {
+	return NewShardedStoreWithDistributions(shards, common.DefaultDistributionFactory, common.DefaultDistributionFactory)
+}
+
+// NewDefaultShardedStore creates a new sharded store with a shard for every processor that can execute go code
+// simultaneously
+func NewDefaultShardedStore() *ShardedStore {
+	return NewShardedStore(runtime.GOMAXPROCS(0))
+}
+
+// NewShardedStoreWithDistributions creates a new sharded store that creates the distributions of new durations and
+// samples with the given factories, see NewStoreWithDistributions. The distributions must be mergeable by
+// common.MergeSnapshots.
+func NewShardedStoreWithDistributions(shards int, newDuration, newSample common.DistributionFactory) *ShardedStore {
+	if shards < 1 {
+		panic(fmt.Sprint("a sharded store needs at least 1 shard but got ", shards))
+	}
+	if newDuration == nil || newSample == nil {
+		panic("distribution factories cannot be nil")
+	}
+	store := &ShardedStore{shards: make([]*Store, shards)}
+	for i := range store.shards {
+		store.shards[i] = newStore(newDuration, newSample)
+	}
+	log.Println("[METRICS] created new sharded lockbased store with", shards, "shards")
+	return store
+}
+
+// shard returns the shard of key, which is picked with the 32 bit FNV-1a hash of the key
+func (store *ShardedStore) shard(key string) *Store {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return store.shards[hash%uint32(len(store.shards))]
+}
+
+// AddSample adds value to the sample distribution identified by key, in the shard of key
+func (store *ShardedStore) AddSample(key string, value float64) {
+	store.shard(key).AddSample(key, value)
+}
+
+// AddDuration adds value to the duration distribution identified by key, in the shard of key
+func (store *ShardedStore) AddDuration(key string, value float64) {
+	store.shard(key).AddDuration(key, value)
+}
+
+// AddToCounter adds value to the counter identified by key, in the shard of key
+func (store *ShardedStore) AddToCounter(key string, value int64) {
+	store.shard(key).AddToCounter(key, value)
+}
+
+// CounterCell returns the cell of the counter identified by key, in the first shard
+func (store *ShardedStore) CounterCell(key string) *common.AtomicCounter {
+	return store.shards[0].CounterCell(key)
+}
+
+// DurationHandle returns a function that records into the duration distribution identified by key, in the shard of
+// key
+func (store *ShardedStore) DurationHandle(key string) func(value float64) {
+	return store.shard(key).DurationHandle(key)
+}
+
+// SampleHandle returns a function that records into the sample distribution identified by key, in the shard of key
+func (store *ShardedStore) SampleHandle(key string) func(value float64) {
+	return store.shard(key).SampleHandle(key)
+}
+
+// SetGauge sets the gauge identified by key to value, in the first shard
+func (store *ShardedStore) SetGauge(key string, value float64) {
+	store.shards[0].SetGauge(key, value)
+}
+
+// RegisterGaugeFunc registers a function that determines the value of the gauge identified by key on every
+// snapshot, in the first shard
+func (store *ShardedStore) RegisterGaugeFunc(key string, gauge func() float64) {
+	store.shards[0].RegisterGaugeFunc(key, gauge)
+}
+
+// MarkMeter marks n events on the meter identified by key, in the first shard
+func (store *ShardedStore) MarkMeter(key string, n int64) {
+	store.shards[0].MarkMeter(key, n)
+}
+
+// Snapshot creates a new snapshot of the current state of all shards
+func (store *ShardedStore) Snapshot() api.Snapshot {
+	snapshots := make([]api.Snapshot, len(store.shards))
+	for i, shard := range store.shards {
+		snapshots[i] = shard.Snapshot()
+	}
+	return common.MergeSnapshots(snapshots...)
+}
+
+// SnapshotAndReset creates a snapshot and clears the recorded counters, durations and samples, gauges and meters
+// are kept. The shards are reset one after the other, every recording ends up in exactly one snapshot.
+func (store *ShardedStore) SnapshotAndReset() api.Snapshot {
+	snapshots := make([]api.Snapshot, len(store.shards))
+	for i, shard := range store.shards {
+		snapshots[i] = shard.SnapshotAndReset()
+	}
+	return common.MergeSnapshots(snapshots...)
+}
+
+// Reset clears the recorded counters, durations and samples of all shards, gauges and meters are kept
+func (store *ShardedStore) Reset() {
+	for _, shard := range store.shards {
+		shard.Reset()
+	}
+}
@@ -0,0 +1,227 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package lockbased
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/common/commontest"
+)
+
+func TestShardedStoreMergesShards(t *testing.T) {
+	store := NewShardedStore(4)
+	for i := 1; i <= 10; i++ {
+		store.AddSample("sample", float64(i))
+		store.AddDuration("duration", 5)
+		store.AddToCounter("counter", 2)
+	}
+	snapshot := store.Snapshot()
+	commontest.AssertDistributionHasValues(snapshot.Samples()["sample"], 10, 1, 10, 5.5, 3.0276, t)
+	commontest.AssertDistributionHasValues(snapshot.Durations()["duration"], 10, 5, 5, 5, 0, t)
+	assertCounter(snapshot, "counter", 20, t)
+	if percentile := snapshot.Samples()["sample"].Percentile(0.9); percentile < 8.9 || percentile > 9.1 {
+		t.Error("expected the merged p90 to be 9 but got", percentile)
+	}
+}
+
+func TestShardedStoreKeepsEveryKeyInOneShard(t *testing.T) {
+	store := NewShardedStore(4)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key.", i)
+		store.AddToCounter(key, 1)
+		store.AddSample(key, 1)
+		store.DurationHandle(key)(1)
+	}
+	used := 0
+	seen := make(map[string]int)
+	for _, shard := range store.shards {
+		snapshot := shard.Snapshot()
+		if len(snapshot.Counters()) > 0 {
+			used++
+		}
+		for key := range snapshot.Counters() {
+			seen[key]++
+			if snapshot.Samples()[key] == nil || snapshot.Durations()[key] == nil {
+				t.Error("the sample and duration of a key should be in the shard of its counter", key)
+			}
+		}
+	}
+	if used != 4 {
+		t.Error("expected the keys to be spread over all 4 shards but used", used)
+	}
+	for key, shards := range seen {
+		if shards != 1 {
+			t.Errorf("expected %v in one shard but it is in %v", key, shards)
+		}
+	}
+}
+
+func TestShardedStoreReset(t *testing.T) {
+	store := NewShardedStore(3)
+	store.AddToCounter("counter", 1)
+	store.AddToCounter("counter", 1)
+	store.SetGauge("gauge", 4)
+	store.MarkMeter("meter", 7)
+	snapshot := store.SnapshotAndReset()
+	assertCounter(snapshot, "counter", 2, t)
+	snapshot = store.Snapshot()
+	if len(snapshot.Counters()) != 0 {
+		t.Error("after SnapshotAndReset the counters should be cleared but got", snapshot.Counters())
+	}
+	if snapshot.Gauges()["gauge"] != 4 || snapshot.Meters()["meter"].Count() != 7 {
+		t.Error("gauges and meters should survive a reset")
+	}
+	store.AddSample("sample", 1)
+	store.Reset()
+	if len(store.Snapshot().Samples()) != 0 {
+		t.Error("after Reset the samples should be cleared")
+	}
+}
+
+func TestShardedStoreGaugesAreNotSummed(t *testing.T) {
+	store := NewShardedStore(4)
+	for i := 0; i < 8; i++ {
+		store.SetGauge("queue.depth", float64(i))
+	}
+	if value := store.Snapshot().Gauges()["queue.depth"]; value != 7 {
+		t.Error("expected the last gauge value 7 but got", value)
+	}
+}
+
+func TestShardedStoreConcurrency(t *testing.T) {
+	facade := NewShardedFacade(NewShardedStore(8))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				facade.IncrementCounter("concurrency.counter")
+				facade.AddSample("concurrency.sample", float64(j))
+			}
+		}()
+	}
+	var snapshots []api.Snapshot
+	for i := 0; i < 10; i++ {
+		snapshots = append(snapshots, facade.SnapshotAndReset())
+	}
+	wg.Wait()
+	snapshots = append(snapshots, facade.SnapshotAndReset())
+	total := common.MergeSnapshots(snapshots...)
+	assertCounter(total, "concurrency.counter", 50000, t)
+	if count := total.Samples()["concurrency.sample"].SampleCount(); count != 50000 {
+		t.Error("expected 50000 samples but got", count)
+	}
+}
+
+func TestNewShardedStoreWithoutShards(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewShardedStore should panic when shards < 1, but no panic")
+		}
+	}()
+	NewShardedStore(0)
+}
+
+func TestNewShardedFacadeWithNilStore(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewShardedFacade should panic when store=nil, but no panic")
+		}
+	}()
+	NewShardedFacade(nil)
+}
+
+func BenchmarkParallelIncrementCounter(b *testing.B) {
+	benchmarkStores(b, func(facade *Facade, key int) {
+		facade.IncrementCounter(counterKeys[key])
+	})
+}
+
+func BenchmarkCounterHandleParallelInc(b *testing.B) {
+	facade := NewFacade(NewStore())
+	counters := make([]api.Counter, benchmarkKeys)
+	for key := range counters {
+		counters[key] = facade.Counter(counterKeys[key])
+	}
+	benchmarkParallel(b, facade, func(facade *Facade, key int) {
+		counters[key].Inc()
+	})
+}
+
+func BenchmarkParallelAddSample(b *testing.B) {
+	benchmarkStores(b, func(facade *Facade, key int) {
+		facade.AddSample(sampleKeys[key], 333.333)
+	})
+}
+
+func BenchmarkParallelAll(b *testing.B) {
+	benchmarkStores(b, func(facade *Facade, key int) {
+		facade.MeasureFunc(funcKeys[key], func() {
+			facade.DecrementCounter(counterKeys[key])
+			facade.AddSample(sampleKeys[key], 333.333)
+			facade.IncrementCounter(counterKeys[key])
+		})
+	})
+}
+
+// benchmarkKeys is the number of distinct keys per kind of metric that the parallel benchmarks spread their work over
+const benchmarkKeys = 64
+
+var (
+	counterKeys = benchmarkKeyNames("some.counter")
+	sampleKeys  = benchmarkKeyNames("some.sample")
+	funcKeys    = benchmarkKeyNames("some.func")
+)
+
+func benchmarkKeyNames(prefix string) []string {
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%v.%v", prefix, i)
+	}
+	return keys
+}
+
+// benchmarkStores runs the same workload on the lockbased store and on the sharded store
+func benchmarkStores(b *testing.B, record func(facade *Facade, key int)) {
+	b.Run("Store", func(b *testing.B) {
+		benchmarkParallel(b, NewFacade(NewStore()), record)
+	})
+	b.Run("ShardedStore", func(b *testing.B) {
+		benchmarkParallel(b, NewShardedFacade(NewDefaultShardedStore()), record)
+	})
+}
+
+// benchmarkParallel calls record from parallel goroutines, every goroutine rotates over the keys starting at its own
+// offset, so concurrent recordings mostly hit distinct keys
+func benchmarkParallel(b *testing.B, facade *Facade, record func(facade *Facade, key int)) {
+	var goroutines int32
+	b.RunParallel(func(pb *testing.PB) {
+		key := int(atomic.AddInt32(&goroutines, 1)) * 7
+		for pb.Next() {
+			key = (key + 1) % benchmarkKeys
+			record(facade, key)
+		}
+	})
+}
@@ -26,112 +26,127 @@ import (
 	"github.com/toefel18/go-patan/metrics/common"
 )
 
-//Store holds the state of the lockbased implementation
+//Store holds the state of the lockbased implementation. Counters, durations and samples are identified by their
+//series key, which combines the key with its tags, see common.SeriesKey. Store is the common.Backend of a Facade.
 type Store struct {
-	timestampStarted int64
-
-	durations map[string]*common.Distribution
-	counters  map[string]int64
-	samples   map[string]*common.Distribution
-
-	lock sync.Mutex
+	state *common.State
+	lock  sync.Mutex
 }
 
-// NewStore creates a new store and starts a go-routine that listens for requests on the channels.
+// NewStore creates a new store that uses common.DefaultDistributionFactory for its durations and samples.
 func NewStore() *Store {
-	store := &Store{
-		timestampStarted: common.CurrentTimeMillis(),
-		durations:        make(map[string]*common.Distribution),
-		counters:         make(map[string]int64),
-		samples:          make(map[string]*common.Distribution),
-	}
+	return NewStoreWithDistributions(common.DefaultDistributionFactory, common.DefaultDistributionFactory)
+}
+
+// NewStoreWithDistributions creates a new store that creates the distributions of new durations and samples with
+// the given factories. For example, to keep track of durations with a HDR histogram:
+// NewStoreWithDistributions(common.HdrDistributionFactory(0.001, 3600000, 3), common.DefaultDistributionFactory)
+func NewStoreWithDistributions(newDuration, newSample common.DistributionFactory) *Store {
+	store := newStore(newDuration, newSample)
 	log.Println("[METRICS] created new lockbased store")
 	return store
 }
 
-func (store *Store) addSample(key string, value float64) {
-	store.addToStore(store.samples, key, value)
+// newStore creates a new store without logging, so a ShardedStore can log once for all its shards
+func newStore(newDuration, newSample common.DistributionFactory) *Store {
+	return &Store{state: common.NewState(newDuration, newSample)}
 }
 
-func (store *Store) addDuration(key string, value float64) {
-	store.addToStore(store.durations, key, value)
+// DurationHandle returns a function that records into the duration distribution identified by key
+func (store *Store) DurationHandle(key string) func(value float64) {
+	handle := common.NewDurationHandle(key)
+	return func(value float64) {
+		store.recordTo(handle, value)
+	}
 }
 
-func (store *Store) addToCounter(key string, value int64) {
+// SampleHandle returns a function that records into the sample distribution identified by key
+func (store *Store) SampleHandle(key string) func(value float64) {
+	handle := common.NewSampleHandle(key)
+	return func(value float64) {
+		store.recordTo(handle, value)
+	}
+}
+
+func (store *Store) recordTo(handle *common.DistributionHandle, value float64) {
 	store.lock.Lock()
-	store.counters[key] = store.counters[key] + value
+	store.state.RecordTo(handle, value)
 	store.lock.Unlock()
 }
 
-func (store *Store) addToStore(destination map[string]*common.Distribution, key string, value float64) {
+// AddSample adds value to the sample distribution identified by key
+func (store *Store) AddSample(key string, value float64) {
 	store.lock.Lock()
-	distribution, exists := destination[key]
-	if !exists {
-		distribution = common.NewDistribution()
-		destination[key] = distribution
-	}
-	distribution.AddSample(value)
+	store.state.AddSample(key, value)
 	store.lock.Unlock()
 }
 
-//Snapshot creates a new snapshot of the current state
-func (store *Store) Snapshot() api.Snapshot {
+// AddDuration adds value to the duration distribution identified by key
+func (store *Store) AddDuration(key string, value float64) {
 	store.lock.Lock()
-	snapshot := store.doGetSnapshot()
+	store.state.AddDuration(key, value)
 	store.lock.Unlock()
-	return snapshot
 }
 
-func (store *Store) doGetSnapshot() api.Snapshot {
-	durationsCopy := deepCopy(store.durations)
-	countersCopy := shallowCopy(store.counters)
-	samplesCopy := deepCopy(store.samples)
+// AddToCounter adds value to the counter identified by key
+func (store *Store) AddToCounter(key string, value int64) {
+	store.lock.Lock()
+	store.state.AddToCounter(key, value)
+	store.lock.Unlock()
+}
 
-	return &common.Snapshot{
-		TimestampStarted:  store.timestampStarted,
-		TimestampCreated:  common.CurrentTimeMillis(),
-		DurationsSnapshot: durationsCopy,
-		CountersSnapshot:  countersCopy,
-		SamplesSnapshot:   samplesCopy,
-	}
+// CounterCell returns the cell of the counter identified by key, which can be updated without holding the lock
+func (store *Store) CounterCell(key string) *common.AtomicCounter {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.state.CounterCell(key)
 }
 
-// SnapshotAndReset creates a snapshot and clears the recorded counters, durations and samples
-func (store *Store) SnapshotAndReset() api.Snapshot {
+// SetGauge sets the gauge identified by key to value
+func (store *Store) SetGauge(key string, value float64) {
 	store.lock.Lock()
-	snapshot := store.doGetSnapshot()
-	store.doReset()
+	store.state.SetGauge(key, value)
 	store.lock.Unlock()
-	return snapshot
 }
 
-// Reset clears the recorded counters, durations and samples
-func (store *Store) Reset() {
+// RegisterGaugeFunc registers a function that determines the value of the gauge identified by key on every snapshot
+func (store *Store) RegisterGaugeFunc(key string, gauge func() float64) {
 	store.lock.Lock()
-	store.doReset()
+	store.state.RegisterGaugeFunc(key, gauge)
 	store.lock.Unlock()
 }
 
-func (store *Store) doReset() {
-	store.timestampStarted = common.CurrentTimeMillis()
-	store.durations = make(map[string]*common.Distribution)
-	store.counters = make(map[string]int64)
-	store.samples = make(map[string]*common.Distribution)
+// MarkMeter marks n events on the meter identified by key
+func (store *Store) MarkMeter(key string, n int64) {
+	store.lock.Lock()
+	store.state.MarkMeter(key, n)
+	store.lock.Unlock()
 }
 
-func deepCopy(source map[string]*common.Distribution) map[string]api.Distribution {
-	distMapCopy := make(map[string]api.Distribution)
-	for key, distribution := range source {
-		valueCopy := *distribution // dereference pointer to get a copy of the struct
-		distMapCopy[key] = &valueCopy
-	}
-	return distMapCopy
+//Snapshot creates a new snapshot of the current state
+func (store *Store) Snapshot() api.Snapshot {
+	store.lock.Lock()
+	snapshot := store.state.Snapshot()
+	gaugeFuncs := store.state.GaugeFuncs()
+	store.lock.Unlock()
+	// gauge functions are evaluated without holding the lock, so they can use the store themselves
+	common.EvaluateGaugeFuncs(snapshot, gaugeFuncs)
+	return snapshot
 }
 
-func shallowCopy(source map[string]int64) map[string]int64 {
-	intMapCopy := make(map[string]int64)
-	for key, counter := range source {
-		intMapCopy[key] = counter
-	}
-	return intMapCopy
+// SnapshotAndReset creates a snapshot and clears the recorded counters, durations and samples, gauges and meters are kept
+func (store *Store) SnapshotAndReset() api.Snapshot {
+	store.lock.Lock()
+	snapshot := store.state.SnapshotAndReset()
+	gaugeFuncs := store.state.GaugeFuncs()
+	store.lock.Unlock()
+	common.EvaluateGaugeFuncs(snapshot, gaugeFuncs)
+	return snapshot
+}
+
+// Reset clears the recorded counters, durations and samples, gauges and meters are kept
+func (store *Store) Reset() {
+	store.lock.Lock()
+	store.state.Reset()
+	store.lock.Unlock()
 }
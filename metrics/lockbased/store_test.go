@@ -85,8 +85,8 @@ func TestAddSampleUpdate(t *testing.T) {
 
 func TestAddCounter(t *testing.T) {
 	store := NewStore()
-	store.addToCounter("active-sessions", 10)
-	snapshot := store.doGetSnapshot()
+	store.AddToCounter("active-sessions", 10)
+	snapshot := store.Snapshot()
 	if snapshot.Counters()["active-sessions"] != 10 {
 		t.Error("Counter active-sessions should be 10 but was", snapshot.Counters()["active-sessions"])
 	}
@@ -94,14 +94,14 @@ func TestAddCounter(t *testing.T) {
 
 func TestStore_Reset(t *testing.T) {
 	store := NewStore()
-	store.addToCounter("active-sessions", 10)
-	store.addSample("sample", 123.0)
-	store.addDuration("duration", 1674)
-	snapshot := store.doGetSnapshot()
+	store.AddToCounter("active-sessions", 10)
+	store.AddSample("sample", 123.0)
+	store.AddDuration("duration", 1674)
+	snapshot := store.Snapshot()
 	time.Sleep(100 * time.Millisecond)
 	store.Reset()
 	expStartedTime := common.CurrentTimeMillis()
-	snapshotAfterReset := store.doGetSnapshot()
+	snapshotAfterReset := store.Snapshot()
 	if len(snapshotAfterReset.Counters()) > 0 || len(snapshotAfterReset.Durations()) > 0 || len(snapshotAfterReset.Samples()) > 0 {
 		t.Error("After a Reset() the store still contains items!")
 	}
@@ -140,11 +140,11 @@ func TestSnapshotsAreDisconnectedFromStore(t *testing.T) {
 
 func addSample(store *Store, durationOrSample int, key string, value float64, t *testing.T) (api.Snapshot, api.Distribution) {
 	if durationOrSample == Duration {
-		store.addDuration(key, value)
+		store.AddDuration(key, value)
 	} else {
-		store.addSample(key, value)
+		store.AddSample(key, value)
 	}
-	snapshot := store.doGetSnapshot()
+	snapshot := store.Snapshot()
 
 	// check that key is present
 	var dist api.Distribution
@@ -177,3 +177,89 @@ func assertSamplesEmpty(snapshot api.Snapshot, t *testing.T) {
 		t.Error("snapshot contains", len(snapshot.Samples()), "samples, expected none")
 	}
 }
+
+func TestStoreWithHdrDurations(t *testing.T) {
+	store := NewStoreWithDistributions(common.HdrDistributionFactory(0.001, 3600000, 3), common.DefaultDistributionFactory)
+	store.AddDuration("duration", 12.5)
+	store.AddSample("sample", 12.5)
+	snapshot := store.Snapshot()
+	if _, ok := snapshot.Durations()["duration"].(*common.HdrDistribution); !ok {
+		t.Errorf("durations should be HdrDistributions but got %T", snapshot.Durations()["duration"])
+	}
+	if _, ok := snapshot.Samples()["sample"].(*common.Distribution); !ok {
+		t.Errorf("samples should be Distributions but got %T", snapshot.Samples()["sample"])
+	}
+	commontest.AssertDistributionHasValues(snapshot.Durations()["duration"], 1, 12.5, 12.5, 12.5, 0, t)
+}
+
+func TestStoreWithWindowedSamples(t *testing.T) {
+	store := NewStoreWithDistributions(common.DefaultDistributionFactory, common.WindowedDistributionFactory(time.Minute, 6))
+	store.AddSample("sample", 10)
+	store.AddSample("sample", 20)
+	first := store.Snapshot()
+	second := store.Snapshot()
+	// windowed samples are not cleared by taking a snapshot, both consumers see the same window
+	commontest.AssertDistributionHasValues(first.Samples()["sample"], 2, 10, 20, 15, 7.0710, t)
+	commontest.AssertDistributionHasValues(second.Samples()["sample"], 2, 10, 20, 15, 7.0710, t)
+	if _, ok := second.Samples()["sample"].(*common.Distribution); !ok {
+		t.Errorf("windowed samples should be reported as Distributions but got %T", second.Samples()["sample"])
+	}
+}
+
+func TestNewStoreWithNilDistributions(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewStoreWithDistributions should panic when a factory is nil")
+		}
+	}()
+	NewStoreWithDistributions(nil, common.DefaultDistributionFactory)
+}
+
+func TestGaugesSurviveReset(t *testing.T) {
+	store := NewStore()
+	store.SetGauge("queue.depth", 5)
+	store.SetGauge("queue.depth", 3)
+	store.RegisterGaugeFunc("pool.size", func() float64 { return 8 })
+	snapshot := store.SnapshotAndReset()
+	if snapshot.Gauges()["queue.depth"] != 3 || snapshot.Gauges()["pool.size"] != 8 {
+		t.Errorf("expected the last gauge values but got %v", snapshot.Gauges())
+	}
+	store.Reset()
+	snapshot = store.Snapshot()
+	if snapshot.Gauges()["queue.depth"] != 3 || snapshot.Gauges()["pool.size"] != 8 {
+		t.Errorf("gauges should survive a reset but got %v", snapshot.Gauges())
+	}
+}
+
+func TestGaugeFuncsAreEvaluatedOnEverySnapshot(t *testing.T) {
+	store := NewStore()
+	calls := 0
+	store.RegisterGaugeFunc("calls", func() float64 {
+		calls++
+		// gauge functions are evaluated outside of the lock, so they can use the store
+		store.AddToCounter("gauge.evaluations", 1)
+		return float64(calls)
+	})
+	store.Snapshot()
+	snapshot := store.Snapshot()
+	if snapshot.Gauges()["calls"] != 2 {
+		t.Errorf("expected gauge func to be evaluated twice but got %v", snapshot.Gauges()["calls"])
+	}
+	if snapshot.Counters()["gauge.evaluations"] != 1 {
+		t.Errorf("expected the counter to contain the first evaluation but got %v", snapshot.Counters()["gauge.evaluations"])
+	}
+}
+
+func TestMetersSurviveReset(t *testing.T) {
+	store := NewStore()
+	store.MarkMeter("requests", 3)
+	store.MarkMeter("requests", 2)
+	snapshot := store.SnapshotAndReset()
+	if snapshot.Meters()["requests"].Count() != 5 {
+		t.Error("expected meter to count 5 events but got", snapshot.Meters()["requests"].Count())
+	}
+	store.MarkMeter("requests", 1)
+	if count := store.Snapshot().Meters()["requests"].Count(); count != 6 {
+		t.Error("meters should survive a reset, expected 6 events but got", count)
+	}
+}
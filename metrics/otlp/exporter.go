@@ -0,0 +1,107 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package otlp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+const (
+	// DefaultEndpoint is the endpoint of the collector when Config.Endpoint is empty, the default OTLP/HTTP endpoint
+	// of a local collector
+	DefaultEndpoint = "http://localhost:4318/v1/metrics"
+	// DefaultTimeout is the timeout of an export when Config.Client is nil
+	DefaultTimeout = 10 * time.Second
+)
+
+// Config configures an Exporter, fields with a zero value get a default
+type Config struct {
+	// Endpoint is the URL the requests are POSTed to, defaults to DefaultEndpoint
+	Endpoint string
+	// Temporality must match how the snapshots are taken: Delta for SnapshotAndReset() or a Cursor(), which are the
+	// reporter.Reset and reporter.Delta modes, and Cumulative for Snapshot(). Defaults to Cumulative
+	Temporality Temporality
+	// Resource contains the attributes of the resource that produces the metrics, such as service.name
+	Resource api.Tags
+	// Headers are added to every request, for example to authenticate
+	Headers map[string]string
+	// Client sends the requests, defaults to a client with DefaultTimeout
+	Client *http.Client
+}
+
+// Exporter POSTs snapshots to an OpenTelemetry collector, see Encode. Exporter implements reporter.Sink.
+type Exporter struct {
+	endpoint    string
+	temporality Temporality
+	resource    api.Tags
+	headers     map[string]string
+	client      *http.Client
+}
+
+// NewExporter creates an Exporter
+func NewExporter(config Config) *Exporter {
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultEndpoint
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Exporter{
+		endpoint:    config.Endpoint,
+		temporality: config.Temporality,
+		resource:    config.Resource,
+		headers:     config.Headers,
+		client:      config.Client,
+	}
+}
+
+// Report exports the snapshot to the collector. Returns an error when the request fails or the collector does not
+// respond with a 2xx status.
+func (exporter *Exporter) Report(snapshot api.Snapshot) error {
+	var body bytes.Buffer
+	if err := Encode(&body, snapshot, exporter.temporality, exporter.resource); err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, exporter.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range exporter.headers {
+		request.Header.Set(name, value)
+	}
+	response, err := exporter.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("otlp: export failed with status %v: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	io.Copy(io.Discard, response.Body)
+	return nil
+}
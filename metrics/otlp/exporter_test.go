@@ -0,0 +1,88 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package otlp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/reporter"
+)
+
+func requestsSnapshot() api.Snapshot {
+	return &common.Snapshot{TimestampStarted: 1, TimestampCreated: 2, CountersSnapshot: map[string]int64{"requests": 5}}
+}
+
+func TestExporterImplementsSink(t *testing.T) {
+	var _ reporter.Sink = NewExporter(Config{})
+}
+
+func TestExporterExports(t *testing.T) {
+	var received exportMetricsServiceRequest
+	var request *http.Request
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer collector.Close()
+
+	exporter := NewExporter(Config{
+		Endpoint:    collector.URL + "/v1/metrics",
+		Temporality: Delta,
+		Resource:    api.Tags{"service.name": "api"},
+		Headers:     map[string]string{"Authorization": "Bearer abc"},
+	})
+	if err := exporter.Report(requestsSnapshot()); err != nil {
+		t.Fatal("exporting failed", err)
+	}
+	if request.Method != http.MethodPost || request.URL.Path != "/v1/metrics" {
+		t.Error("expected a POST to /v1/metrics but got", request.Method, request.URL.Path)
+	}
+	if request.Header.Get("Content-Type") != "application/json" || request.Header.Get("Authorization") != "Bearer abc" {
+		t.Error("unexpected headers", request.Header)
+	}
+	metrics := received.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 1 || metrics[0].Sum.AggregationTemporality != aggregationTemporalityDelta {
+		t.Errorf("the collector did not receive the delta snapshot, got %+v", metrics)
+	}
+}
+
+func TestExporterError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+	err := NewExporter(Config{Endpoint: collector.URL}).Report(requestsSnapshot())
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "unavailable") {
+		t.Error("expected an error with the status and the message of the collector but got", err)
+	}
+}
+
+func TestNewExporterDefaults(t *testing.T) {
+	if exporter := NewExporter(Config{}); exporter.endpoint != DefaultEndpoint || exporter.temporality != Cumulative {
+		t.Error("expected the default endpoint and cumulative temporality but got", exporter.endpoint, exporter.temporality)
+	}
+}
@@ -0,0 +1,327 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package otlp exports patan snapshots to an OpenTelemetry collector as an OTLP ExportMetricsServiceRequest in the
+// JSON encoding. example:
+// exporter := otlp.NewExporter(otlp.Config{Temporality: otlp.Delta, Resource: api.Tags{"service.name": "api"}})
+// r := reporter.Start(metrics.New(), time.Minute, reporter.Reset, exporter)
+// defer r.Stop()
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// ScopeName is the name of the instrumentation scope of the exported metrics
+const ScopeName = "github.com/toefel18/go-patan/metrics"
+
+// Temporality determines whether the counters of a snapshot are exported as cumulative or delta sums
+type Temporality int
+
+const (
+	// Cumulative is the temporality of snapshots taken with Snapshot(), counters contain everything since the facade
+	// was created or reset
+	Cumulative Temporality = iota
+	// Delta is the temporality of snapshots taken with SnapshotAndReset() or a Cursor(), counters contain what changed
+	// since the previous snapshot
+	Delta
+)
+
+// aggregation temporality values of OTLP
+const (
+	aggregationTemporalityDelta      = 1
+	aggregationTemporalityCumulative = 2
+)
+
+// the OTLP messages, int64 and uint64 fields are encoded as strings by the JSON mapping of protobuf
+type exportMetricsServiceRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type metric struct {
+	Name    string   `json:"name"`
+	Unit    string   `json:"unit,omitempty"`
+	Sum     *sum     `json:"sum,omitempty"`
+	Gauge   *gauge   `json:"gauge,omitempty"`
+	Summary *summary `json:"summary,omitempty"`
+}
+
+type sum struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type summary struct {
+	DataPoints []summaryDataPoint `json:"dataPoints"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsInt             *string    `json:"asInt,omitempty"`
+	AsDouble          *float64   `json:"asDouble,omitempty"`
+}
+
+type summaryDataPoint struct {
+	Attributes        []keyValue      `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	QuantileValues    []quantileValue `json:"quantileValues,omitempty"`
+}
+
+type quantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// Encode writes the snapshot to w as an OTLP ExportMetricsServiceRequest in the JSON encoding, with resource as the
+// attributes of the resource. Counters are written as non-monotonic sums with the given temporality, gauges as
+// gauges, meters as a cumulative monotonic sum with the count and a gauge named key.rate with a window attribute (1m,
+// 5m, 15m or mean), and durations and samples as summaries with the quantiles 0 (the minimum),
+// common.DefaultQuantiles and 1 (the maximum). Durations have the unit ms. The start time of counters and summaries
+// is the StartedTimestamp() of the snapshot, the start time of meter counts is the StartedTimestamp() of the meter,
+// and the time of every data point is the CreatedTimestamp() of the snapshot. The tags of tagged series are written
+// as attributes and series with the same key are data points of the same metric. JSON has no NaN and infinite
+// numbers, so data points with such a value are left out, and so are metrics without data points.
+func Encode(w io.Writer, snapshot api.Snapshot, temporality Temporality, resourceAttributes api.Tags) error {
+	return json.NewEncoder(w).Encode(newRequest(snapshot, temporality, resourceAttributes))
+}
+
+func newRequest(snapshot api.Snapshot, temporality Temporality, resourceAttributes api.Tags) *exportMetricsServiceRequest {
+	start := nanos(snapshot.StartedTimestamp())
+	now := nanos(snapshot.CreatedTimestamp())
+	aggregationTemporality := aggregationTemporalityCumulative
+	if temporality == Delta {
+		aggregationTemporality = aggregationTemporalityDelta
+	}
+
+	series := snapshot.Series()
+	var metrics []metric
+	for _, family := range groupByKey(counterKeys(snapshot.Counters()), series) {
+		points := make([]numberDataPoint, 0, len(family.seriesKeys))
+		for _, seriesKey := range family.seriesKeys {
+			value := strconv.FormatInt(snapshot.Counters()[seriesKey], 10)
+			points = append(points, numberDataPoint{Attributes: attributes(family.tags[seriesKey]),
+				StartTimeUnixNano: start, TimeUnixNano: now, AsInt: &value})
+		}
+		metrics = append(metrics, metric{Name: family.key, Sum: &sum{points, aggregationTemporality, false}})
+	}
+	for _, family := range groupByKey(gaugeKeys(snapshot.Gauges()), series) {
+		points := make([]numberDataPoint, 0, len(family.seriesKeys))
+		for _, seriesKey := range family.seriesKeys {
+			value := snapshot.Gauges()[seriesKey]
+			if !isFinite(value) {
+				continue
+			}
+			points = append(points, numberDataPoint{Attributes: attributes(family.tags[seriesKey]), TimeUnixNano: now, AsDouble: &value})
+		}
+		if len(points) > 0 {
+			metrics = append(metrics, metric{Name: family.key, Gauge: &gauge{points}})
+		}
+	}
+	metrics = appendMeters(metrics, snapshot.Meters(), series, now)
+	metrics = appendSummaries(metrics, snapshot.Durations(), series, "ms", start, now)
+	metrics = appendSummaries(metrics, snapshot.Samples(), series, "", start, now)
+
+	return &exportMetricsServiceRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     resource{Attributes: attributes(resourceAttributes)},
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: ScopeName}, Metrics: metrics}},
+	}}}
+}
+
+func appendMeters(metrics []metric, meters map[string]api.Meter, series map[string]api.Series, now string) []metric {
+	keys := make([]string, 0, len(meters))
+	for key := range meters {
+		keys = append(keys, key)
+	}
+	for _, family := range groupByKey(keys, series) {
+		var counts, rates []numberDataPoint
+		for _, seriesKey := range family.seriesKeys {
+			meter := meters[seriesKey]
+			count := strconv.FormatInt(meter.Count(), 10)
+			point := numberDataPoint{Attributes: attributes(family.tags[seriesKey]), TimeUnixNano: now, AsInt: &count}
+			// the count of a meter is never reset, its sum starts when the meter was created
+			if started := meter.StartedTimestamp(); started != 0 {
+				point.StartTimeUnixNano = nanos(started)
+			}
+			counts = append(counts, point)
+			for _, window := range []struct {
+				name string
+				rate float64
+			}{{"1m", meter.Rate1()}, {"5m", meter.Rate5()}, {"15m", meter.Rate15()}, {"mean", meter.RateMean()}} {
+				if !isFinite(window.rate) {
+					continue
+				}
+				tags := api.Tags{"window": window.name}
+				for name, value := range family.tags[seriesKey] {
+					tags[name] = value
+				}
+				rate := window.rate
+				rates = append(rates, numberDataPoint{Attributes: attributes(tags), TimeUnixNano: now, AsDouble: &rate})
+			}
+		}
+		metrics = append(metrics, metric{Name: family.key, Sum: &sum{counts, aggregationTemporalityCumulative, true}})
+		if len(rates) > 0 {
+			metrics = append(metrics, metric{Name: family.key + ".rate", Unit: "1/s", Gauge: &gauge{rates}})
+		}
+	}
+	return metrics
+}
+
+func appendSummaries(metrics []metric, distributions map[string]api.Distribution, series map[string]api.Series,
+	unit, start, now string) []metric {
+	keys := make([]string, 0, len(distributions))
+	for key := range distributions {
+		keys = append(keys, key)
+	}
+	for _, family := range groupByKey(keys, series) {
+		points := make([]summaryDataPoint, 0, len(family.seriesKeys))
+		for _, seriesKey := range family.seriesKeys {
+			dist := distributions[seriesKey]
+			point := summaryDataPoint{
+				Attributes:        attributes(family.tags[seriesKey]),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				Count:             strconv.FormatInt(dist.SampleCount(), 10),
+				Sum:               dist.Avg() * float64(dist.SampleCount()),
+			}
+			if !isFinite(point.Sum) || dist.SampleCount() > 0 && (!isFinite(dist.Min()) || !isFinite(dist.Max())) {
+				continue
+			}
+			if dist.SampleCount() > 0 {
+				point.QuantileValues = append(point.QuantileValues, quantileValue{0, dist.Min()})
+				for _, q := range common.DefaultQuantiles {
+					point.QuantileValues = append(point.QuantileValues, quantileValue{q, dist.Percentile(q)})
+				}
+				point.QuantileValues = append(point.QuantileValues, quantileValue{1, dist.Max()})
+			}
+			points = append(points, point)
+		}
+		if len(points) > 0 {
+			metrics = append(metrics, metric{Name: family.key, Unit: unit, Summary: &summary{points}})
+		}
+	}
+	return metrics
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// family contains the series keys of a key with their tags
+type family struct {
+	key        string
+	seriesKeys []string
+	tags       map[string]api.Tags
+}
+
+// groupByKey groups series keys by their key, see common.SplitSeriesKey, the families and their series keys are sorted
+func groupByKey(seriesKeys []string, series map[string]api.Series) []*family {
+	sort.Strings(seriesKeys)
+	var families []*family
+	byKey := make(map[string]*family)
+	for _, seriesKey := range seriesKeys {
+		key, tags := common.SplitSeriesKey(series, seriesKey)
+		current, exists := byKey[key]
+		if !exists {
+			current = &family{key: key, tags: make(map[string]api.Tags)}
+			byKey[key] = current
+			families = append(families, current)
+		}
+		current.seriesKeys = append(current.seriesKeys, seriesKey)
+		current.tags[seriesKey] = tags
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].key < families[j].key })
+	return families
+}
+
+func counterKeys(counters map[string]int64) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func gaugeKeys(gauges map[string]float64) []string {
+	keys := make([]string, 0, len(gauges))
+	for key := range gauges {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// attributes returns the tags as attributes sorted by key
+func attributes(tags api.Tags) []keyValue {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]keyValue, 0, len(tags))
+	for _, name := range names {
+		result = append(result, keyValue{name, anyValue{tags[name]}})
+	}
+	return result
+}
+
+func nanos(millis int64) string {
+	return strconv.FormatInt(millis*1000000, 10)
+}
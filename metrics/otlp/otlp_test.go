@@ -0,0 +1,227 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+	"github.com/toefel18/go-patan/metrics/lockbased"
+)
+
+func encode(t *testing.T, snapshot api.Snapshot, temporality Temporality, resourceAttributes api.Tags) *exportMetricsServiceRequest {
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, snapshot, temporality, resourceAttributes); err != nil {
+		t.Fatal("encoding failed", err)
+	}
+	var request exportMetricsServiceRequest
+	if err := json.Unmarshal(buffer.Bytes(), &request); err != nil {
+		t.Fatal("the request is not valid json", err)
+	}
+	return &request
+}
+
+func metricsOf(t *testing.T, snapshot api.Snapshot, temporality Temporality) map[string]metric {
+	byName := make(map[string]metric)
+	for _, metric := range encode(t, snapshot, temporality, nil).ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		byName[metric.Name] = metric
+	}
+	return byName
+}
+
+func TestEncodeResourceAndScope(t *testing.T) {
+	request := encode(t, &common.Snapshot{}, Cumulative, api.Tags{"service.name": "api", "host.name": "a"})
+	if len(request.ResourceMetrics) != 1 || len(request.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatal("expected a single resource and scope")
+	}
+	attributes := request.ResourceMetrics[0].Resource.Attributes
+	if len(attributes) != 2 || attributes[0] != (keyValue{"host.name", anyValue{"a"}}) ||
+		attributes[1] != (keyValue{"service.name", anyValue{"api"}}) {
+		t.Error("expected the resource attributes sorted by name but got", attributes)
+	}
+	if scope := request.ResourceMetrics[0].ScopeMetrics[0].Scope; scope.Name != ScopeName {
+		t.Error("unexpected scope", scope.Name)
+	}
+}
+
+func TestEncodeCumulativeSnapshots(t *testing.T) {
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	facade.IncrementCounterWithTags("requests", api.Tags{"method": "GET"})
+	facade.IncrementCounterWithTags("requests", api.Tags{"method": "POST"})
+	first := facade.Snapshot()
+	facade.IncrementCounterWithTags("requests", api.Tags{"method": "GET"})
+	second := facade.Snapshot()
+
+	requests := metricsOf(t, second, Cumulative)["requests"].Sum
+	if requests == nil || requests.AggregationTemporality != aggregationTemporalityCumulative || requests.IsMonotonic {
+		t.Fatalf("expected a cumulative non-monotonic sum but got %+v", requests)
+	}
+	get := requests.DataPoints[0]
+	if *get.AsInt != "2" || get.Attributes[0] != (keyValue{"method", anyValue{"GET"}}) {
+		t.Errorf("expected GET to count everything since the facade was created but got %+v", get)
+	}
+	if get.StartTimeUnixNano != nanos(first.StartedTimestamp()) || get.TimeUnixNano != nanos(second.CreatedTimestamp()) {
+		t.Errorf("expected cumulative snapshots to keep their start time but got %+v", get)
+	}
+}
+
+func TestEncodeDeltaSnapshots(t *testing.T) {
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	facade.AddToCounter("requests", 3)
+	time.Sleep(2 * time.Millisecond)
+	first := facade.SnapshotAndReset()
+	facade.AddToCounter("requests", 2)
+	second := facade.SnapshotAndReset()
+
+	requests := metricsOf(t, second, Delta)["requests"].Sum
+	if requests == nil || requests.AggregationTemporality != aggregationTemporalityDelta {
+		t.Fatalf("expected a delta sum but got %+v", requests)
+	}
+	point := requests.DataPoints[0]
+	if *point.AsInt != "2" || point.StartTimeUnixNano != nanos(second.StartedTimestamp()) {
+		t.Errorf("expected the delta since the reset but got %+v", point)
+	}
+	if point.StartTimeUnixNano == nanos(first.StartedTimestamp()) {
+		t.Error("expected every delta to start at the reset that preceded it")
+	}
+}
+
+func TestEncodeMetersStartWhenTheMeterWasCreated(t *testing.T) {
+	facade := lockbased.NewFacade(lockbased.NewStore())
+	facade.MarkMeter("logins", 3)
+	time.Sleep(2 * time.Millisecond)
+	first := facade.SnapshotAndReset()
+	facade.MarkMeter("logins", 1)
+	second := facade.SnapshotAndReset()
+
+	started := nanos(first.Meters()["logins"].StartedTimestamp())
+	for i, snapshot := range []api.Snapshot{first, second} {
+		logins := metricsOf(t, snapshot, Delta)["logins"].Sum
+		if logins == nil || logins.AggregationTemporality != aggregationTemporalityCumulative || !logins.IsMonotonic {
+			t.Fatalf("expected the meter count to be a cumulative monotonic sum but got %+v", logins)
+		}
+		if point := logins.DataPoints[0]; point.StartTimeUnixNano != started {
+			t.Errorf("snapshot %v: expected the meter count to start at %v but got %+v", i, started, point)
+		}
+	}
+	if count := *metricsOf(t, second, Delta)["logins"].Sum.DataPoints[0].AsInt; count != "4" {
+		t.Error("expected the meter count to include the events before the reset but got", count)
+	}
+	if second.StartedTimestamp() == first.StartedTimestamp() {
+		t.Error("expected the snapshots to start at different times")
+	}
+}
+
+func TestEncodeMeterRates(t *testing.T) {
+	snapshot := &common.Snapshot{MetersSnapshot: map[string]api.Meter{
+		"logins": &common.MeterSnapshot{Events: 4, M1: 0.5, M5: 0.25, M15: 0.125, Mean: math.NaN()},
+	}}
+	rates := metricsOf(t, snapshot, Cumulative)["logins.rate"]
+	if rates.Unit != "1/s" || rates.Gauge == nil || len(rates.Gauge.DataPoints) != 3 {
+		t.Fatalf("expected a gauge with the three finite rates but got %+v", rates)
+	}
+	for i, window := range []string{"1m", "5m", "15m"} {
+		if attributes := rates.Gauge.DataPoints[i].Attributes; attributes[0] != (keyValue{"window", anyValue{window}}) {
+			t.Errorf("expected rate %v to have the window %v but got %v", i, window, attributes)
+		}
+	}
+}
+
+func TestEncodeSummaries(t *testing.T) {
+	dist := common.NewDistribution()
+	dist.AddSample(10)
+	dist.AddSample(20)
+	snapshot := &common.Snapshot{
+		TimestampStarted:  1,
+		DurationsSnapshot: map[string]api.Distribution{"db.query": dist},
+		SamplesSnapshot:   map[string]api.Distribution{"empty": common.NewDistribution()},
+	}
+	metrics := metricsOf(t, snapshot, Delta)
+	query := metrics["db.query"]
+	if query.Unit != "ms" || query.Summary == nil {
+		t.Fatalf("expected durations to be a summary in ms but got %+v", query)
+	}
+	point := query.Summary.DataPoints[0]
+	if point.Count != "2" || point.Sum != 30 || point.StartTimeUnixNano != "1000000" {
+		t.Errorf("unexpected summary data point %+v", point)
+	}
+	quantiles := point.QuantileValues
+	if len(quantiles) != len(common.DefaultQuantiles)+2 || quantiles[0] != (quantileValue{0, 10}) ||
+		quantiles[len(quantiles)-1] != (quantileValue{1, 20}) {
+		t.Errorf("expected the quantiles to range from the minimum to the maximum but got %+v", quantiles)
+	}
+	if empty := metrics["empty"]; empty.Unit != "" || empty.Summary.DataPoints[0].Count != "0" ||
+		len(empty.Summary.DataPoints[0].QuantileValues) != 0 {
+		t.Errorf("expected samples to be a summary without unit and an empty one to have no quantiles, got %+v", empty)
+	}
+}
+
+func TestEncodeLeavesOutNonFiniteSummaryPoints(t *testing.T) {
+	finite := common.NewDistribution()
+	finite.AddSample(1)
+	infinite := common.NewDistribution()
+	infinite.AddSample(math.Inf(1))
+	snapshot := &common.Snapshot{
+		DurationsSnapshot: map[string]api.Distribution{"db.query{db=a}": finite, "db.query{db=b}": infinite},
+		SamplesSnapshot:   map[string]api.Distribution{"overflow": infinite},
+		SeriesSnapshot: map[string]api.Series{
+			"db.query{db=a}": {Key: "db.query", Tags: api.Tags{"db": "a"}},
+			"db.query{db=b}": {Key: "db.query", Tags: api.Tags{"db": "b"}},
+		},
+	}
+	metrics := metricsOf(t, snapshot, Cumulative)
+	if _, exists := metrics["overflow"]; exists {
+		t.Error("expected a summary without finite data points to be left out")
+	}
+	points := metrics["db.query"].Summary.DataPoints
+	if len(points) != 1 || points[0].Attributes[0] != (keyValue{"db", anyValue{"a"}}) {
+		t.Errorf("expected only the finite data point of db=a but got %+v", points)
+	}
+}
+
+func TestEncodeLeavesOutNonFiniteNumberPoints(t *testing.T) {
+	snapshot := &common.Snapshot{
+		GaugesSnapshot: map[string]float64{"nan": math.NaN(), "mixed{a=1}": 1, "mixed{a=2}": math.Inf(-1)},
+	}
+	metrics := encode(t, snapshot, Cumulative, nil).ResourceMetrics[0].ScopeMetrics[0].Metrics
+	var names []string
+	for _, metric := range metrics {
+		names = append(names, metric.Name)
+	}
+	if strings.Join(names, ",") != "mixed" || len(metrics[0].Gauge.DataPoints) != 1 {
+		t.Errorf("expected only the finite data point of mixed but got %+v", metrics)
+	}
+}
+
+func TestEncodeJSONMapping(t *testing.T) {
+	var buffer bytes.Buffer
+	snapshot := &common.Snapshot{TimestampStarted: 1, TimestampCreated: 2, CountersSnapshot: map[string]int64{"requests": 5}}
+	Encode(&buffer, snapshot, Delta, nil)
+	expected := `{"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"github.com/toefel18/go-patan/metrics"},` +
+		`"metrics":[{"name":"requests","sum":{"dataPoints":[{"startTimeUnixNano":"1000000","timeUnixNano":"2000000",` +
+		`"asInt":"5"}],"aggregationTemporality":1,"isMonotonic":false}}]}]}]}` + "\n"
+	if buffer.String() != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, buffer.String())
+	}
+}
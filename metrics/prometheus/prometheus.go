@@ -0,0 +1,308 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package prometheus exposes patan snapshots in the Prometheus text exposition format, so they can be scraped by
+// Prometheus. example:
+// http.Handle("/metrics", prometheus.Handler())
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/toefel18/go-patan/metrics"
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Encode writes the snapshot to w in the Prometheus text exposition format. Counters are written as counter with
+// a _total suffix, gauges are written as gauge, meters are written as a counter with a _total suffix and a gauge
+// with a _rate suffix that has a window label (1m, 5m, 15m or mean), durations and samples are written as summary
+// with the common.DefaultQuantiles, a _sum and a _count. Durations are written in milliseconds, as recorded. Keys are
+// converted with MetricName and the tags of tagged series are written as labels.
+//
+// Every metric name is written by one family only, because Prometheus rejects a scrape with duplicate families.
+// Series of the same kind whose keys convert to the same name, such as a.b and a_b, are written in one family, with
+// a key label that contains the original key when their labels are the same. When the name of a family is already
+// taken by a family of another kind, for example when a duration and a sample have the same key, the kind is
+// appended to the name: a.b becomes a_b_sample.
+func Encode(w io.Writer, snapshot api.Snapshot) error {
+	writer := bufio.NewWriter(w)
+	families := newFamilies(snapshot.Series())
+
+	counters := snapshot.Counters()
+	seriesKeys := make([]string, 0, len(counters))
+	for seriesKey := range counters {
+		seriesKeys = append(seriesKeys, seriesKey)
+	}
+	families.add(seriesKeys, "counter", "_total", "counter", func(writer *bufio.Writer, name, seriesKey, keyLabel string) {
+		writeSample(writer, name, families.labels(seriesKey, keyLabel), float64(counters[seriesKey]))
+	})
+
+	gauges := snapshot.Gauges()
+	seriesKeys = make([]string, 0, len(gauges))
+	for seriesKey := range gauges {
+		seriesKeys = append(seriesKeys, seriesKey)
+	}
+	families.add(seriesKeys, "gauge", "", "gauge", func(writer *bufio.Writer, name, seriesKey, keyLabel string) {
+		writeSample(writer, name, families.labels(seriesKey, keyLabel), gauges[seriesKey])
+	})
+
+	addMeters(families, snapshot.Meters())
+	addSummaries(families, "duration", snapshot.Durations())
+	addSummaries(families, "sample", snapshot.Samples())
+	for _, family := range families.ordered {
+		families.write(writer, family)
+	}
+	return writer.Flush()
+}
+
+// MetricName converts a patan key into a valid Prometheus metric name, by replacing every character that is not
+// allowed with an underscore. my.heavy.operation becomes my_heavy_operation.
+func MetricName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		valid := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && i > 0)
+		if !valid {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return "_"
+	}
+	return string(name)
+}
+
+// Handler returns a http.Handler that serves the snapshot of the global metrics instance
+func Handler() http.Handler {
+	return snapshotHandler(metrics.Snapshot)
+}
+
+// NewHandler returns a http.Handler that serves the snapshot of facade
+func NewHandler(facade api.Facade) http.Handler {
+	if facade == nil {
+		panic("facade = nil, Handler needs a facade")
+	}
+	return snapshotHandler(facade.Snapshot)
+}
+
+type snapshotHandler func() api.Snapshot
+
+func (handler snapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, handler()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	buffer.WriteTo(w)
+}
+
+func addMeters(families *metricFamilies, meters map[string]api.Meter) {
+	seriesKeys := make([]string, 0, len(meters))
+	for seriesKey := range meters {
+		seriesKeys = append(seriesKeys, seriesKey)
+	}
+	families.add(seriesKeys, "meter", "_total", "counter", func(writer *bufio.Writer, name, seriesKey, keyLabel string) {
+		writeSample(writer, name, families.labels(seriesKey, keyLabel), float64(meters[seriesKey].Count()))
+	})
+	families.add(seriesKeys, "meter", "_rate", "gauge", func(writer *bufio.Writer, name, seriesKey, keyLabel string) {
+		meter := meters[seriesKey]
+		writeSample(writer, name, families.labels(seriesKey, keyLabel, `window="1m"`), meter.Rate1())
+		writeSample(writer, name, families.labels(seriesKey, keyLabel, `window="5m"`), meter.Rate5())
+		writeSample(writer, name, families.labels(seriesKey, keyLabel, `window="15m"`), meter.Rate15())
+		writeSample(writer, name, families.labels(seriesKey, keyLabel, `window="mean"`), meter.RateMean())
+	})
+}
+
+func addSummaries(families *metricFamilies, kind string, distributions map[string]api.Distribution) {
+	seriesKeys := make([]string, 0, len(distributions))
+	for seriesKey := range distributions {
+		seriesKeys = append(seriesKeys, seriesKey)
+	}
+	families.add(seriesKeys, kind, "", "summary", func(writer *bufio.Writer, name, seriesKey, keyLabel string) {
+		distribution := distributions[seriesKey]
+		if distribution.SampleCount() > 0 {
+			for _, q := range common.DefaultQuantiles {
+				writeSample(writer, name, families.labels(seriesKey, keyLabel, `quantile="`+formatFloat(q)+`"`), distribution.Percentile(q))
+			}
+		}
+		writeSample(writer, name+"_sum", families.labels(seriesKey, keyLabel), distribution.Avg()*float64(distribution.SampleCount()))
+		writeSample(writer, name+"_count", families.labels(seriesKey, keyLabel), float64(distribution.SampleCount()))
+	})
+}
+
+// seriesWriter writes the samples of a series under the metric name of its family, keyLabel is an extra label that
+// is empty unless another series of the family has the same labels
+type seriesWriter func(writer *bufio.Writer, name, seriesKey, keyLabel string)
+
+// metricFamily contains all series that share a metric name
+type metricFamily struct {
+	name        string
+	kind        string
+	metricType  string
+	seriesKeys  []string
+	writeSeries seriesWriter
+}
+
+func (families *metricFamilies) write(writer *bufio.Writer, family *metricFamily) {
+	occurrences := make(map[string]int, len(family.seriesKeys))
+	for _, seriesKey := range family.seriesKeys {
+		occurrences[families.labels(seriesKey)]++
+	}
+	writeType(writer, family.name, family.metricType)
+	for _, seriesKey := range family.seriesKeys {
+		keyLabel := ""
+		if occurrences[families.labels(seriesKey)] > 1 {
+			key, _ := common.SplitSeriesKey(families.series, seriesKey)
+			keyLabel = `key="` + labelValueEscaper.Replace(key) + `"`
+		}
+		family.writeSeries(writer, family.name, seriesKey, keyLabel)
+	}
+}
+
+// metricFamilies assigns every series to a family with a metric name that no other family writes
+type metricFamilies struct {
+	ordered []*metricFamily
+	// byName contains every metric name written by a family, including the _sum and _count of summaries
+	byName map[string]*metricFamily
+	// series contains the tagged series of the snapshot, see api.Snapshot.Series
+	series map[string]api.Series
+}
+
+func newFamilies(series map[string]api.Series) *metricFamilies {
+	return &metricFamilies{byName: make(map[string]*metricFamily), series: series}
+}
+
+// add groups series keys by the metric name of their key followed by suffix into families of the given kind and
+// type. The families are written after the families added before, sorted by name.
+func (families *metricFamilies) add(seriesKeys []string, kind, suffix, metricType string, writeSeries seriesWriter) {
+	sort.Strings(seriesKeys)
+	var added []*metricFamily
+	for _, seriesKey := range seriesKeys {
+		key, _ := common.SplitSeriesKey(families.series, seriesKey)
+		family := families.family(MetricName(key), kind, suffix, metricType)
+		if family == nil {
+			family = families.newFamily(MetricName(key), kind, suffix, metricType, writeSeries)
+			added = append(added, family)
+		}
+		family.seriesKeys = append(family.seriesKeys, seriesKey)
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].name < added[j].name })
+	families.ordered = append(families.ordered, added...)
+}
+
+// family returns the existing family of the given kind and type that is named base followed by suffix, or nil when
+// it does not exist yet
+func (families *metricFamilies) family(base, kind, suffix, metricType string) *metricFamily {
+	for name := base + suffix; ; name = nextName(name, kind, suffix) {
+		family, exists := families.byName[name]
+		if !exists {
+			return nil
+		}
+		if family.name == name && family.kind == kind && family.metricType == metricType {
+			return family
+		}
+	}
+}
+
+// newFamily creates a family for base and suffix, with the kind appended to base until none of its names are taken
+func (families *metricFamilies) newFamily(base, kind, suffix, metricType string, writeSeries seriesWriter) *metricFamily {
+	name := base + suffix
+	for families.taken(name, metricType) {
+		name = nextName(name, kind, suffix)
+	}
+	family := &metricFamily{name: name, kind: kind, metricType: metricType, writeSeries: writeSeries}
+	for _, written := range writtenNames(name, metricType) {
+		families.byName[written] = family
+	}
+	return family
+}
+
+// nextName returns the name that is tried after name, which has the kind appended once more
+func nextName(name, kind, suffix string) string {
+	return strings.TrimSuffix(name, suffix) + "_" + kind + suffix
+}
+
+func (families *metricFamilies) taken(name, metricType string) bool {
+	for _, written := range writtenNames(name, metricType) {
+		if _, exists := families.byName[written]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// writtenNames returns the metric names of the samples of a family
+func writtenNames(name, metricType string) []string {
+	if metricType == "summary" {
+		return []string{name, name + "_sum", name + "_count"}
+	}
+	return []string{name}
+}
+
+// labels returns the tags of the series as Prometheus labels, followed by the extra labels that are not empty
+func (families *metricFamilies) labels(seriesKey string, extra ...string) string {
+	_, tags := common.SplitSeriesKey(families.series, seriesKey)
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(tags)+len(extra))
+	for _, name := range names {
+		pairs = append(pairs, labelName(name)+`="`+labelValueEscaper.Replace(tags[name])+`"`)
+	}
+	for _, label := range extra {
+		if label != "" {
+			pairs = append(pairs, label)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// labelName converts a tag name into a valid label name, which is a metric name without colons
+func labelName(name string) string {
+	return strings.Replace(MetricName(name), ":", "_", -1)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeType(writer *bufio.Writer, name, metricType string) {
+	writer.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+func writeSample(writer *bufio.Writer, name, labels string, value float64) {
+	writer.WriteString(name)
+	if labels != "" {
+		writer.WriteString("{" + labels + "}")
+	}
+	writer.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
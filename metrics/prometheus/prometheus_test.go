@@ -0,0 +1,246 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package prometheus
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics"
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+func TestEncode(t *testing.T) {
+	duration := common.NewDistribution()
+	duration.AddSample(10)
+	duration.AddSample(10)
+	snapshot := &common.Snapshot{
+		DurationsSnapshot: map[string]api.Distribution{"my.heavy.operation": duration},
+		CountersSnapshot:  map[string]int64{"active.sessions": 131},
+		SamplesSnapshot:   map[string]api.Distribution{"mem.collects": common.NewDistribution()},
+	}
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, snapshot); err != nil {
+		t.Fatal("encoding failed", err)
+	}
+	expected := `# TYPE active_sessions_total counter
+active_sessions_total 131
+# TYPE my_heavy_operation summary
+my_heavy_operation{quantile="0.5"} 10
+my_heavy_operation{quantile="0.9"} 10
+my_heavy_operation{quantile="0.95"} 10
+my_heavy_operation{quantile="0.99"} 10
+my_heavy_operation{quantile="0.999"} 10
+my_heavy_operation_sum 20
+my_heavy_operation_count 2
+# TYPE mem_collects summary
+mem_collects_sum 0
+mem_collects_count 0
+`
+	if buffer.String() != expected {
+		t.Errorf("encoded snapshot was:\n%v\nexpected:\n%v", buffer.String(), expected)
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	expectations := map[string]string{
+		"my.heavy.operation":  "my_heavy_operation",
+		"http.GET./users.200": "http_GET__users_200",
+		"1st.key":             "_st_key",
+		"already_valid:name":  "already_valid:name",
+		"":                    "_",
+	}
+	for key, expected := range expectations {
+		if name := MetricName(key); name != expected {
+			t.Errorf("MetricName(%q) = %q, expected %q", key, name, expected)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	metrics.Reset()
+	metrics.IncrementCounter("prometheus.handler.test")
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Header().Get("Content-Type") != ContentType {
+		t.Errorf("content type should be %v but was %v", ContentType, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "prometheus_handler_test_total 1\n") {
+		t.Errorf("the global snapshot was not served, got:\n%v", recorder.Body.String())
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	facade := metrics.New()
+	facade.AddSample("some.sample", 5)
+	recorder := httptest.NewRecorder()
+	NewHandler(facade).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), "some_sample_count 1\n") {
+		t.Errorf("the snapshot of the facade was not served, got:\n%v", recorder.Body.String())
+	}
+	if len(facade.Snapshot().Samples()) != 1 {
+		t.Error("serving a snapshot should not reset the facade")
+	}
+}
+
+func TestNewHandlerWithNilFacade(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewHandler should panic when facade=nil, but no panic")
+		}
+	}()
+	NewHandler(nil)
+}
+
+func TestEncodeTaggedSeries(t *testing.T) {
+	facade := metrics.New()
+	facade.IncrementCounterWithTags("http.requests", api.Tags{"method": "GET", "status": "200"})
+	facade.AddToCounterWithTags("http.requests", api.Tags{"method": "POST", "status": "500"}, 2)
+	facade.IncrementCounter("http.requests")
+	facade.AddSampleWithTags("response.size", api.Tags{"path": `/say "hi"`}, 10)
+	var buffer bytes.Buffer
+	Encode(&buffer, facade.Snapshot())
+
+	expected := `# TYPE http_requests_total counter
+http_requests_total 1
+http_requests_total{method="GET",status="200"} 1
+http_requests_total{method="POST",status="500"} 2
+# TYPE response_size summary
+response_size{path="/say \"hi\"",quantile="0.5"} 10
+response_size{path="/say \"hi\"",quantile="0.9"} 10
+response_size{path="/say \"hi\"",quantile="0.95"} 10
+response_size{path="/say \"hi\"",quantile="0.99"} 10
+response_size{path="/say \"hi\"",quantile="0.999"} 10
+response_size_sum{path="/say \"hi\""} 10
+response_size_count{path="/say \"hi\""} 1
+`
+	if buffer.String() != expected {
+		t.Errorf("encoded snapshot was:\n%v\nexpected:\n%v", buffer.String(), expected)
+	}
+}
+
+func TestEncodeGauges(t *testing.T) {
+	facade := metrics.New()
+	facade.SetGauge("queue.depth", 12)
+	facade.SetGaugeWithTags("pool.size", api.Tags{"pool": "db"}, 4)
+	facade.IncrementCounter("requests")
+	var buffer bytes.Buffer
+	Encode(&buffer, facade.Snapshot())
+
+	expected := `# TYPE requests_total counter
+requests_total 1
+# TYPE pool_size gauge
+pool_size{pool="db"} 4
+# TYPE queue_depth gauge
+queue_depth 12
+`
+	if buffer.String() != expected {
+		t.Errorf("encoded snapshot was:\n%v\nexpected:\n%v", buffer.String(), expected)
+	}
+}
+
+func TestEncodeMeters(t *testing.T) {
+	snapshot := &common.Snapshot{
+		MetersSnapshot: map[string]api.Meter{"http.requests": &common.MeterSnapshot{Events: 10, M1: 1, M5: 2, M15: 3, Mean: 4}},
+	}
+	var buffer bytes.Buffer
+	Encode(&buffer, snapshot)
+
+	expected := `# TYPE http_requests_total counter
+http_requests_total 10
+# TYPE http_requests_rate gauge
+http_requests_rate{window="1m"} 1
+http_requests_rate{window="5m"} 2
+http_requests_rate{window="15m"} 3
+http_requests_rate{window="mean"} 4
+`
+	if buffer.String() != expected {
+		t.Errorf("encoded snapshot was:\n%v\nexpected:\n%v", buffer.String(), expected)
+	}
+}
+
+func TestEncodeWritesEveryNameOnce(t *testing.T) {
+	distribution := common.NewDistribution()
+	distribution.AddSample(1)
+	snapshot := &common.Snapshot{
+		CountersSnapshot:  map[string]int64{"a.b": 1, "a_b": 2, "requests": 3},
+		GaugesSnapshot:    map[string]float64{"latency.count": 4},
+		DurationsSnapshot: map[string]api.Distribution{"latency": distribution},
+		SamplesSnapshot:   map[string]api.Distribution{"latency": distribution},
+	}
+	var buffer bytes.Buffer
+	Encode(&buffer, snapshot)
+
+	output := buffer.String()
+	for _, expected := range []string{
+		"a_b_total{key=\"a.b\"} 1\n",
+		"a_b_total{key=\"a_b\"} 2\n",
+		"requests_total 3\n",
+		"latency_count 4\n",
+		"latency_duration_count 1\n",
+		"latency_sample_count 1\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in:\n%v", expected, output)
+		}
+	}
+	names := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			name := strings.Fields(line)[2]
+			if names[name] {
+				t.Errorf("family %v is written twice in:\n%v", name, output)
+			}
+			names[name] = true
+			continue
+		}
+		if name := strings.FieldsFunc(line, func(c rune) bool { return c == '{' || c == ' ' }); len(name) > 0 {
+			family := strings.TrimSuffix(strings.TrimSuffix(name[0], "_sum"), "_count")
+			if !names[name[0]] && !names[family] {
+				t.Errorf("sample %v does not belong to a family in:\n%v", name[0], output)
+			}
+		}
+	}
+}
+
+func TestEncodeMeterAndCounterWithTheSameKey(t *testing.T) {
+	snapshot := &common.Snapshot{
+		CountersSnapshot: map[string]int64{"http.requests": 3},
+		MetersSnapshot:   map[string]api.Meter{"http.requests": &common.MeterSnapshot{Events: 10, M1: 1, M5: 2, M15: 3, Mean: 4}},
+	}
+	var buffer bytes.Buffer
+	Encode(&buffer, snapshot)
+
+	expected := `# TYPE http_requests_total counter
+http_requests_total 3
+# TYPE http_requests_meter_total counter
+http_requests_meter_total 10
+# TYPE http_requests_rate gauge
+http_requests_rate{window="1m"} 1
+http_requests_rate{window="5m"} 2
+http_requests_rate{window="15m"} 3
+http_requests_rate{window="mean"} 4
+`
+	if buffer.String() != expected {
+		t.Errorf("encoded snapshot was:\n%v\nexpected:\n%v", buffer.String(), expected)
+	}
+}
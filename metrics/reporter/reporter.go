@@ -0,0 +1,133 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package reporter periodically takes snapshots of an api.Facade and pushes them to one or more sinks. example:
+// r := reporter.Start(metrics.New(), time.Minute, reporter.Reset, reporter.NewLogSink(nil))
+// defer r.Stop()
+package reporter
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// Mode determines how a Reporter takes its snapshots
+type Mode int
+
+const (
+	// Reset takes snapshots with SnapshotAndReset(), every report covers the time since the previous report
+	Reset Mode = iota
+	// Cumulative takes snapshots with Snapshot(), every report covers the time since the facade was created or reset
+	Cumulative
+	// Delta reads snapshots with a Cursor(), every report covers the time since the previous report without resetting
+	// the facade, so other consumers of the facade are not affected
+	Delta
+)
+
+// Sink receives the snapshots taken by a Reporter
+type Sink interface {
+	Report(snapshot api.Snapshot) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(snapshot api.Snapshot) error
+
+// Report calls f(snapshot)
+func (f SinkFunc) Report(snapshot api.Snapshot) error {
+	return f(snapshot)
+}
+
+// Reporter takes a snapshot of a facade at a fixed interval and reports it to its sinks
+type Reporter struct {
+	facade   api.Facade
+	interval time.Duration
+	mode     Mode
+	cursor   api.Cursor
+	sinks    []Sink
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Start creates a Reporter and starts a go-routine that reports a snapshot of facade to all sinks every interval.
+func Start(facade api.Facade, interval time.Duration, mode Mode, sinks ...Sink) *Reporter {
+	if facade == nil {
+		panic("facade = nil, Reporter needs a facade")
+	}
+	if interval <= 0 {
+		panic("interval must be > 0")
+	}
+	reporter := &Reporter{
+		facade:   facade,
+		interval: interval,
+		mode:     mode,
+		sinks:    sinks,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if mode == Delta {
+		reporter.cursor = facade.Cursor()
+	}
+	go reporter.run()
+	return reporter
+}
+
+// Stop stops the reporter after reporting a final snapshot, so nothing recorded before Stop() is lost. Stop blocks
+// until the final snapshot has been reported, calling it more than once has no effect.
+func (reporter *Reporter) Stop() {
+	reporter.stopOnce.Do(func() {
+		close(reporter.stop)
+	})
+	<-reporter.done
+}
+
+func (reporter *Reporter) run() {
+	defer close(reporter.done)
+	ticker := time.NewTicker(reporter.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reporter.report()
+		case <-reporter.stop:
+			reporter.report()
+			return
+		}
+	}
+}
+
+func (reporter *Reporter) report() {
+	var snapshot api.Snapshot
+	switch reporter.mode {
+	case Reset:
+		snapshot = reporter.facade.SnapshotAndReset()
+	case Delta:
+		snapshot = reporter.cursor.Next()
+	default:
+		snapshot = reporter.facade.Snapshot()
+	}
+	for _, sink := range reporter.sinks {
+		if err := sink.Report(snapshot); err != nil {
+			log.Println("[METRICS] reporting snapshot failed:", err)
+		}
+	}
+}
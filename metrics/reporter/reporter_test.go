@@ -0,0 +1,139 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package reporter
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics"
+	"github.com/toefel18/go-patan/metrics/api"
+)
+
+// collectingSink keeps every reported snapshot
+type collectingSink struct {
+	snapshots []api.Snapshot
+	lock      sync.Mutex
+}
+
+func (sink *collectingSink) Report(snapshot api.Snapshot) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.snapshots = append(sink.snapshots, snapshot)
+	return nil
+}
+
+func (sink *collectingSink) reported() []api.Snapshot {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return append([]api.Snapshot(nil), sink.snapshots...)
+}
+
+func TestReporterResetMode(t *testing.T) {
+	facade := metrics.New()
+	sink := &collectingSink{}
+	reporter := Start(facade, 50*time.Millisecond, Reset, sink)
+	facade.IncrementCounter("reported.counter")
+	time.Sleep(75 * time.Millisecond)
+	facade.IncrementCounter("reported.counter")
+	reporter.Stop()
+
+	snapshots := sink.reported()
+	if len(snapshots) != 2 {
+		t.Fatalf("expected a report after 50ms and a final report on Stop(), but got %v reports", len(snapshots))
+	}
+	if snapshots[0].Counters()["reported.counter"] != 1 || snapshots[1].Counters()["reported.counter"] != 1 {
+		t.Error("in Reset mode, every report should only contain what was recorded since the previous report")
+	}
+	if len(facade.Snapshot().Counters()) != 0 {
+		t.Error("in Reset mode, the facade should be reset after reporting")
+	}
+}
+
+func TestReporterCumulativeMode(t *testing.T) {
+	facade := metrics.New()
+	sink := &collectingSink{}
+	reporter := Start(facade, 50*time.Millisecond, Cumulative, sink)
+	facade.IncrementCounter("reported.counter")
+	time.Sleep(75 * time.Millisecond)
+	facade.IncrementCounter("reported.counter")
+	reporter.Stop()
+
+	snapshots := sink.reported()
+	if len(snapshots) != 2 || snapshots[1].Counters()["reported.counter"] != 2 {
+		t.Error("in Cumulative mode, the final report should contain everything recorded")
+	}
+	if facade.Snapshot().Counters()["reported.counter"] != 2 {
+		t.Error("in Cumulative mode, the facade should not be reset")
+	}
+}
+
+func TestReporterDeltaMode(t *testing.T) {
+	facade := metrics.New()
+	facade.IncrementCounter("reported.counter")
+	sink := &collectingSink{}
+	reporter := Start(facade, 50*time.Millisecond, Delta, sink)
+	facade.IncrementCounter("reported.counter")
+	time.Sleep(75 * time.Millisecond)
+	facade.AddToCounter("reported.counter", 2)
+	reporter.Stop()
+
+	snapshots := sink.reported()
+	if len(snapshots) != 2 {
+		t.Fatalf("expected a report after 50ms and a final report on Stop(), but got %v reports", len(snapshots))
+	}
+	if snapshots[0].Counters()["reported.counter"] != 1 || snapshots[1].Counters()["reported.counter"] != 2 {
+		t.Error("in Delta mode, every report should only contain what was recorded since the previous report")
+	}
+	if facade.Snapshot().Counters()["reported.counter"] != 4 {
+		t.Error("in Delta mode, the facade should not be reset")
+	}
+}
+
+func TestReporterReportsToAllSinksDespiteErrors(t *testing.T) {
+	failing := SinkFunc(func(snapshot api.Snapshot) error {
+		return errors.New("sink unavailable")
+	})
+	sink := &collectingSink{}
+	reporter := Start(metrics.New(), time.Hour, Reset, failing, sink)
+	reporter.Stop()
+	reporter.Stop()
+	if len(sink.reported()) != 1 {
+		t.Errorf("the final snapshot should be reported once to every sink, got %v reports", len(sink.reported()))
+	}
+}
+
+func TestStartWithInvalidArguments(t *testing.T) {
+	arguments := []struct {
+		facade   api.Facade
+		interval time.Duration
+	}{{nil, time.Second}, {metrics.New(), 0}}
+	for _, args := range arguments {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Start(%v, %v) should panic", args.facade, args.interval)
+				}
+			}()
+			Start(args.facade, args.interval, Reset)
+		}()
+	}
+}
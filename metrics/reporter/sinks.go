@@ -0,0 +1,126 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+// NewLogSink returns a Sink that writes a line for every counter, gauge, meter, duration and sample in the snapshot
+// to logger.
+// When logger is nil, the standard logger is used.
+func NewLogSink(logger *log.Logger) Sink {
+	if logger == nil {
+		logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+	return &logSink{logger}
+}
+
+type logSink struct {
+	logger *log.Logger
+}
+
+func (sink *logSink) Report(snapshot api.Snapshot) error {
+	for _, key := range sortedCounterKeys(snapshot.Counters()) {
+		sink.logger.Printf("[METRICS] counter %v=%v", key, snapshot.Counters()[key])
+	}
+	gauges := snapshot.Gauges()
+	gaugeKeys := make([]string, 0, len(gauges))
+	for key := range gauges {
+		gaugeKeys = append(gaugeKeys, key)
+	}
+	sort.Strings(gaugeKeys)
+	for _, key := range gaugeKeys {
+		sink.logger.Printf("[METRICS] gauge %v=%v", key, gauges[key])
+	}
+	meters := snapshot.Meters()
+	meterKeys := make([]string, 0, len(meters))
+	for key := range meters {
+		meterKeys = append(meterKeys, key)
+	}
+	sort.Strings(meterKeys)
+	for _, key := range meterKeys {
+		meter := meters[key]
+		sink.logger.Printf("[METRICS] meter %v count=%v m1=%v m5=%v m15=%v mean=%v",
+			key, meter.Count(), meter.Rate1(), meter.Rate5(), meter.Rate15(), meter.RateMean())
+	}
+	sink.logDistributions("duration", snapshot.Durations())
+	sink.logDistributions("sample", snapshot.Samples())
+	return nil
+}
+
+func (sink *logSink) logDistributions(kind string, distributions map[string]api.Distribution) {
+	keys := make([]string, 0, len(distributions))
+	for key := range distributions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		dist := distributions[key]
+		line := fmt.Sprintf("[METRICS] %v %v count=%v", kind, key, dist.SampleCount())
+		if dist.SampleCount() > 0 {
+			line += fmt.Sprintf(" min=%v max=%v mean=%v stddev=%v", dist.Min(), dist.Max(), dist.Avg(), dist.StdDev())
+			percentiles := make([]string, 0, len(common.DefaultQuantiles))
+			for _, q := range common.DefaultQuantiles {
+				percentiles = append(percentiles, fmt.Sprintf("%v=%v", common.PercentileName(q), dist.Percentile(q)))
+			}
+			line += " " + strings.Join(percentiles, " ")
+		}
+		sink.logger.Println(line)
+	}
+}
+
+// NewJSONLinesSink returns a Sink that writes every snapshot as a single line of json to w
+func NewJSONLinesSink(w io.Writer) Sink {
+	return &jsonLinesSink{writer: w}
+}
+
+type jsonLinesSink struct {
+	writer io.Writer
+	lock   sync.Mutex
+}
+
+func (sink *jsonLinesSink) Report(snapshot api.Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	_, err = sink.writer.Write(append(data, '\n'))
+	return err
+}
+
+func sortedCounterKeys(counters map[string]int64) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package reporter
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/toefel18/go-patan/metrics"
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+func TestLogSink(t *testing.T) {
+	facade := metrics.New()
+	facade.AddToCounter("active.sessions", 131)
+	facade.SetGauge("queue.depth", 4.5)
+	facade.AddSample("mem.collects", 2)
+	facade.RecordElapsedTime("my.heavy.operation", facade.StartStopwatch())
+	var buffer bytes.Buffer
+	NewLogSink(log.New(&buffer, "", 0)).Report(facade.Snapshot())
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a line per counter, gauge, duration and sample but got:\n%v", buffer.String())
+	}
+	if lines[0] != "[METRICS] counter active.sessions=131" {
+		t.Errorf("unexpected counter line %q", lines[0])
+	}
+	if lines[1] != "[METRICS] gauge queue.depth=4.5" {
+		t.Errorf("unexpected gauge line %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "[METRICS] duration my.heavy.operation count=1 min=") {
+		t.Errorf("unexpected duration line %q", lines[2])
+	}
+	if lines[3] != "[METRICS] sample mem.collects count=1 min=2 max=2 mean=2 stddev=0 p50=2 p90=2 p95=2 p99=2 p999=2" {
+		t.Errorf("unexpected sample line %q", lines[3])
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	facade := metrics.New()
+	var buffer bytes.Buffer
+	sink := NewJSONLinesSink(&buffer)
+	facade.IncrementCounter("counter")
+	sink.Report(facade.Snapshot())
+	facade.IncrementCounter("counter")
+	sink.Report(facade.Snapshot())
+
+	scanner := bufio.NewScanner(&buffer)
+	for expected := int64(1); expected <= 2; expected++ {
+		if !scanner.Scan() {
+			t.Fatal("expected a line for every snapshot")
+		}
+		snapshot, err := common.ParseSnapshot(scanner.Bytes())
+		if err != nil {
+			t.Fatal("line does not contain a valid snapshot", err)
+		}
+		if snapshot.Counters()["counter"] != expected {
+			t.Errorf("line %v should contain counter=%v", expected, expected)
+		}
+	}
+	if scanner.Scan() {
+		t.Error("expected only two lines")
+	}
+}
+
+func TestLogSinkWithMeters(t *testing.T) {
+	snapshot := &common.Snapshot{
+		MetersSnapshot: map[string]api.Meter{"http.requests": &common.MeterSnapshot{Events: 10, M1: 1, M5: 2, M15: 3, Mean: 4}},
+	}
+	var buffer bytes.Buffer
+	NewLogSink(log.New(&buffer, "", 0)).Report(snapshot)
+	if buffer.String() != "[METRICS] meter http.requests count=10 m1=1 m5=2 m15=3 mean=4\n" {
+		t.Errorf("unexpected meter line %q", buffer.String())
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package statsd
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// client batches lines into packets of at most maxPacketSize bytes and sends them over a connected UDP socket. A line
+// that is longer than maxPacketSize is sent in a packet of its own.
+type client struct {
+	conn          net.Conn
+	maxPacketSize int
+	buffer        []byte
+	lock          sync.Mutex
+	dropped       int64
+}
+
+func newClient(conn net.Conn, maxPacketSize int) *client {
+	return &client{
+		conn:          conn,
+		maxPacketSize: maxPacketSize,
+		buffer:        make([]byte, 0, maxPacketSize),
+	}
+}
+
+// send adds line to the current packet, the packet is sent first when line does not fit anymore
+func (client *client) send(line []byte) {
+	client.lock.Lock()
+	if len(client.buffer) > 0 && len(client.buffer)+1+len(line) > client.maxPacketSize {
+		client.flushLocked()
+	}
+	if len(client.buffer) > 0 {
+		client.buffer = append(client.buffer, '\n')
+	}
+	client.buffer = append(client.buffer, line...)
+	if len(client.buffer) >= client.maxPacketSize {
+		client.flushLocked()
+	}
+	client.lock.Unlock()
+}
+
+// flush sends the current packet
+func (client *client) flush() {
+	client.lock.Lock()
+	client.flushLocked()
+	client.lock.Unlock()
+}
+
+func (client *client) flushLocked() {
+	if len(client.buffer) == 0 {
+		return
+	}
+	if _, err := client.conn.Write(client.buffer); err != nil {
+		atomic.AddInt64(&client.dropped, 1)
+	}
+	client.buffer = client.buffer[:0]
+}
+
+func (client *client) droppedPackets() int64 {
+	return atomic.LoadInt64(&client.dropped)
+}
+
+func (client *client) close() error {
+	client.flush()
+	return client.conn.Close()
+}
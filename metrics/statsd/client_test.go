@@ -0,0 +1,65 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestClientBatchesIntoPackets(t *testing.T) {
+	listener := listen(t)
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(conn, 20)
+	defer client.close()
+	client.send([]byte("a:1|c"))
+	client.send([]byte("b:2|c"))
+	client.send([]byte("c:3|c"))  // 5+1+5+1+5=17 bytes still fit
+	client.send([]byte("dd:4|c")) // does not fit, the first three are sent
+	client.send([]byte("a.very.long.line.that.does.not.fit:1|c"))
+	client.flush()
+	packets := receive(listener)
+	expected := []string{"a:1|c\nb:2|c\nc:3|c", "dd:4|c", "a.very.long.line.that.does.not.fit:1|c"}
+	if strings.Join(packets, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected packets %q but got %q", expected, packets)
+	}
+	for _, packet := range packets[:2] {
+		if len(packet) > 20 {
+			t.Error("packet is larger than the maximum packet size", packet)
+		}
+	}
+}
+
+func TestClientFlushWithoutRecordings(t *testing.T) {
+	listener := listen(t)
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(conn, 20)
+	client.flush()
+	client.close()
+	if packets := receive(listener); len(packets) != 0 {
+		t.Error("an empty packet should not be sent, got", packets)
+	}
+}
@@ -0,0 +1,465 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package statsd decorates an api.Facade so every recording is also sent to a StatsD or DogStatsD daemon over UDP.
+// example:
+// facade, err := statsd.NewFacade(metrics.New(), statsd.Config{Address: "127.0.0.1:8125", Prefix: "api."})
+// defer facade.Close()
+package statsd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/common"
+)
+
+const (
+	// DefaultAddress is the address of the StatsD daemon when Config.Address is empty
+	DefaultAddress = "127.0.0.1:8125"
+	// DefaultMaxPacketSize is the size of the packets when Config.MaxPacketSize is 0, it fits the MTU of an ethernet
+	// network after the IP and UDP headers
+	DefaultMaxPacketSize = 1432
+	// DefaultFlushInterval is the interval at which partially filled packets are sent when Config.FlushInterval is 0
+	DefaultFlushInterval = 100 * time.Millisecond
+)
+
+// Config configures a Facade, fields with a zero value get a default
+type Config struct {
+	// Address is the host:port of the StatsD daemon, defaults to DefaultAddress
+	Address string
+	// Prefix is prepended to every key
+	Prefix string
+	// MaxPacketSize is the maximum size of a packet in bytes, defaults to DefaultMaxPacketSize
+	MaxPacketSize int
+	// FlushInterval is the interval at which partially filled packets are sent, defaults to DefaultFlushInterval
+	FlushInterval time.Duration
+	// SampleRate is the fraction of the counters, durations and samples that is sent, the daemon scales them back up.
+	// Everything is recorded in the decorated facade regardless of the sample rate. Defaults to 1.
+	SampleRate float64
+	// DogStatsD sends the tags of tagged recordings in the DogStatsD syntax, plain StatsD has no tags so they are left
+	// out otherwise
+	DogStatsD bool
+	// Tags are added to everything that is sent, requires DogStatsD
+	Tags api.Tags
+}
+
+// Facade records everything in the decorated api.Facade and sends counters as |c, durations as |ms, samples as |h
+// and gauges as |g to the StatsD daemon. Meters are sent as counters, the daemon derives their rate. Gauge functions
+// are only evaluated by snapshots of the decorated facade and are not sent.
+type Facade struct {
+	api.Facade
+
+	client     *client
+	prefix     string
+	sampleRate float64
+	dogStatsD  bool
+	tags       string
+	random     func() float64
+	// classifier holds the api.ErrorClassifier of MeasureFuncErr
+	classifier atomic.Value
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFacade creates a Facade that decorates facade and starts a go-routine that sends partially filled packets every
+// Config.FlushInterval. Call Close to stop the go-routine.
+func NewFacade(facade api.Facade, config Config) (*Facade, error) {
+	if facade == nil {
+		panic("facade = nil, statsd.Facade needs a facade to decorate")
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, errors.New("statsd: the sample rate must be between 0 and 1")
+	}
+	if len(config.Tags) > 0 && !config.DogStatsD {
+		return nil, errors.New("statsd: tags require DogStatsD")
+	}
+	if config.Address == "" {
+		config.Address = DefaultAddress
+	}
+	if config.MaxPacketSize <= 0 {
+		config.MaxPacketSize = DefaultMaxPacketSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = 1
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	statsdFacade := &Facade{
+		Facade:     facade,
+		client:     newClient(conn, config.MaxPacketSize),
+		prefix:     config.Prefix,
+		sampleRate: config.SampleRate,
+		dogStatsD:  config.DogStatsD,
+		tags:       formatTags(config.Tags),
+		random:     rand.Float64,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go statsdFacade.run(config.FlushInterval)
+	log.Println("[METRICS] sending metrics to statsd at", config.Address)
+	return statsdFacade, nil
+}
+
+func (facade *Facade) run(interval time.Duration) {
+	defer close(facade.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			facade.client.flush()
+		case <-facade.stop:
+			return
+		}
+	}
+}
+
+// Flush sends the recordings that are waiting for a packet to fill up
+func (facade *Facade) Flush() {
+	facade.client.flush()
+}
+
+// Dropped returns the number of packets that could not be sent
+func (facade *Facade) Dropped() int64 {
+	return facade.client.droppedPackets()
+}
+
+// Close sends the recordings that are waiting for a packet to fill up, stops the go-routine and closes the socket.
+// The decorated facade is not closed. Close can be called more than once.
+func (facade *Facade) Close() error {
+	var err error
+	facade.closeOnce.Do(func() {
+		close(facade.stop)
+		<-facade.done
+		err = facade.client.close()
+	})
+	return err
+}
+
+// RecordElapsedTime records the elapsed time of the stopwatch and sends it as a timing
+func (facade *Facade) RecordElapsedTime(key string, stopwatch api.Stopwatch) float64 {
+	millis := facade.Facade.RecordElapsedTime(key, stopwatch)
+	facade.send(key, nil, formatFloat(millis), "ms", true)
+	return millis
+}
+
+// MeasureFunc runs the subject function, records its execution duration and sends it as a timing
+func (facade *Facade) MeasureFunc(key string, subject func()) float64 {
+	millis := facade.Facade.MeasureFunc(key, subject)
+	facade.send(key, nil, formatFloat(millis), "ms", true)
+	return millis
+}
+
+// MeasureFuncCanPanic runs the subject function, records its execution duration and sends it as a timing. When
+// subject() panics, the measurement is recorded and sent under the same key with .panic appended. This function
+// itself will panic with the same error as the inner function.
+func (facade *Facade) MeasureFuncCanPanic(key string, subject func()) float64 {
+	sw := facade.StartStopwatch()
+	defer func() {
+		if err := recover(); err != nil {
+			facade.RecordElapsedTime(key+".panic", sw)
+			panic(err)
+		}
+	}()
+	subject()
+	return facade.RecordElapsedTime(key, sw)
+}
+
+// MeasureFuncErr runs the subject function and records its duration under key when it succeeds, or under key with the
+// suffix of the error classifier appended when it returns an error. The counter key.ok or the counter with the same
+// suffix is incremented as well. The duration and the increment are sent too.
+func (facade *Facade) MeasureFuncErr(key string, subject func() error) (float64, error) {
+	return common.MeasureFuncErr(facade, facade.errorClassifier(), key, subject)
+}
+
+// SetErrorClassifier sets the classifier that determines the suffix of failed MeasureFuncErr calls, on this facade and
+// on the decorated facade. nil records every failure as error
+func (facade *Facade) SetErrorClassifier(classifier api.ErrorClassifier) {
+	facade.classifier.Store(classifier)
+	facade.Facade.SetErrorClassifier(classifier)
+}
+
+func (facade *Facade) errorClassifier() api.ErrorClassifier {
+	classifier, _ := facade.classifier.Load().(api.ErrorClassifier)
+	return classifier
+}
+
+// RecordElapsedTimeCtx records the elapsed time of the stopwatch under key with the outcome of ctx and err appended,
+// see common.OutcomeKey, and sends it as a timing
+func (facade *Facade) RecordElapsedTimeCtx(ctx context.Context, key string, stopwatch api.Stopwatch, err error) float64 {
+	if contextStopwatch, ok := stopwatch.(*common.ContextStopwatch); ok {
+		contextStopwatch.Stop()
+	}
+	return facade.RecordElapsedTime(common.OutcomeKey(ctx, key, err), stopwatch)
+}
+
+// MeasureFuncCtx runs subject with ctx, records its duration under key with the outcome appended and sends it as a
+// timing. The timing stops when ctx is done, even when subject returns later.
+func (facade *Facade) MeasureFuncCtx(ctx context.Context, key string, subject func(ctx context.Context) error) (float64, error) {
+	sw := facade.StartStopwatchCtx(ctx)
+	err := subject(ctx)
+	return facade.RecordElapsedTimeCtx(ctx, key, sw, err), err
+}
+
+// IncrementCounter increments the counter identified by key by 1 and sends the increment
+func (facade *Facade) IncrementCounter(key string) {
+	facade.AddToCounter(key, 1)
+}
+
+// DecrementCounter decrements the counter identified by key by 1 and sends the decrement
+func (facade *Facade) DecrementCounter(key string) {
+	facade.AddToCounter(key, -1)
+}
+
+// AddToCounter adds value to the counter identified by key and sends it, value can be negative
+func (facade *Facade) AddToCounter(key string, value int64) {
+	facade.Facade.AddToCounter(key, value)
+	facade.send(key, nil, strconv.FormatInt(value, 10), "c", true)
+}
+
+// Counter returns a handle to the counter identified by key that also sends its updates
+func (facade *Facade) Counter(key string) api.Counter {
+	return &counter{facade.Facade.Counter(key), key, facade}
+}
+
+// Timer returns a handle to the duration distribution identified by key that also sends its timings
+func (facade *Facade) Timer(key string) api.Timer {
+	return &timer{facade.Facade.Timer(key), key, facade}
+}
+
+// Histogram returns a handle to the sample distribution identified by key that also sends its samples
+func (facade *Facade) Histogram(key string) api.Histogram {
+	return &histogram{facade.Facade.Histogram(key), key, facade}
+}
+
+// AddSample adds a sample to the distribution identified by key and sends it as a histogram value
+func (facade *Facade) AddSample(key string, value float64) {
+	facade.Facade.AddSample(key, value)
+	facade.send(key, nil, formatFloat(value), "h", true)
+}
+
+// SetGauge sets the gauge identified by key to value and sends it
+func (facade *Facade) SetGauge(key string, value float64) {
+	facade.Facade.SetGauge(key, value)
+	facade.sendGauge(key, nil, value)
+}
+
+// MarkMeter marks the occurrence of n events on the meter identified by key and sends them as a counter
+func (facade *Facade) MarkMeter(key string, n int64) {
+	facade.Facade.MarkMeter(key, n)
+	facade.send(key, nil, strconv.FormatInt(n, 10), "c", true)
+}
+
+// SetGaugeWithTags sets the gauge identified by key and tags to value and sends it
+func (facade *Facade) SetGaugeWithTags(key string, tags api.Tags, value float64) {
+	facade.Facade.SetGaugeWithTags(key, tags, value)
+	facade.sendGauge(key, tags, value)
+}
+
+// MarkMeterWithTags marks the occurrence of n events on the meter identified by key and tags and sends them as a
+// counter
+func (facade *Facade) MarkMeterWithTags(key string, tags api.Tags, n int64) {
+	facade.Facade.MarkMeterWithTags(key, tags, n)
+	facade.send(key, tags, strconv.FormatInt(n, 10), "c", true)
+}
+
+func (facade *Facade) sendGauge(key string, tags api.Tags, value float64) {
+	if value < 0 && !facade.dogStatsD {
+		// StatsD reads a negative value as a decrement of the gauge, so it has to be set to 0 first
+		facade.send(key, tags, "0", "g", false)
+	}
+	facade.send(key, tags, formatFloat(value), "g", false)
+}
+
+// RecordElapsedTimeWithTags records the elapsed time of the stopwatch under key and tags and sends it as a timing
+func (facade *Facade) RecordElapsedTimeWithTags(key string, tags api.Tags, stopwatch api.Stopwatch) float64 {
+	millis := facade.Facade.RecordElapsedTimeWithTags(key, tags, stopwatch)
+	facade.send(key, tags, formatFloat(millis), "ms", true)
+	return millis
+}
+
+// MeasureFuncWithTags runs the subject function, records its execution duration under key and tags and sends it as
+// a timing
+func (facade *Facade) MeasureFuncWithTags(key string, tags api.Tags, subject func()) float64 {
+	millis := facade.Facade.MeasureFuncWithTags(key, tags, subject)
+	facade.send(key, tags, formatFloat(millis), "ms", true)
+	return millis
+}
+
+// IncrementCounterWithTags increments the counter identified by key and tags by 1 and sends the increment
+func (facade *Facade) IncrementCounterWithTags(key string, tags api.Tags) {
+	facade.AddToCounterWithTags(key, tags, 1)
+}
+
+// DecrementCounterWithTags decrements the counter identified by key and tags by 1 and sends the decrement
+func (facade *Facade) DecrementCounterWithTags(key string, tags api.Tags) {
+	facade.AddToCounterWithTags(key, tags, -1)
+}
+
+// AddToCounterWithTags adds value to the counter identified by key and tags and sends it, value can be negative
+func (facade *Facade) AddToCounterWithTags(key string, tags api.Tags, value int64) {
+	facade.Facade.AddToCounterWithTags(key, tags, value)
+	facade.send(key, tags, strconv.FormatInt(value, 10), "c", true)
+}
+
+// AddSampleWithTags adds a sample to the distribution identified by key and tags and sends it as a histogram value
+func (facade *Facade) AddSampleWithTags(key string, tags api.Tags, value float64) {
+	facade.Facade.AddSampleWithTags(key, tags, value)
+	facade.send(key, tags, formatFloat(value), "h", true)
+}
+
+// send formats a line in the form prefix.key:value|type|@rate|#tags and adds it to the current packet. When sampled is
+// true, the line is only sent with the probability of the sample rate.
+func (facade *Facade) send(key string, tags api.Tags, value, metricType string, sampled bool) {
+	if sampled && facade.sampleRate < 1 && facade.random() >= facade.sampleRate {
+		return
+	}
+	line := make([]byte, 0, len(facade.prefix)+len(key)+len(value)+len(facade.tags)+16)
+	line = append(line, sanitizeName(facade.prefix+key)...)
+	line = append(line, ':')
+	line = append(line, value...)
+	line = append(line, '|')
+	line = append(line, metricType...)
+	if sampled && facade.sampleRate < 1 {
+		line = append(line, "|@"...)
+		line = strconv.AppendFloat(line, facade.sampleRate, 'f', -1, 64)
+	}
+	if facade.dogStatsD && (len(tags) > 0 || facade.tags != "") {
+		line = append(line, "|#"...)
+		line = append(line, facade.tags...)
+		if len(tags) > 0 {
+			if facade.tags != "" {
+				line = append(line, ',')
+			}
+			line = append(line, formatTags(tags)...)
+		}
+	}
+	facade.client.send(line)
+}
+
+// formatTags formats the tags as sorted key:value pairs separated by commas
+func formatTags(tags api.Tags) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, sanitizeTag(key)+":"+sanitizeTag(value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+var (
+	nameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "\n", "_")
+	tagReplacer  = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "\n", "_")
+)
+
+// sanitizeName replaces the characters that have a meaning in the StatsD protocol by underscores
+func sanitizeName(name string) string {
+	return nameReplacer.Replace(name)
+}
+
+// sanitizeTag replaces the characters that have a meaning in the DogStatsD tag syntax by underscores
+func sanitizeTag(tag string) string {
+	return tagReplacer.Replace(tag)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// counter updates the counter of the decorated facade and sends the update
+type counter struct {
+	api.Counter
+	key    string
+	facade *Facade
+}
+
+func (counter *counter) Inc() {
+	counter.Add(1)
+}
+
+func (counter *counter) Dec() {
+	counter.Add(-1)
+}
+
+func (counter *counter) Add(value int64) {
+	counter.Counter.Add(value)
+	counter.facade.send(counter.key, nil, strconv.FormatInt(value, 10), "c", true)
+}
+
+// timer records in the timer of the decorated facade and sends the timings
+type timer struct {
+	api.Timer
+	key    string
+	facade *Facade
+}
+
+func (timer *timer) Record(millis float64) {
+	timer.Timer.Record(millis)
+	timer.facade.send(timer.key, nil, formatFloat(millis), "ms", true)
+}
+
+func (timer *timer) Time(subject func()) float64 {
+	millis := timer.Timer.Time(subject)
+	timer.facade.send(timer.key, nil, formatFloat(millis), "ms", true)
+	return millis
+}
+
+func (timer *timer) Start() api.TimerContext {
+	return &timerContext{timer.Timer.Start(), timer}
+}
+
+type timerContext struct {
+	api.TimerContext
+	timer *timer
+}
+
+func (context *timerContext) Stop() float64 {
+	millis := context.TimerContext.Stop()
+	context.timer.facade.send(context.timer.key, nil, formatFloat(millis), "ms", true)
+	return millis
+}
+
+// histogram records in the histogram of the decorated facade and sends the samples
+type histogram struct {
+	api.Histogram
+	key    string
+	facade *Facade
+}
+
+func (histogram *histogram) Record(value float64) {
+	histogram.Histogram.Record(value)
+	histogram.facade.send(histogram.key, nil, formatFloat(value), "h", true)
+}
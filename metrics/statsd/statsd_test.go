@@ -0,0 +1,297 @@
+/*
+ *
+ *     Copyright 2016 Christophe Hesters
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toefel18/go-patan/metrics/api"
+	"github.com/toefel18/go-patan/metrics/lockbased"
+)
+
+// listen starts a local UDP listener that plays the StatsD daemon
+func listen(t *testing.T) net.PacketConn {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("could not listen on udp", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// receive returns the packets that arrive within a short time
+func receive(listener net.PacketConn) []string {
+	var packets []string
+	buffer := make([]byte, 65536)
+	for {
+		listener.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buffer[:n]))
+	}
+}
+
+// receiveLines returns the sorted lines of the packets that arrive within a short time
+func receiveLines(listener net.PacketConn) []string {
+	var lines []string
+	for _, packet := range receive(listener) {
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func newTestFacade(t *testing.T, listener net.PacketConn, config Config) *Facade {
+	config.Address = listener.LocalAddr().String()
+	facade, err := NewFacade(lockbased.NewFacade(lockbased.NewStore()), config)
+	if err != nil {
+		t.Fatal("could not create the statsd facade", err)
+	}
+	t.Cleanup(func() { facade.Close() })
+	return facade
+}
+
+func TestFacadeImplementsApiInterface(t *testing.T) {
+	var _ api.Facade = &Facade{}
+}
+
+func TestNewFacadeWithNilFacade(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewFacade should panic when facade=nil, but no panic")
+		}
+	}()
+	NewFacade(nil, Config{})
+}
+
+func TestNewFacadeInvalidConfig(t *testing.T) {
+	decorated := lockbased.NewFacade(lockbased.NewStore())
+	if _, err := NewFacade(decorated, Config{SampleRate: 1.5}); err == nil {
+		t.Error("a sample rate above 1 should be rejected")
+	}
+	if _, err := NewFacade(decorated, Config{Tags: api.Tags{"env": "prod"}}); err == nil {
+		t.Error("tags without DogStatsD should be rejected")
+	}
+	if _, err := NewFacade(decorated, Config{Address: "no port"}); err == nil {
+		t.Error("an invalid address should be rejected")
+	}
+}
+
+func TestFacadeSendsAndRecords(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{Prefix: "api."})
+	facade.IncrementCounter("requests")
+	facade.AddToCounter("requests", 5)
+	facade.DecrementCounter("requests")
+	facade.AddSample("response.size", 512.5)
+	facade.RecordElapsedTime("db.query", facade.StartStopwatch())
+	facade.SetGauge("queue.depth", 3)
+	facade.SetGauge("temperature", -2)
+	facade.MarkMeter("logins", 2)
+	facade.Counter("handle.counter").Add(4)
+	facade.Timer("handle.timer").Record(12)
+	facade.Histogram("handle.histogram").Record(7)
+	facade.Flush()
+
+	lines := receiveLines(listener)
+	expected := []string{
+		"api.handle.counter:4|c",
+		"api.handle.histogram:7|h",
+		"api.handle.timer:12|ms",
+		"api.logins:2|c",
+		"api.queue.depth:3|g",
+		"api.requests:-1|c",
+		"api.requests:1|c",
+		"api.requests:5|c",
+		"api.response.size:512.5|h",
+		"api.temperature:-2|g",
+		"api.temperature:0|g",
+	}
+	var withoutTimings []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "api.db.query:") {
+			if !strings.HasSuffix(line, "|ms") {
+				t.Error("expected db.query to be sent as a timing, got", line)
+			}
+			continue
+		}
+		withoutTimings = append(withoutTimings, line)
+	}
+	if strings.Join(withoutTimings, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected lines\n%v\nbut got\n%v", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+
+	snapshot := facade.Snapshot()
+	if snapshot.Counters()["requests"] != 5 || snapshot.Counters()["handle.counter"] != 4 {
+		t.Errorf("the decorated facade should record the counters too, got %v", snapshot.Counters())
+	}
+	if snapshot.Samples()["response.size"].SampleCount() != 1 || snapshot.Durations()["handle.timer"].SampleCount() != 1 {
+		t.Error("the decorated facade should record the samples and durations too")
+	}
+}
+
+func TestFacadeMeasureFunc(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{})
+	facade.MeasureFunc("func", func() {})
+	facade.Timer("timer").Time(func() {})
+	facade.Timer("timer").Start().Stop()
+	func() {
+		defer func() { recover() }()
+		facade.MeasureFuncCanPanic("panics", func() { panic("failed") })
+	}()
+	facade.Flush()
+	lines := strings.Join(receiveLines(listener), "\n")
+	if strings.Count(lines, "func:") != 1 || strings.Count(lines, "timer:") != 2 || strings.Count(lines, "panics.panic:") != 1 {
+		t.Errorf("expected a timing for every measurement, got\n%v", lines)
+	}
+	if facade.Snapshot().Durations()["panics.panic"].SampleCount() != 1 {
+		t.Error("the panic should be recorded in the decorated facade")
+	}
+}
+
+func TestFacadeMeasureFuncCtx(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{})
+	facade.MeasureFuncCtx(context.Background(), "query", func(ctx context.Context) error { return errors.New("failed") })
+	facade.RecordElapsedTimeCtx(context.Background(), "query", facade.StartStopwatchCtx(context.Background()), nil)
+	facade.Flush()
+	lines := strings.Join(receiveLines(listener), "\n")
+	if strings.Count(lines, "query.error:") != 1 || strings.Count(lines, "query.ok:") != 1 {
+		t.Errorf("expected a timing for every outcome, got\n%v", lines)
+	}
+}
+
+func TestFacadeMeasureFuncErr(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{})
+	facade.SetErrorClassifier(func(err error) string { return "timeout" })
+	facade.MeasureFuncErr("call", func() error { return nil })
+	facade.MeasureFuncErr("call", func() error { return errors.New("failed") })
+	facade.Flush()
+	lines := strings.Join(receiveLines(listener), "\n")
+	if !strings.Contains(lines, "call.ok:1|c") || !strings.Contains(lines, "call.timeout:1|c") ||
+		strings.Count(lines, "|ms") != 2 || strings.Count(lines, "call.timeout:") != 2 {
+		t.Errorf("expected the outcome counters and timings to be sent, got\n%v", lines)
+	}
+	if facade.Snapshot().Counters()["call.timeout"] != 1 {
+		t.Error("the failure should be recorded in the decorated facade")
+	}
+}
+
+func TestFacadeDogStatsDTags(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{DogStatsD: true, Tags: api.Tags{"env": "prod"}})
+	facade.IncrementCounterWithTags("requests", api.Tags{"status": "200", "method": "GET"})
+	facade.AddSampleWithTags("size", api.Tags{"path": "/a,b"}, 3)
+	facade.IncrementCounter("plain")
+	facade.SetGauge("negative", -1)
+	facade.SetGaugeWithTags("pool", api.Tags{"name": "db"}, 2)
+	facade.MarkMeterWithTags("events", api.Tags{"kind": "login"}, 2)
+	facade.Flush()
+	expected := []string{
+		"events:2|c|#env:prod,kind:login",
+		"negative:-1|g|#env:prod",
+		"plain:1|c|#env:prod",
+		"pool:2|g|#env:prod,name:db",
+		"requests:1|c|#env:prod,method:GET,status:200",
+		"size:3|h|#env:prod,path:/a_b",
+	}
+	if lines := receiveLines(listener); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected lines\n%v\nbut got\n%v", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+	if facade.Snapshot().Counters()["requests{method=GET,status=200}"] != 1 {
+		t.Error("the tagged counter should be recorded in the decorated facade")
+	}
+}
+
+func TestFacadeWithoutDogStatsDLeavesOutTags(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{})
+	facade.IncrementCounterWithTags("requests", api.Tags{"status": "200"})
+	facade.AddToCounter("weird:name|x", 1)
+	facade.Flush()
+	expected := []string{"requests:1|c", "weird_name_x:1|c"}
+	if lines := receiveLines(listener); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected lines %v but got %v", expected, lines)
+	}
+}
+
+func TestFacadeSampleRate(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{SampleRate: 0.5})
+	draws := []float64{0.1, 0.7, 0.4, 0.9}
+	facade.random = func() float64 {
+		draw := draws[0]
+		draws = draws[1:]
+		return draw
+	}
+	for i := 0; i < 4; i++ {
+		facade.IncrementCounter("requests")
+	}
+	facade.SetGauge("gauge", 1)
+	facade.Flush()
+	expected := []string{"gauge:1|g", "requests:1|c|@0.5", "requests:1|c|@0.5"}
+	if lines := receiveLines(listener); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected lines %v but got %v", expected, lines)
+	}
+	if facade.Snapshot().Counters()["requests"] != 4 {
+		t.Error("the decorated facade should record everything regardless of the sample rate")
+	}
+}
+
+func TestFacadeFlushesPeriodically(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{FlushInterval: 10 * time.Millisecond})
+	facade.IncrementCounter("requests")
+	if lines := receiveLines(listener); len(lines) != 1 || lines[0] != "requests:1|c" {
+		t.Error("expected the packet to be sent after the flush interval, got", lines)
+	}
+}
+
+func TestFacadeClose(t *testing.T) {
+	listener := listen(t)
+	facade := newTestFacade(t, listener, Config{FlushInterval: time.Hour})
+	facade.IncrementCounter("requests")
+	if err := facade.Close(); err != nil {
+		t.Error("closing failed", err)
+	}
+	if err := facade.Close(); err != nil {
+		t.Error("closing twice should have no effect but got", err)
+	}
+	if lines := receiveLines(listener); len(lines) != 1 {
+		t.Error("Close should send the waiting recordings, got", lines)
+	}
+	facade.IncrementCounter("requests")
+	if facade.Dropped() != 0 {
+		t.Error("recording after Close should not drop packets until the next flush")
+	}
+	facade.Flush()
+	if facade.Dropped() != 1 {
+		t.Error("a packet sent after Close should be counted as dropped, got", facade.Dropped())
+	}
+}